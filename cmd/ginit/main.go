@@ -16,6 +16,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/direktoren/gecholog/internal/glconfig"
@@ -64,6 +65,7 @@ type executable struct {
 
 	DisableConfigFileMonitoring bool `json:"disable_config_file_monitoring"`
 	DiePromise                  bool `json:"die_promise"`
+	ReloadOnSighup              bool `json:"reload_on_sighup"`
 
 	state  serviceState
 	starts int
@@ -76,7 +78,7 @@ type executable struct {
 }
 
 func (p executable) String() string {
-	return fmt.Sprintf("name:%s file:%s config_command:%s configuration_file:%s additional_arguments:%v validate_command:%s healthy_output:%s disable_config_file_monitoring:%v die_promise:%v ", p.Name, p.File, p.ConfigCommand, p.ConfigurationFile, p.AdditionalArguments, p.ValidateCommand, p.HealthyOutput, p.DisableConfigFileMonitoring, p.DiePromise)
+	return fmt.Sprintf("name:%s file:%s config_command:%s configuration_file:%s additional_arguments:%v validate_command:%s healthy_output:%s disable_config_file_monitoring:%v die_promise:%v reload_on_sighup:%v ", p.Name, p.File, p.ConfigCommand, p.ConfigurationFile, p.AdditionalArguments, p.ValidateCommand, p.HealthyOutput, p.DisableConfigFileMonitoring, p.DiePromise, p.ReloadOnSighup)
 }
 
 func (e executable) Validate() validate.ValidationErrors {
@@ -1031,6 +1033,29 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 
 				}
 
+				if service.ReloadOnSighup && service.state == HEALTHY && service.cmd != nil && service.cmd.Process != nil {
+					// The service reloads the configuration itself, no restart
+					err := service.cmd.Process.Signal(syscall.SIGHUP)
+					if err == nil {
+						logger.Info(
+							"configuration reload signalled",
+							slog.String("child", service.Name),
+						)
+
+						go func() {
+							time.Sleep(1 * time.Second)
+							eventChan <- tick{}
+						}()
+						continue
+					}
+					logger.Warn(
+						"error signalling configuration reload, restarting",
+						slog.String("child", service.Name),
+						slog.Any("error", err),
+					)
+
+				}
+
 				// Begin the controlled restart by sending a graceful shutdown event
 				queuedChan := make(chan struct{})
 				go func(i int) {
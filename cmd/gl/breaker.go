@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half_open"
+)
+
+// circuitBreaker tracks consecutive failures of one outbound url
+type circuitBreaker struct {
+	m sync.Mutex
+
+	url    string
+	config router.CircuitBreaker
+	notify func(url string, from string, to string)
+
+	state    string
+	failures int
+	openedAt time.Time
+	probes   int
+	now      func() time.Time
+}
+
+// Caller holds the lock
+func (cb *circuitBreaker) setState(state string) {
+	if cb.state == state {
+		return
+	}
+	from := cb.state
+	cb.state = state
+	cb.failures = 0
+	cb.probes = 0
+	if state == BREAKER_OPEN {
+		cb.openedAt = cb.now()
+	}
+	if cb.notify != nil {
+		go cb.notify(cb.url, from, state)
+	}
+}
+
+// allow returns false if the call should fail fast
+func (cb *circuitBreaker) allow() bool {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.state == BREAKER_OPEN {
+		if cb.now().Sub(cb.openedAt) < time.Duration(cb.config.OpenDuration)*time.Millisecond {
+			return false
+		}
+		cb.setState(BREAKER_HALF_OPEN)
+	}
+
+	if cb.state == BREAKER_HALF_OPEN {
+		maxProbes := max(cb.config.HalfOpenRequests, 1)
+		if cb.probes >= maxProbes {
+			return false
+		}
+		cb.probes++
+	}
+	return true
+}
+
+func (cb *circuitBreaker) success() {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.state == BREAKER_HALF_OPEN {
+		cb.setState(BREAKER_CLOSED)
+		return
+	}
+	cb.failures = 0
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	switch cb.state {
+	case BREAKER_HALF_OPEN:
+		cb.setState(BREAKER_OPEN)
+	case BREAKER_CLOSED:
+		cb.failures++
+		if cb.failures >= cb.config.FailureThreshold {
+			cb.setState(BREAKER_OPEN)
+		}
+	}
+}
+
+// cancel gives back the probe of a call the client abandoned, the upstream was neither healthy nor failing
+func (cb *circuitBreaker) cancel() {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.state == BREAKER_HALF_OPEN && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+func (cb *circuitBreaker) getState() string {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+	return cb.state
+}
+
+// failFast returns the status code and body used while the breaker is open
+func (cb *circuitBreaker) failFast() (int, string) {
+	statusCode := cb.config.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusServiceUnavailable
+	}
+	body := cb.config.Body
+	if body == "" {
+		body = `{"error":"circuit breaker open"}`
+	}
+	return statusCode, body
+}
+
+// breakerRegistry holds one circuit breaker per outbound url
+type breakerRegistry struct {
+	m        sync.Mutex
+	breakers map[string]*circuitBreaker
+	previous map[string]*circuitBreaker // breakers of the configuration before a reload
+	notify   func(url string, from string, to string)
+}
+
+func newBreakerRegistry(notify func(url string, from string, to string)) *breakerRegistry {
+	return &breakerRegistry{
+		breakers: map[string]*circuitBreaker{},
+		notify:   notify,
+	}
+}
+
+// keep lets the registry reuse the breakers of the previous registry, so a reload keeps
+// the state of the urls whose circuit breaker configuration is unchanged
+func (br *breakerRegistry) keep(previous *breakerRegistry) {
+	previous.m.Lock()
+	defer previous.m.Unlock()
+	br.m.Lock()
+	defer br.m.Unlock()
+
+	br.previous = map[string]*circuitBreaker{}
+	for key, cb := range previous.breakers {
+		br.previous[key] = cb
+	}
+}
+
+// breakerKey drops query parameters, they may contain credentials
+func breakerKey(u *url.URL) string {
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
+}
+
+// get returns the breaker of the url. The first configuration for a url wins
+func (br *breakerRegistry) get(u *url.URL, config router.CircuitBreaker) *circuitBreaker {
+	br.m.Lock()
+	defer br.m.Unlock()
+
+	key := breakerKey(u)
+	cb, exists := br.breakers[key]
+	if exists {
+		if cb.config != config {
+			logger.Warn("circuit breaker already configured for url, keeping first configuration", slog.String("url", key))
+		}
+		return cb
+	}
+	if cb, exists := br.previous[key]; exists && cb.config == config {
+		br.breakers[key] = cb
+		return cb
+	}
+	cb = &circuitBreaker{
+		url:    key,
+		config: config,
+		notify: br.notify,
+		state:  BREAKER_CLOSED,
+		now:    time.Now,
+	}
+	br.breakers[key] = cb
+	return cb
+}
+
+// states returns the current state of all breakers, for isalive
+func (br *breakerRegistry) states() map[string]string {
+	br.m.Lock()
+	defer br.m.Unlock()
+
+	states := map[string]string{}
+	for key, cb := range br.breakers {
+		states[key] = cb.getState()
+	}
+	return states
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_circuitBreaker_States(t *testing.T) {
+	m := sync.Mutex{}
+	changes := []string{}
+	registry := newBreakerRegistry(func(url string, from string, to string) {
+		m.Lock()
+		defer m.Unlock()
+		changes = append(changes, from+">"+to)
+	})
+
+	u, _ := url.Parse("https://example.com/v1/?api-key=secret")
+	cb := registry.get(u, router.CircuitBreaker{
+		FailureThreshold: 2,
+		OpenDuration:     1000,
+	})
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	assert.True(t, cb.allow())
+	cb.failure()
+	assert.Equal(t, BREAKER_CLOSED, cb.getState())
+	cb.failure()
+	assert.Equal(t, BREAKER_OPEN, cb.getState())
+	assert.False(t, cb.allow())
+
+	// Open duration passed, a single probe is let through
+	now = now.Add(2 * time.Second)
+	assert.True(t, cb.allow())
+	assert.Equal(t, BREAKER_HALF_OPEN, cb.getState())
+	assert.False(t, cb.allow())
+
+	cb.failure()
+	assert.Equal(t, BREAKER_OPEN, cb.getState())
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, cb.allow())
+	cb.success()
+	assert.Equal(t, BREAKER_CLOSED, cb.getState())
+
+	assert.Equal(t, map[string]string{"https://example.com/v1/": BREAKER_CLOSED}, registry.states())
+
+	assert.Eventually(t, func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return len(changes) == 5
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_circuitBreaker_SharedPerUrl(t *testing.T) {
+	registry := newBreakerRegistry(nil)
+	u1, _ := url.Parse("https://example.com/v1/?a=1")
+	u2, _ := url.Parse("https://example.com/v1/?a=2")
+	cb1 := registry.get(u1, router.CircuitBreaker{FailureThreshold: 1, OpenDuration: 1})
+	cb2 := registry.get(u2, router.CircuitBreaker{FailureThreshold: 5, OpenDuration: 1})
+	assert.Same(t, cb1, cb2)
+	assert.Equal(t, 1, cb2.config.FailureThreshold)
+}
+
+func Test_breakerRegistry_keep(t *testing.T) {
+	config := router.CircuitBreaker{FailureThreshold: 1, OpenDuration: 60000}
+	previous := newBreakerRegistry(nil)
+	u1, _ := url.Parse("https://a.example.com/v1/")
+	u2, _ := url.Parse("https://b.example.com/v1/")
+	open := previous.get(u1, config)
+	open.failure()
+	other := previous.get(u2, config)
+
+	registry := newBreakerRegistry(nil)
+	registry.keep(previous)
+
+	// Unchanged configuration keeps the open breaker
+	kept := registry.get(u1, config)
+	assert.Same(t, open, kept)
+	assert.Equal(t, BREAKER_OPEN, kept.getState())
+
+	// Changed configuration starts over
+	changed := registry.get(u2, router.CircuitBreaker{FailureThreshold: 3, OpenDuration: 60000})
+	assert.NotSame(t, other, changed)
+	assert.Equal(t, BREAKER_CLOSED, changed.getState())
+
+	assert.Equal(t, map[string]string{"https://a.example.com/v1/": BREAKER_OPEN, "https://b.example.com/v1/": BREAKER_CLOSED}, registry.states())
+}
+
+func Test_standardRequestFunc_CircuitBreaker(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	b := newTargetBalancer(router.OutboundNode{Url: server.URL})
+	b.attachBreakers(newBreakerRegistry(nil), router.CircuitBreaker{
+		FailureThreshold: 1,
+		OpenDuration:     60000,
+		StatusCode:       http.StatusTooManyRequests,
+		Body:             `{"error":"try later"}`,
+	})
+
+	expected := []int{http.StatusInternalServerError, http.StatusTooManyRequests}
+	for _, statusCode := range expected {
+		crw := &GechologResponseWriter{
+			ResponseWriter:     httptest.NewRecorder(),
+			outboundBody:       bytes.NewBufferString(`{}`),
+			outboundHeaders:    http.Header{},
+			inboundBody:        bytes.NewBufferString(""),
+			inboundHeaders:     http.Header{},
+			requestObject:      gechologobject.New(),
+			requestErrorObject: gechologobject.New(),
+			upstreams:          b.candidates(),
+		}
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+		assert.Equal(t, statusCode, crw.inboundStatusCode)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func Test_standardRequestFunc_CircuitBreakerCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	registry := newBreakerRegistry(nil)
+	b := newTargetBalancer(router.OutboundNode{Url: server.URL})
+	b.attachBreakers(registry, router.CircuitBreaker{
+		FailureThreshold: 1,
+		OpenDuration:     60000,
+	})
+
+	crw := &GechologResponseWriter{
+		ResponseWriter:     httptest.NewRecorder(),
+		outboundBody:       bytes.NewBufferString(`{}`),
+		outboundHeaders:    http.Header{},
+		inboundBody:        bytes.NewBufferString(""),
+		inboundHeaders:     http.Header{},
+		requestObject:      gechologobject.New(),
+		requestErrorObject: gechologobject.New(),
+		upstreams:          b.candidates(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+
+	// A client that went away does not trip the breaker
+	u, _ := url.Parse(server.URL)
+	assert.Equal(t, BREAKER_CLOSED, registry.get(u, router.CircuitBreaker{}).getState())
+
+	// and an abandoned probe does not hold the half open breaker
+	cb := &circuitBreaker{state: BREAKER_HALF_OPEN, config: router.CircuitBreaker{HalfOpenRequests: 1}, now: time.Now}
+	assert.True(t, cb.allow())
+	cb.cancel()
+	assert.True(t, cb.allow())
+}
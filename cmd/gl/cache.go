@@ -0,0 +1,327 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+)
+
+const (
+	CACHE_BACKEND_MEMORY = "memory"
+	CACHE_BACKEND_DISK   = "disk"
+)
+
+type cachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+	Expires    time.Time   `json:"expires"`
+}
+
+// responseCache is implemented by the cache backends
+type responseCache interface {
+	get(key string) (cachedResponse, bool)
+	set(key string, response cachedResponse)
+}
+
+func newResponseCache(config router.Cache) (responseCache, error) {
+	switch config.Backend {
+	case CACHE_BACKEND_MEMORY:
+		mc := newMemoryCache(config.MaxEntries)
+		mc.config = config
+		return mc, nil
+	case CACHE_BACKEND_DISK:
+		return newDiskCache(config.Directory, config.MaxEntries)
+	}
+	return nil, fmt.Errorf("unknown cache backend %s", config.Backend)
+}
+
+// keepResponseCache returns the previous memory cache of the router if its configuration is unchanged,
+// so a reload keeps the entries. Otherwise a new cache. Disk caches keep their entries on disk anyway
+func keepResponseCache(previous responseCache, config router.Cache) (responseCache, error) {
+	if mc, ok := previous.(*memoryCache); ok && config.Backend == CACHE_BACKEND_MEMORY &&
+		mc.config.Ttl == config.Ttl && mc.config.MaxEntries == config.MaxEntries && slices.Equal(mc.config.Headers, config.Headers) {
+		return mc, nil
+	}
+	return newResponseCache(config)
+}
+
+// ----------- memory backend -----------------
+
+type memoryCacheEntry struct {
+	key      string
+	response cachedResponse
+}
+
+// memoryCache evicts the least recently used entry when full
+type memoryCache struct {
+	m          sync.Mutex
+	config     router.Cache
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (mc *memoryCache) get(key string) (cachedResponse, bool) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	element, exists := mc.entries[key]
+	if !exists {
+		return cachedResponse{}, false
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.response.Expires) {
+		mc.lru.Remove(element)
+		delete(mc.entries, key)
+		return cachedResponse{}, false
+	}
+	mc.lru.MoveToFront(element)
+	return entry.response, true
+}
+
+func (mc *memoryCache) set(key string, response cachedResponse) {
+	mc.m.Lock()
+	defer mc.m.Unlock()
+
+	if element, exists := mc.entries[key]; exists {
+		element.Value.(*memoryCacheEntry).response = response
+		mc.lru.MoveToFront(element)
+		return
+	}
+	mc.entries[key] = mc.lru.PushFront(&memoryCacheEntry{key: key, response: response})
+	for mc.lru.Len() > mc.maxEntries {
+		oldest := mc.lru.Back()
+		mc.lru.Remove(oldest)
+		delete(mc.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// ----------- disk backend -----------------
+
+// diskCache stores one file per entry and evicts the oldest written entry when full
+type diskCache struct {
+	m          sync.Mutex
+	directory  string
+	maxEntries int
+	written    map[string]time.Time
+}
+
+func newDiskCache(directory string, maxEntries int) (*diskCache, error) {
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &diskCache{
+		directory:  directory,
+		maxEntries: maxEntries,
+		written:    map[string]time.Time{},
+	}
+
+	// Pick up the entries from before a restart
+	files, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		key, isEntry := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !isEntry {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		dc.written[key] = info.ModTime()
+	}
+	dc.evict()
+	return dc, nil
+}
+
+func (dc *diskCache) filename(key string) string {
+	return filepath.Join(dc.directory, key+".json")
+}
+
+// evict removes the oldest entries above maxEntries. Caller holds the lock
+func (dc *diskCache) evict() {
+	if len(dc.written) <= dc.maxEntries {
+		return
+	}
+	keys := make([]string, 0, len(dc.written))
+	for key := range dc.written {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return dc.written[keys[i]].Before(dc.written[keys[j]])
+	})
+	for _, key := range keys[:len(keys)-dc.maxEntries] {
+		os.Remove(dc.filename(key))
+		delete(dc.written, key)
+	}
+}
+
+func (dc *diskCache) get(key string) (cachedResponse, bool) {
+	dc.m.Lock()
+	defer dc.m.Unlock()
+
+	if _, exists := dc.written[key]; !exists {
+		return cachedResponse{}, false
+	}
+
+	response := cachedResponse{}
+	data, err := os.ReadFile(dc.filename(key))
+	if err == nil {
+		err = json.Unmarshal(data, &response)
+	}
+	if err != nil || time.Now().After(response.Expires) {
+		os.Remove(dc.filename(key))
+		delete(dc.written, key)
+		return cachedResponse{}, false
+	}
+	return response, true
+}
+
+func (dc *diskCache) set(key string, response cachedResponse) {
+	dc.m.Lock()
+	defer dc.m.Unlock()
+
+	data, err := json.Marshal(&response)
+	if err != nil {
+		logger.Error("unexpected json.Marshal error", slog.String("context", "cache"), slog.Any("error", err))
+		return
+	}
+
+	// Write and rename so a crash never leaves half an entry
+	tmp := dc.filename(key) + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, dc.filename(key))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		logger.Error("failed to write cache entry", slog.String("directory", dc.directory), slog.Any("error", err))
+		return
+	}
+	dc.written[key] = time.Now()
+	dc.evict()
+}
+
+// ----------- middleware -----------------
+
+// cacheIdentityHeaders are the ingress headers that identify the client of a router: Authorization,
+// the jwt header and the ingress headers. The client certificate is added to the key by cacheKey
+func cacheIdentityHeaders(thisRouter router.Router) []string {
+	headers := []string{"Authorization"}
+	if thisRouter.Ingress.JWT != nil && thisRouter.Ingress.JWT.Header != "" {
+		headers = append(headers, http.CanonicalHeaderKey(thisRouter.Ingress.JWT.Header))
+	}
+	for header := range thisRouter.Ingress.Headers {
+		headers = append(headers, http.CanonicalHeaderKey(header))
+	}
+	sort.Strings(headers)
+	return slices.Compact(headers)
+}
+
+// cacheKey hashes the final outbound request: method, outbound router, sub path, query, payload and
+// the selected headers. The target is left out since it rotates between the targets of the router.
+// The client identity is always part of the key, so one client is never served the answer of another
+func cacheKey(crw *GechologResponseWriter, r *http.Request, headers []string, identityHeaders []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", r.Method, router.ExpandPathParameters(crw.outboundRouter, crw.pathParameters), crw.outboundSubPath, crw.outboundURL.RawQuery)
+
+	fmt.Fprintf(h, "consumer:%q\n", crw.consumer)
+	for _, header := range identityHeaders {
+		fmt.Fprintf(h, "%s:%q\n", header, r.Header.Values(header))
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		fmt.Fprintf(h, "certificate:%x\n", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
+	}
+
+	sortedHeaders := append([]string{}, headers...)
+	sort.Strings(sortedHeaders)
+	for _, header := range sortedHeaders {
+		fmt.Fprintf(h, "%s:%q\n", header, crw.outboundHeaders.Values(header))
+	}
+	h.Write(crw.outboundBody.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheMiddlewareFunc serves stored responses without calling upstream. A nil cache disables it
+func cacheMiddlewareFunc(cache responseCache, config *router.Cache, identityHeaders []string, s *state) func(http.Handler) http.Handler {
+
+	if cache != nil && config == nil {
+		logger.Error("cache config is nil")
+		return nil
+	}
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if cache == nil || crw.mirrorOf != "" {
+				// Shadow requests are neither served from nor stored in the cache
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			key := cacheKey(crw, r, config.Headers, identityHeaders)
+			if cached, hit := cache.get(key); hit {
+				crw.inboundBody.Write(cached.Body)
+				crw.inboundStatusCode = cached.StatusCode
+				for key, values := range cached.Headers {
+					for _, value := range values {
+						crw.inboundHeaders.Add(key, value)
+					}
+				}
+				store.Store(&crw.responseObject, &crw.responseErrorObject, "cache_hit", true)
+				return
+			}
+			store.Store(&crw.responseObject, &crw.responseErrorObject, "cache_hit", false)
+
+			next.ServeHTTP(crw, r)
+
+			if crw.inboundStatusCode != http.StatusOK || crw.streamed {
+				return
+			}
+			cache.set(key, cachedResponse{
+				StatusCode: crw.inboundStatusCode,
+				Headers:    crw.inboundHeaders.Clone(),
+				Body:       append([]byte{}, crw.inboundBody.Bytes()...),
+				Expires:    time.Now().Add(time.Duration(config.Ttl) * time.Second),
+			})
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_memoryCache(t *testing.T) {
+	mc := newMemoryCache(2)
+	valid := time.Now().Add(time.Minute)
+
+	mc.set("a", cachedResponse{Body: []byte("a"), Expires: valid})
+	mc.set("b", cachedResponse{Body: []byte("b"), Expires: valid})
+	_, hit := mc.get("a") // a is now most recently used
+	assert.True(t, hit)
+
+	mc.set("c", cachedResponse{Body: []byte("c"), Expires: valid})
+	_, hit = mc.get("b")
+	assert.False(t, hit)
+	_, hit = mc.get("a")
+	assert.True(t, hit)
+
+	mc.set("d", cachedResponse{Body: []byte("d"), Expires: time.Now().Add(-time.Second)})
+	_, hit = mc.get("d")
+	assert.False(t, hit)
+}
+
+func Test_diskCache(t *testing.T) {
+	directory := t.TempDir()
+	dc, err := newDiskCache(directory, 2)
+	assert.NoError(t, err)
+
+	valid := time.Now().Add(time.Minute)
+	dc.set("a", cachedResponse{StatusCode: 200, Headers: http.Header{"Content-Type": []string{"application/json"}}, Body: []byte(`{"a":1}`), Expires: valid})
+	time.Sleep(10 * time.Millisecond)
+	dc.set("b", cachedResponse{Body: []byte("b"), Expires: valid})
+	time.Sleep(10 * time.Millisecond)
+	dc.set("c", cachedResponse{Body: []byte("c"), Expires: valid})
+
+	_, hit := dc.get("a")
+	assert.False(t, hit)
+
+	// Survives a restart
+	restarted, err := newDiskCache(directory, 2)
+	assert.NoError(t, err)
+	response, hit := restarted.get("c")
+	assert.True(t, hit)
+	assert.Equal(t, []byte("c"), response.Body)
+
+	restarted.set("e", cachedResponse{Body: []byte("e"), Expires: time.Now().Add(-time.Second)})
+	_, hit = restarted.get("e")
+	assert.False(t, hit)
+}
+
+func Test_cacheMiddlewareFunc(t *testing.T) {
+	calls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		crw := w.(*GechologResponseWriter)
+		crw.inboundBody.WriteString(`{"answer":42}`)
+		crw.inboundStatusCode = http.StatusOK
+		crw.inboundHeaders.Set("Content-Type", "application/json")
+	})
+
+	config := &router.Cache{Backend: CACHE_BACKEND_MEMORY, Ttl: 60, MaxEntries: 10, Headers: []string{"Api-Key"}}
+	middleware := cacheMiddlewareFunc(newMemoryCache(10), config, []string{"Authorization"}, &state{})
+	handler := middleware(upstream)
+
+	call := func(payload string, apiKey string, mirrorOf string) *GechologResponseWriter {
+		crw := &GechologResponseWriter{
+			ResponseWriter:      httptest.NewRecorder(),
+			outboundBody:        bytes.NewBufferString(payload),
+			outboundHeaders:     http.Header{"Api-Key": []string{apiKey}},
+			outboundURL:         url.URL{Scheme: "https", Host: "example.com", Path: "/v1/"},
+			outboundRouter:      "/service/standard/",
+			inboundBody:         bytes.NewBufferString(""),
+			inboundHeaders:      http.Header{},
+			responseObject:      gechologobject.New(),
+			responseErrorObject: gechologobject.New(),
+			mirrorOf:            mirrorOf,
+		}
+		req, _ := http.NewRequest("POST", "/", nil)
+		handler.ServeHTTP(crw, req)
+		return crw
+	}
+
+	// Shadow requests are not stored
+	call(`{"temperature":0}`, "one", "TST00001_1700000000000000000_1_0")
+	assert.Equal(t, 1, calls)
+
+	first := call(`{"temperature":0}`, "one", "")
+	hitRaw, _ := first.responseObject.GetField("cache_hit")
+	assert.Equal(t, "false", string(hitRaw))
+
+	second := call(`{"temperature":0}`, "one", "")
+	hitRaw, _ = second.responseObject.GetField("cache_hit")
+	assert.Equal(t, "true", string(hitRaw))
+	assert.Equal(t, `{"answer":42}`, second.inboundBody.String())
+	assert.Equal(t, "application/json", second.inboundHeaders.Get("Content-Type"))
+	assert.Equal(t, 2, calls)
+
+	// Nor served from the cache
+	call(`{"temperature":0}`, "one", "TST00001_1700000000000000000_1_0")
+	assert.Equal(t, 3, calls)
+
+	call(`{"temperature":0}`, "two", "")
+	call(`{"temperature":1}`, "one", "")
+	assert.Equal(t, 5, calls)
+}
+
+func Test_keepResponseCache(t *testing.T) {
+	config := router.Cache{Backend: CACHE_BACKEND_MEMORY, Ttl: 60, MaxEntries: 10, Headers: []string{"Api-Key"}}
+	previous, err := newResponseCache(config)
+	assert.NoError(t, err)
+	previous.set("k", cachedResponse{StatusCode: http.StatusOK, Expires: time.Now().Add(time.Minute)})
+
+	kept, err := keepResponseCache(previous, config)
+	assert.NoError(t, err)
+	assert.Same(t, previous, kept)
+	_, hit := kept.get("k")
+	assert.True(t, hit)
+
+	config.Headers = []string{"Api-Key", "Model"}
+	changed, err := keepResponseCache(previous, config)
+	assert.NoError(t, err)
+	assert.NotSame(t, previous, changed)
+	_, hit = changed.get("k")
+	assert.False(t, hit)
+
+	created, err := keepResponseCache(nil, config)
+	assert.NoError(t, err)
+	assert.NotNil(t, created)
+}
+
+func Test_cacheMiddlewareFunc_BadArgs(t *testing.T) {
+	assert.Nil(t, cacheMiddlewareFunc(newMemoryCache(1), nil, nil, &state{}))
+	assert.Nil(t, cacheMiddlewareFunc(nil, nil, nil, nil))
+	assert.NotNil(t, cacheMiddlewareFunc(nil, nil, nil, &state{}))
+}
+
+func Test_cacheIdentityHeaders(t *testing.T) {
+	r := router.Router{Ingress: router.IngressNode{Headers: protectedheader.ProtectedHeader{"api-key": {"regex:.+"}, "Authorization": {"regex:.+"}}}}
+	assert.Equal(t, []string{"Api-Key", "Authorization"}, cacheIdentityHeaders(r))
+	assert.Equal(t, []string{"Authorization"}, cacheIdentityHeaders(router.Router{}))
+
+	// A jwt in a custom header identifies the client
+	r = router.Router{Ingress: router.IngressNode{JWT: &router.JWTRequirement{Header: "x-id-token"}}}
+	assert.Equal(t, []string{"Authorization", "X-Id-Token"}, cacheIdentityHeaders(r))
+}
+
+func Test_cacheKey(t *testing.T) {
+	type request struct {
+		target         string
+		outboundRouter string
+		pathParameters map[string]string
+		consumer       string
+		apiKey         string
+		idToken        string
+		certificate    []byte
+	}
+	base := request{
+		target:         "https://a.example.com/v1/",
+		outboundRouter: "/openai/{deployment}/",
+		pathParameters: map[string]string{"deployment": "gpt-4o"},
+		consumer:       "team-a",
+		apiKey:         "one",
+		idToken:        "token-a",
+		certificate:    []byte("certificate-a"),
+	}
+	key := func(rq request) string {
+		u, _ := url.Parse(rq.target + "chat/completions")
+		crw := &GechologResponseWriter{
+			outboundBody:    bytes.NewBufferString(`{"temperature":0}`),
+			outboundHeaders: http.Header{},
+			outboundURL:     *u,
+			outboundRouter:  rq.outboundRouter,
+			outboundSubPath: "chat/completions",
+			pathParameters:  rq.pathParameters,
+			consumer:        rq.consumer,
+		}
+		r := httptest.NewRequest(http.MethodPost, "/openai/gpt-4o/chat/completions", nil)
+		r.Header.Set("Api-Key", rq.apiKey)
+		r.Header.Set("X-Id-Token", rq.idToken)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: rq.certificate}}}
+		return cacheKey(crw, r, nil, []string{"Api-Key", "Authorization", "X-Id-Token"})
+	}
+
+	tests := []struct {
+		name     string
+		change   func(rq *request)
+		expected bool // same key as the base request
+	}{
+		{name: "other target of the router", change: func(rq *request) { rq.target = "https://b.example.com/v1/" }, expected: true},
+		{name: "other router", change: func(rq *request) { rq.outboundRouter = "/anthropic/{deployment}/" }, expected: false},
+		{name: "other path parameter", change: func(rq *request) { rq.pathParameters = map[string]string{"deployment": "gpt-4o-mini"} }, expected: false},
+		{name: "other consumer", change: func(rq *request) { rq.consumer = "team-b" }, expected: false},
+		{name: "other ingress key", change: func(rq *request) { rq.apiKey = "two" }, expected: false},
+		{name: "other jwt", change: func(rq *request) { rq.idToken = "token-b" }, expected: false},
+		{name: "other client certificate", change: func(rq *request) { rq.certificate = []byte("certificate-b") }, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := base
+			tt.change(&rq)
+			assert.Equal(t, tt.expected, key(base) == key(rq))
+		})
+	}
+}
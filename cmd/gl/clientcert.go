@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+)
+
+// ingressClientAuth maps client_auth to the tls client authentication
+func ingressClientAuth(clientAuth string) tls.ClientAuthType {
+	switch clientAuth {
+	case "request":
+		return tls.VerifyClientCertIfGiven
+	case "require":
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// Identity of a verified client certificate, written to the request log
+type clientIdentity struct {
+	Subject           string   `json:"subject"`
+	CommonName        string   `json:"common_name"`
+	SANs              []string `json:"sans"`
+	Issuer            string   `json:"issuer"`
+	FingerprintSHA256 string   `json:"fingerprint_sha256"`
+}
+
+func newClientIdentity(cert *x509.Certificate) clientIdentity {
+	sans := []string{}
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return clientIdentity{
+		Subject:           cert.Subject.String(),
+		CommonName:        cert.Subject.CommonName,
+		SANs:              sans,
+		Issuer:            cert.Issuer.String(),
+		FingerprintSHA256: hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// verifiedClientCertificate returns the leaf of the verified chain, nil if there is none
+func verifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// clientCertificateMatcher holds the compiled patterns of a router
+type clientCertificateMatcher struct {
+	subjects []*regexp.Regexp
+	sans     []*regexp.Regexp
+}
+
+// newClientCertificateMatcher returns nil if the router does not require a client certificate
+func newClientCertificateMatcher(requirement *router.ClientCertificateRequirement) (*clientCertificateMatcher, error) {
+	if requirement == nil {
+		return nil, nil
+	}
+	subjects, sans, err := requirement.Patterns()
+	if err != nil {
+		return nil, err
+	}
+	return &clientCertificateMatcher{subjects: subjects, sans: sans}, nil
+}
+
+func (m *clientCertificateMatcher) allowed(identity clientIdentity) bool {
+	if len(m.subjects) == 0 && len(m.sans) == 0 {
+		return true
+	}
+	for _, re := range m.subjects {
+		if re.MatchString(identity.CommonName) {
+			return true
+		}
+	}
+	for _, re := range m.sans {
+		for _, san := range identity.SANs {
+			if re.MatchString(san) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientCertificateMiddlewareFunc logs the verified client identity and enforces the patterns of the router. A nil matcher only logs
+func clientCertificateMiddlewareFunc(matcher *clientCertificateMatcher, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			cert := verifiedClientCertificate(r)
+			if cert == nil {
+				if matcher != nil {
+					crw.egressBody.Write([]byte(`{"error":"unauthorized client certificate missing"}`))
+					crw.egressStatusCode = http.StatusUnauthorized
+
+					crw.requestErrorObject.AssignField("client_certificate", "no verified client certificate")
+					return
+				}
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			identity := newClientIdentity(cert)
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "client_certificate", identity)
+
+			if matcher != nil && !matcher.allowed(identity) {
+				crw.egressBody.Write([]byte(`{"error":"forbidden"}`))
+				crw.egressStatusCode = http.StatusForbidden
+
+				crw.requestErrorObject.AssignField("client_certificate", "client certificate not allowed on router")
+				logger.Warn(
+					"client certificate not allowed on router",
+					slog.String("transaction_id", crw.transactionID),
+					slog.String("subject", identity.Subject),
+				)
+				return
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
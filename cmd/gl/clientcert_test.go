@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func testClientCertificate(t *testing.T, commonName string, dnsNames []string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Coburn"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func Test_clientCertificateMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		requirement      *router.ClientCertificateRequirement
+		commonName       string
+		dnsNames         []string
+		noCertificate    bool
+		expectedNext     bool
+		expectedStatus   int
+		expectedIdentity bool
+	}{
+		{
+			name:          "no requirement and no certificate",
+			noCertificate: true,
+			expectedNext:  true,
+		},
+		{
+			name:             "no requirement logs identity",
+			commonName:       "batch-job",
+			expectedNext:     true,
+			expectedIdentity: true,
+		},
+		{
+			name:           "certificate missing",
+			requirement:    &router.ClientCertificateRequirement{},
+			noCertificate:  true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:             "any verified certificate",
+			requirement:      &router.ClientCertificateRequirement{},
+			commonName:       "batch-job",
+			expectedNext:     true,
+			expectedIdentity: true,
+		},
+		{
+			name:             "subject matches",
+			requirement:      &router.ClientCertificateRequirement{Subjects: []string{"^batch-"}},
+			commonName:       "batch-job",
+			expectedNext:     true,
+			expectedIdentity: true,
+		},
+		{
+			name:             "san matches",
+			requirement:      &router.ClientCertificateRequirement{Subjects: []string{"^batch-"}, SANs: []string{`\.svc\.cluster\.local$`}},
+			commonName:       "chat-ui",
+			dnsNames:         []string{"chat-ui.apps.svc.cluster.local"},
+			expectedNext:     true,
+			expectedIdentity: true,
+		},
+		{
+			name:             "no pattern matches",
+			requirement:      &router.ClientCertificateRequirement{Subjects: []string{"^batch-"}, SANs: []string{`\.svc\.cluster\.local$`}},
+			commonName:       "chat-ui",
+			dnsNames:         []string{"chat-ui.example.com"},
+			expectedStatus:   http.StatusForbidden,
+			expectedIdentity: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := newClientCertificateMatcher(tt.requirement)
+			assert.NoError(t, err)
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+			var cert *x509.Certificate
+			if !tt.noCertificate {
+				cert = testClientCertificate(t, tt.commonName, tt.dnsNames)
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			clientCertificateMiddlewareFunc(matcher, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			assert.Equal(t, tt.expectedNext, nextCalled)
+			if !tt.expectedNext {
+				assert.Equal(t, tt.expectedStatus, crw.egressStatusCode)
+			}
+
+			raw, err := crw.requestObject.GetField("client_certificate")
+			if !tt.expectedIdentity {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			identity := clientIdentity{}
+			assert.NoError(t, json.Unmarshal(raw, &identity))
+			assert.Equal(t, tt.commonName, identity.CommonName)
+			assert.Equal(t, "CN="+tt.commonName+",O=Coburn", identity.Subject)
+			assert.Len(t, identity.FingerprintSHA256, 64)
+			assert.Equal(t, len(tt.dnsNames), len(identity.SANs))
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ENCODING_GZIP    = "gzip"
+	ENCODING_BROTLI  = "br"
+	ENCODING_DEFLATE = "deflate"
+	ENCODING_ZSTD    = "zstd"
+)
+
+// Compression of egress responses. Encodings are in order of preference, bodies smaller than MinBytes are not compressed
+type compressionConfig struct {
+	Encodings []string `json:"encodings" validate:"gt=0,unique,dive,oneof=gzip br"`
+	MinBytes  int      `json:"min_bytes" validate:"min=0"`
+}
+
+func (c *compressionConfig) String() string {
+	return fmt.Sprintf("encodings:%v min_bytes:%d", c.Encodings, c.MinBytes)
+}
+
+// decodedBody returns a reader of the decoded body for the content encodings gl can read.
+// Returns the body as is and an empty encoding for other encodings
+func decodedBody(header http.Header, body io.ReadCloser) (io.ReadCloser, string, error) {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	switch encoding {
+	case ENCODING_GZIP, "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, encoding, err
+		}
+		return readCloser{reader, body}, encoding, nil
+	case ENCODING_DEFLATE:
+		// deflate is the zlib format, some servers send raw deflate
+		buffered := bufio.NewReader(body)
+		header, _ := buffered.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, encoding, err
+			}
+			return readCloser{reader, body}, encoding, nil
+		}
+		return readCloser{flate.NewReader(buffered), body}, encoding, nil
+	case ENCODING_BROTLI:
+		return readCloser{brotli.NewReader(body), body}, encoding, nil
+	case ENCODING_ZSTD:
+		decoder, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, encoding, err
+		}
+		return readCloser{decoder.IOReadCloser(), body}, encoding, nil
+	}
+	return body, "", nil
+}
+
+// readCloser reads the decoded body and closes the original body
+type readCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (rc readCloser) Close() error {
+	if closer, ok := rc.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return rc.body.Close()
+}
+
+// negotiateEncoding picks the first offered encoding the client accepts. Empty if none is accepted
+func negotiateEncoding(acceptEncoding string, offered []string) string {
+	accepted := map[string]float64{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q
+	}
+	for _, encoding := range offered {
+		q, exists := accepted[encoding]
+		if !exists {
+			q, exists = accepted["*"]
+		}
+		if exists && q > 0 {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressBody encodes b with gzip or br
+func compressBody(encoding string, b []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	var writer io.WriteCloser
+	switch encoding {
+	case ENCODING_GZIP:
+		writer = gzip.NewWriter(buffer)
+	case ENCODING_BROTLI:
+		writer = brotli.NewWriter(buffer)
+	default:
+		return nil, fmt.Errorf("encoding %s is not supported", encoding)
+	}
+	_, err := writer.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// compressEgress compresses the egress body when the client accepts one of the configured encodings. Returns the encoding used
+func compressEgress(compression *compressionConfig, crw *GechologResponseWriter, r *http.Request) string {
+	if compression == nil || crw.egressBody.Len() == 0 || crw.egressBody.Len() < compression.MinBytes {
+		return ""
+	}
+	if crw.Header().Get("Content-Encoding") != "" || crw.egressStatusCode == http.StatusNoContent || crw.egressStatusCode == http.StatusNotModified {
+		return ""
+	}
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compression.Encodings)
+	if encoding == "" {
+		return ""
+	}
+	compressed, err := compressBody(encoding, crw.egressBody.Bytes())
+	if err != nil {
+		logger.Error("failed to compress egress body", slog.Any("error", err))
+		return ""
+	}
+	crw.egressBody.Reset()
+	crw.egressBody.Write(compressed)
+	crw.Header().Set("Content-Encoding", encoding)
+	if !slices.Contains(crw.Header().Values("Vary"), "Accept-Encoding") {
+		crw.Header().Add("Vary", "Accept-Encoding")
+	}
+	return encoding
+}
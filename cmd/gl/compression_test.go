@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const compressionTestPayload = `{"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`
+
+func encodeTestPayload(t *testing.T, encoding string) []byte {
+	buffer := &bytes.Buffer{}
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(buffer)
+	case "deflate":
+		writer = zlib.NewWriter(buffer)
+	case "raw deflate":
+		writer, _ = flate.NewWriter(buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(buffer)
+	case "zstd":
+		writer, _ = zstd.NewWriter(buffer)
+	}
+	_, err := writer.Write([]byte(compressionTestPayload))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func Test_decodedBody(t *testing.T) {
+	tests := []struct {
+		name             string
+		contentEncoding  string
+		encoding         string
+		expectedEncoding string
+	}{
+		{name: "gzip", contentEncoding: "gzip", encoding: "gzip", expectedEncoding: "gzip"},
+		{name: "deflate", contentEncoding: "deflate", encoding: "deflate", expectedEncoding: "deflate"},
+		{name: "raw deflate", contentEncoding: "deflate", encoding: "raw deflate", expectedEncoding: "deflate"},
+		{name: "br", contentEncoding: "br", encoding: "br", expectedEncoding: "br"},
+		{name: "zstd", contentEncoding: "zstd", encoding: "zstd", expectedEncoding: "zstd"},
+		{name: "identity", contentEncoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(compressionTestPayload)
+			if tt.encoding != "" {
+				body = encodeTestPayload(t, tt.encoding)
+			}
+			header := http.Header{}
+			if tt.contentEncoding != "" {
+				header.Set("Content-Encoding", tt.contentEncoding)
+			}
+
+			decoded, encoding, err := decodedBody(header, io.NopCloser(bytes.NewReader(body)))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedEncoding, encoding)
+			b, err := io.ReadAll(decoded)
+			assert.NoError(t, err)
+			assert.Equal(t, compressionTestPayload, string(b))
+			assert.NoError(t, decoded.Close())
+		})
+	}
+
+	_, _, err := decodedBody(http.Header{"Content-Encoding": {"gzip"}}, io.NopCloser(bytes.NewReader([]byte("not gzip"))))
+	assert.Error(t, err)
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	offered := []string{"br", "gzip"}
+	for acceptEncoding, expected := range map[string]string{
+		"":                   "",
+		"gzip":               "gzip",
+		"gzip, deflate, br":  "br",
+		"br;q=0, gzip;q=0.5": "gzip",
+		"*":                  "br",
+		"identity":           "",
+		"GZIP":               "gzip",
+		"deflate, *;q=0":     "",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding, offered), acceptEncoding)
+	}
+}
+
+func Test_standardRequestFunc_Compressed(t *testing.T) {
+	for _, encoding := range []string{"gzip", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", encoding)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(encodeTestPayload(t, encoding))
+			}))
+			defer server.Close()
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:      httptest.NewRecorder(),
+				outboundBody:        bytes.NewBufferString(`{}`),
+				outboundHeaders:     http.Header{"Accept-Encoding": {"gzip, br"}},
+				inboundBody:         bytes.NewBufferString(""),
+				inboundHeaders:      http.Header{},
+				requestObject:       gechologobject.New(),
+				requestErrorObject:  gechologobject.New(),
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+				upstreams:           newTargetBalancer(router.OutboundNode{Url: server.URL}).candidates(),
+			}
+			standardRequestFunc(http.DefaultClient).ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			assert.Equal(t, http.StatusOK, crw.inboundStatusCode)
+			assert.JSONEq(t, compressionTestPayload, crw.inboundBody.String())
+			assert.Empty(t, crw.inboundHeaders.Get("Content-Encoding"))
+			logged, err := crw.responseObject.GetField("inbound_content_encoding")
+			assert.NoError(t, err)
+			assert.Equal(t, `"`+encoding+`"`, string(logged))
+		})
+	}
+}
+
+func Test_egressResponseMiddleware_Compression(t *testing.T) {
+	tests := []struct {
+		name             string
+		compression      *compressionConfig
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{name: "not configured", acceptEncoding: "gzip"},
+		{name: "gzip", compression: &compressionConfig{Encodings: []string{"gzip"}}, acceptEncoding: "gzip, deflate", expectedEncoding: "gzip"},
+		{name: "br preferred", compression: &compressionConfig{Encodings: []string{"br", "gzip"}}, acceptEncoding: "gzip, br", expectedEncoding: "br"},
+		{name: "not accepted", compression: &compressionConfig{Encodings: []string{"br"}}, acceptEncoding: "gzip"},
+		{name: "below min bytes", compression: &compressionConfig{Encodings: []string{"gzip"}, MinBytes: 1024}, acceptEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			crw := &GechologResponseWriter{
+				ResponseWriter:      recorder,
+				egressBody:          bytes.NewBufferString(compressionTestPayload),
+				egressHeaders:       http.Header{"Content-Type": {"application/json"}},
+				egressStatusCode:    http.StatusOK,
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			egressResponseMiddlewareFunc(tt.compression, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			assert.Equal(t, tt.expectedEncoding, recorder.Header().Get("Content-Encoding"))
+			body := io.NopCloser(recorder.Body)
+			decoded, _, err := decodedBody(recorder.Header(), body)
+			assert.NoError(t, err)
+			b, _ := io.ReadAll(decoded)
+			assert.Equal(t, compressionTestPayload, string(b))
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/direktoren/gecholog/internal/store"
+)
+
+// Header carrying the consumer key when consumer_header is not set
+const DEFAULT_CONSUMER_HEADER = "Api-Key"
+
+// A named API consumer. key_hashes are sha256 hex digests of keys. No routers means all routers
+type consumer struct {
+	Name      string   `json:"name" validate:"required,alphanumdot"`
+	Keys      []string `json:"keys,omitempty" validate:"omitempty,unique,dive,required,ascii"`
+	KeyHashes []string `json:"key_hashes,omitempty" validate:"omitempty,unique,dive,len=64,hexadecimal,lowercase"`
+	Routers   []string `json:"routers,omitempty" validate:"omitempty,unique,dive,router"`
+}
+
+func (c *consumer) String() string {
+	// Keys are secret
+	return fmt.Sprintf("name:%s keys:%d key_hashes:%d routers:%v", c.Name, len(c.Keys), len(c.KeyHashes), c.Routers)
+}
+
+// consumerIndex finds the consumer by the sha256 of its key
+type consumerIndex struct {
+	header string
+	byHash map[string]*consumer
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newConsumerIndex returns nil if no consumers are configured
+func newConsumerIndex(header string, consumers []consumer, routers []string) (*consumerIndex, error) {
+	if len(consumers) == 0 {
+		return nil, nil
+	}
+	if header == "" {
+		header = DEFAULT_CONSUMER_HEADER
+	}
+
+	index := &consumerIndex{
+		header: http.CanonicalHeaderKey(header),
+		byHash: map[string]*consumer{},
+	}
+	for i := range consumers {
+		c := &consumers[i]
+		if len(c.Keys) == 0 && len(c.KeyHashes) == 0 {
+			return nil, fmt.Errorf("consumer %s has no keys", c.Name)
+		}
+		for _, path := range c.Routers {
+			if !slices.Contains(routers, path) {
+				return nil, fmt.Errorf("consumer %s uses unknown router %s", c.Name, path)
+			}
+		}
+
+		hashes := slices.Clone(c.KeyHashes)
+		for _, key := range c.Keys {
+			hashes = append(hashes, hashKey(key))
+		}
+		for _, hash := range hashes {
+			if other, exists := index.byHash[hash]; exists && other != c {
+				return nil, fmt.Errorf("consumers %s and %s share a key", other.Name, c.Name)
+			}
+			index.byHash[hash] = c
+		}
+	}
+	return index, nil
+}
+
+// lookup returns the consumer of the key in the header, nil if unknown
+func (ci *consumerIndex) lookup(h http.Header) *consumer {
+	key := h.Get(ci.header)
+	if ci.header == "Authorization" {
+		key = strings.TrimPrefix(key, "Bearer ")
+	}
+	if key == "" {
+		return nil
+	}
+	return ci.byHash[hashKey(key)]
+}
+
+// allowed is true if the consumer may use the router
+func (c *consumer) allowed(path string) bool {
+	return len(c.Routers) == 0 || slices.Contains(c.Routers, path)
+}
+
+// consumerMiddlewareFunc identifies the consumer of the request. A nil index disables it
+func consumerMiddlewareFunc(index *consumerIndex, path string, s *state) func(http.Handler) http.Handler {
+
+	if path == "" {
+		logger.Error("path is empty")
+		return nil
+	}
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if index == nil {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			c := index.lookup(r.Header)
+			if c == nil {
+				crw.egressBody.Write([]byte(`{"error":"unauthorized consumer"}`))
+				crw.egressStatusCode = http.StatusUnauthorized
+
+				crw.requestErrorObject.AssignField("consumer", "unknown key in "+index.header)
+				return
+			}
+			crw.consumer = c.Name
+			// In the request so processors can key on it
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "consumer", c.Name)
+
+			if !c.allowed(path) {
+				crw.egressBody.Write([]byte(`{"error":"forbidden"}`))
+				crw.egressStatusCode = http.StatusForbidden
+
+				crw.requestErrorObject.AssignField("consumer", "consumer "+c.Name+" may not use "+path)
+				logger.Warn(
+					"consumer not allowed on router",
+					slog.String("transaction_id", crw.transactionID),
+					slog.String("consumer", c.Name),
+					slog.String("path", path),
+				)
+				return
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newConsumerIndex(t *testing.T) {
+	routers := []string{"/service/standard/", "/service/capped/"}
+
+	tests := []struct {
+		name      string
+		consumers []consumer
+		wantNil   bool
+		wantErr   bool
+	}{
+		{
+			name:    "no consumers",
+			wantNil: true,
+		},
+		{
+			name: "keys and hashes",
+			consumers: []consumer{
+				{Name: "team_a", Keys: []string{"key-a"}},
+				{Name: "team_b", KeyHashes: []string{hashKey("key-b")}, Routers: []string{"/service/capped/"}},
+			},
+		},
+		{
+			name:      "no keys",
+			consumers: []consumer{{Name: "team_a"}},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown router",
+			consumers: []consumer{{Name: "team_a", Keys: []string{"key-a"}, Routers: []string{"/service/other/"}}},
+			wantErr:   true,
+		},
+		{
+			name: "shared key",
+			consumers: []consumer{
+				{Name: "team_a", Keys: []string{"key-a"}},
+				{Name: "team_b", KeyHashes: []string{hashKey("key-a")}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := newConsumerIndex("", tt.consumers, routers)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantNil, index == nil)
+		})
+	}
+}
+
+func Test_consumerMiddleware(t *testing.T) {
+	consumers := []consumer{
+		{Name: "team_a", Keys: []string{"key-a"}},
+		{Name: "team_b", KeyHashes: []string{hashKey("key-b")}, Routers: []string{"/service/capped/"}},
+	}
+
+	tests := []struct {
+		name             string
+		header           string
+		key              string
+		expectedNext     bool
+		expectedStatus   int
+		expectedConsumer string
+	}{
+		{
+			name:             "known key",
+			key:              "key-a",
+			expectedNext:     true,
+			expectedStatus:   http.StatusOK,
+			expectedConsumer: "team_a",
+		},
+		{
+			name:           "unknown key",
+			key:            "key-c",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing key",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:             "router not allowed",
+			key:              "key-b",
+			expectedStatus:   http.StatusForbidden,
+			expectedConsumer: "team_b",
+		},
+		{
+			name:             "bearer token",
+			header:           "Authorization",
+			key:              "Bearer key-a",
+			expectedNext:     true,
+			expectedStatus:   http.StatusOK,
+			expectedConsumer: "team_a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = DEFAULT_CONSUMER_HEADER
+			}
+			index, err := newConsumerIndex(header, consumers, []string{"/service/standard/", "/service/capped/"})
+			assert.NoError(t, err)
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+			if tt.key != "" {
+				r.Header.Set(header, tt.key)
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			consumerMiddlewareFunc(index, "/service/standard/", &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			assert.Equal(t, tt.expectedNext, nextCalled)
+			assert.Equal(t, tt.expectedStatus, crw.egressStatusCode)
+			assert.Equal(t, tt.expectedConsumer, crw.consumer)
+		})
+	}
+}
+
+func Test_consumerMiddleware_Processors(t *testing.T) {
+	received := []byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	p := processorconfiguration.ProcessorConfiguration{
+		Name:               "tokencounter",
+		InputFieldsInclude: []string{"consumer"},
+		Http:               &processorconfiguration.HttpTransport{Url: server.URL},
+		Timeout:            1000,
+	}
+	caller := &processorCaller{clients: map[*processorconfiguration.HttpTransport]*http.Client{p.Http: http.DefaultClient}}
+	index, err := newConsumerIndex(DEFAULT_CONSUMER_HEADER, []consumer{{Name: "team_a", Keys: []string{"key-a"}}}, []string{"/service/standard/"})
+	assert.NoError(t, err)
+
+	crw := &GechologResponseWriter{
+		ResponseWriter:           httptest.NewRecorder(),
+		egressBody:               bytes.NewBufferString(""),
+		egressStatusCode:         http.StatusOK,
+		requestObject:            gechologobject.New(),
+		requestErrorObject:       gechologobject.New(),
+		processorLogsRequestSync: map[string]processorLog{},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+	r.Header.Set(DEFAULT_CONSUMER_HEADER, "key-a")
+
+	s := &state{m: &sync.Mutex{}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	consumerMiddlewareFunc(index, "/service/standard/", s)(
+		requestProcessorMiddlewareFunc(context.Background(), caller, []processorconfiguration.ProcessorConfiguration{p}, s)(next),
+	).ServeHTTP(crw, r)
+
+	input := map[string]json.RawMessage{}
+	assert.NoError(t, json.Unmarshal(received, &input))
+	assert.JSONEq(t, `"team_a"`, string(input["consumer"]))
+}
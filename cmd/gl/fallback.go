@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/direktoren/gecholog/internal/timer"
+)
+
+// One outbound call of the fallback chain, written to the request log
+type fallbackHop struct {
+	Router     string      `json:"router"`
+	StatusCode int         `json:"status_code"`
+	Error      string      `json:"error,omitempty"`
+	Timer      timer.Timer `json:"timer"`
+}
+
+// checkFallback verifies that the fallback routers can be reached from the router
+func checkFallback(thisRouter router.Router, routers []router.Router) error {
+	if thisRouter.Fallback == nil {
+		return nil
+	}
+	for _, path := range thisRouter.Fallback.Routers {
+		err := checkRouterReference(thisRouter, path, routers)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldFallBack is true when the outbound call failed in a way the fallback covers. A response
+// that is already streamed to the client cannot be replaced
+func shouldFallBack(fallback *router.Fallback, crw *GechologResponseWriter) bool {
+	if crw.streamed {
+		return false
+	}
+	if fallback.NetworkErrors && crw.outboundError != "" {
+		return true
+	}
+	return slices.Contains(fallback.StatusCodes, crw.inboundStatusCode)
+}
+
+// resetOutbound clears the outbound call so that it can be made again. The outbound payload is
+// restored since translation rewrites it
+func (crw *GechologResponseWriter) resetOutbound(outboundPayload json.RawMessage) {
+	crw.outboundBody.Reset()
+	crw.outboundHeaders = http.Header{}
+	crw.inboundBody.Reset()
+	crw.inboundHeaders = http.Header{}
+	crw.inboundStatusCode = http.StatusOK
+	crw.inboundBinary = false
+	crw.outboundError = ""
+	crw.errorCategory = ""
+	crw.upstreams = nil
+	if outboundPayload != nil {
+		store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", outboundPayload)
+	}
+}
+
+// fallbackMiddlewareFunc makes the outbound call again through the fallback routers of the router in
+// gl_path, in order, until a call succeeds. Each hop sets gl_path so the outbound router is looked up
+// as for a gl_path set by a processor. Fallback routers do not fall back further
+func fallbackMiddlewareFunc(thisRouter router.Router, listOfRouters []router.Router, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	fallbacks := map[string]*router.Fallback{}
+	for _, r := range listOfRouters {
+		if r.Fallback != nil {
+			fallbacks[r.Path] = r.Fallback
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			path := thisRouter.Path
+			if raw, err := crw.requestObject.GetField("gl_path"); err == nil {
+				glPath := ""
+				if json.Unmarshal(raw, &glPath) == nil && slices.ContainsFunc(listOfRouters, func(r router.Router) bool { return r.Path == glPath }) {
+					path = glPath
+				}
+			}
+
+			fallback, exists := fallbacks[path]
+			if !exists {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			outboundPayload, _ := crw.requestObject.GetField("outbound_payload")
+			hops := []fallbackHop{}
+			defer func() {
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "fallback_hops", &hops)
+			}()
+
+			for n := 0; ; n++ {
+				hop := fallbackHop{Router: path}
+				hop.Timer.Start()
+				next.ServeHTTP(crw, r)
+				hop.Timer.Stop()
+				hop.StatusCode = crw.inboundStatusCode
+				hop.Error = crw.outboundError
+				hops = append(hops, hop)
+
+				if n == len(fallback.Routers) || !shouldFallBack(fallback, crw) {
+					return
+				}
+
+				path = fallback.Routers[n]
+				logger.Info(
+					"falling back to router",
+					slog.String("transaction_id", crw.transactionID),
+					slog.String("from", hop.Router),
+					slog.String("to", path),
+					slog.Int("status_code", hop.StatusCode),
+				)
+				crw.resetOutbound(outboundPayload)
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", path)
+			}
+		})
+	}
+}
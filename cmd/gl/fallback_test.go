@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFallbackTestWriter() *GechologResponseWriter {
+	return &GechologResponseWriter{
+		ResponseWriter:      httptest.NewRecorder(),
+		outboundBody:        bytes.NewBufferString(""),
+		outboundHeaders:     http.Header{},
+		inboundBody:         bytes.NewBufferString(""),
+		inboundHeaders:      http.Header{},
+		inboundStatusCode:   http.StatusOK,
+		egressBody:          bytes.NewBufferString(""),
+		egressHeaders:       http.Header{},
+		egressStatusCode:    http.StatusOK,
+		requestObject:       gechologobject.New(),
+		requestErrorObject:  gechologobject.New(),
+		responseObject:      gechologobject.New(),
+		responseErrorObject: gechologobject.New(),
+	}
+}
+
+func Test_fallbackMiddlewareFunc(t *testing.T) {
+	routers := []router.Router{
+		{Path: "/service/standard/", Fallback: &router.Fallback{Routers: []string{"/service/backup/", "/service/last/"}, StatusCodes: []int{429, 503}}},
+		{Path: "/service/network/", Fallback: &router.Fallback{Routers: []string{"/service/backup/"}, NetworkErrors: true}},
+		{Path: "/service/backup/"},
+		{Path: "/service/last/"},
+		{Path: "/service/plain/"},
+	}
+
+	type outcome struct {
+		statusCode int
+		err        string
+	}
+	tests := []struct {
+		name           string
+		glPath         string
+		outcomes       map[string]outcome
+		streamed       bool
+		expectedHops   []string
+		expectedStatus int
+	}{
+		{
+			name:           "primary succeeds",
+			glPath:         "/service/standard/",
+			outcomes:       map[string]outcome{"/service/standard/": {statusCode: 200}},
+			expectedHops:   []string{"/service/standard/"},
+			expectedStatus: 200,
+		},
+		{
+			name:   "fallback on status code",
+			glPath: "/service/standard/",
+			outcomes: map[string]outcome{
+				"/service/standard/": {statusCode: 503},
+				"/service/backup/":   {statusCode: 200},
+			},
+			expectedHops:   []string{"/service/standard/", "/service/backup/"},
+			expectedStatus: 200,
+		},
+		{
+			name:   "all routers fail",
+			glPath: "/service/standard/",
+			outcomes: map[string]outcome{
+				"/service/standard/": {statusCode: 503},
+				"/service/backup/":   {statusCode: 429},
+				"/service/last/":     {statusCode: 503},
+			},
+			expectedHops:   []string{"/service/standard/", "/service/backup/", "/service/last/"},
+			expectedStatus: 503,
+		},
+		{
+			name:   "status code not covered",
+			glPath: "/service/standard/",
+			outcomes: map[string]outcome{
+				"/service/standard/": {statusCode: 400},
+			},
+			expectedHops:   []string{"/service/standard/"},
+			expectedStatus: 400,
+		},
+		{
+			name:   "network error",
+			glPath: "/service/network/",
+			outcomes: map[string]outcome{
+				"/service/network/": {statusCode: 502, err: "connection refused"},
+				"/service/backup/":  {statusCode: 200},
+			},
+			expectedHops:   []string{"/service/network/", "/service/backup/"},
+			expectedStatus: 200,
+		},
+		{
+			name:   "network error not covered",
+			glPath: "/service/standard/",
+			outcomes: map[string]outcome{
+				"/service/standard/": {statusCode: 502, err: "connection refused"},
+			},
+			expectedHops:   []string{"/service/standard/"},
+			expectedStatus: 502,
+		},
+		{
+			name:   "streamed response",
+			glPath: "/service/standard/",
+			outcomes: map[string]outcome{
+				"/service/standard/": {statusCode: 503},
+			},
+			streamed:       true,
+			expectedHops:   []string{"/service/standard/"},
+			expectedStatus: 503,
+		},
+		{
+			name:           "no fallback",
+			glPath:         "/service/plain/",
+			outcomes:       map[string]outcome{"/service/plain/": {statusCode: 503}},
+			expectedStatus: 503,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := newFallbackTestWriter()
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", tt.glPath)
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", []byte(`{"model":"gpt-4o"}`))
+
+			calls := []string{}
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				raw, _ := crw.requestObject.GetField("gl_path")
+				glPath := ""
+				json.Unmarshal(raw, &glPath)
+				calls = append(calls, glPath)
+
+				// Every hop starts from the original payload
+				payload, _ := crw.requestObject.GetField("outbound_payload")
+				assert.JSONEq(t, `{"model":"gpt-4o"}`, string(payload))
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", []byte(`{"translated":true}`))
+
+				assert.Equal(t, 0, crw.outboundBody.Len())
+				crw.outboundBody.WriteString(`{"model":"gpt-4o"}`)
+				crw.inboundBody.WriteString(`{}`)
+				crw.inboundStatusCode = tt.outcomes[glPath].statusCode
+				crw.outboundError = tt.outcomes[glPath].err
+				crw.streamed = tt.streamed
+			})
+			fallbackMiddlewareFunc(routers[0], routers, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/service/standard/", nil))
+
+			assert.Equal(t, tt.expectedStatus, crw.inboundStatusCode)
+			assert.Equal(t, `{}`, crw.inboundBody.String())
+
+			raw, err := crw.requestObject.GetField("fallback_hops")
+			if tt.expectedHops == nil {
+				assert.Error(t, err)
+				assert.Equal(t, []string{tt.glPath}, calls)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedHops, calls)
+
+			hops := []fallbackHop{}
+			assert.NoError(t, json.Unmarshal(raw, &hops))
+			assert.Len(t, hops, len(tt.expectedHops))
+			for i, hop := range hops {
+				assert.Equal(t, tt.expectedHops[i], hop.Router)
+				assert.Equal(t, tt.outcomes[hop.Router].statusCode, hop.StatusCode)
+				assert.Equal(t, tt.outcomes[hop.Router].err, hop.Error)
+			}
+		})
+	}
+}
+
+func Test_fallbackChain(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"overloaded"}`))
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"answer":42}`))
+	}))
+	defer backup.Close()
+
+	routers := []router.Router{
+		{
+			Path:     "/service/standard/",
+			Outbound: router.OutboundNode{Url: primary.URL, Headers: protectedheader.ProtectedHeader{}},
+			Fallback: &router.Fallback{Routers: []string{"/service/backup/"}, StatusCodes: []int{503}},
+		},
+		{
+			Path:     "/service/backup/",
+			Outbound: router.OutboundNode{Url: backup.URL, Headers: protectedheader.ProtectedHeader{}},
+		},
+	}
+	balancers := map[string]*targetBalancer{}
+	for _, r := range routers {
+		balancers[r.Path] = newTargetBalancer(r.Outbound)
+	}
+	s := &state{m: &sync.Mutex{}}
+
+	crw := newFallbackTestWriter()
+	store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", "/service/standard/")
+	store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", []byte(`{"model":"gpt-4o"}`))
+
+	handler := fallbackMiddlewareFunc(routers[0], routers, s)(
+		outboundInboundPathMiddlewareFunc(routers[0], routers, balancers, map[string]*http.Client{}, s)(
+			outboundInboundPayloadMiddlewareFunc(1024, s)(
+				standardRequestFunc(http.DefaultClient),
+			),
+		),
+	)
+	handler.ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/service/standard/", nil))
+
+	assert.Equal(t, http.StatusOK, crw.inboundStatusCode)
+	assert.Equal(t, `{"answer":42}`, crw.inboundBody.String())
+
+	original, err := crw.requestObject.GetField("original_gl_path")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `"/service/standard/"`, string(original))
+
+	glPath, err := crw.responseObject.GetField("gl_path")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `"/service/backup/"`, string(glPath))
+
+	raw, err := crw.requestObject.GetField("fallback_hops")
+	assert.NoError(t, err)
+	hops := []fallbackHop{}
+	assert.NoError(t, json.Unmarshal(raw, &hops))
+	assert.Len(t, hops, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, hops[0].StatusCode)
+	assert.Equal(t, http.StatusOK, hops[1].StatusCode)
+}
+
+func Test_checkFallback(t *testing.T) {
+	routers := []router.Router{
+		{Path: "/service/standard/"},
+		{Path: "/service/backup/"},
+	}
+	assert.NoError(t, checkFallback(router.Router{Path: "/service/standard/", Fallback: &router.Fallback{Routers: []string{"/service/backup/"}}}, routers))
+	assert.Error(t, checkFallback(router.Router{Path: "/service/standard/", Fallback: &router.Fallback{Routers: []string{"/service/backup/", "/service/other/"}}}, routers))
+	assert.NoError(t, checkFallback(router.Router{Path: "/service/standard/"}, routers))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/jwt"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+)
+
+// Header carrying the token when the jwt header is not set, with the Bearer prefix
+const DEFAULT_JWT_HEADER = "Authorization"
+
+// jwtVerifier checks the tokens of one router
+type jwtVerifier struct {
+	requirement *router.JWTRequirement
+	keys        *jwt.KeySet
+	header      string
+}
+
+// newJWTVerifier loads the keys of the requirement. Returns nil if no jwt is required
+func newJWTVerifier(requirement *router.JWTRequirement) (*jwtVerifier, error) {
+	if requirement == nil {
+		return nil, nil
+	}
+
+	var keys *jwt.KeySet
+	switch {
+	case requirement.JwksFile != "":
+		data, err := os.ReadFile(requirement.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err = jwt.ParseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", requirement.JwksFile, err)
+		}
+	case requirement.PublicKeyFile != "":
+		data, err := os.ReadFile(requirement.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err = jwt.ParsePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", requirement.PublicKeyFile, err)
+		}
+	default:
+		keys = jwt.NewHMACKeySet([]byte(requirement.Secret))
+	}
+
+	header := requirement.Header
+	if header == "" {
+		header = DEFAULT_JWT_HEADER
+	}
+	return &jwtVerifier{
+		requirement: requirement,
+		keys:        keys,
+		header:      http.CanonicalHeaderKey(header),
+	}, nil
+}
+
+func (v *jwtVerifier) token(h http.Header) string {
+	token := h.Get(v.header)
+	if v.header == DEFAULT_JWT_HEADER {
+		token, _ = strings.CutPrefix(token, "Bearer ")
+	}
+	return strings.TrimSpace(token)
+}
+
+// verify returns the claims of a valid token
+func (v *jwtVerifier) verify(h http.Header, now time.Time) (jwt.Claims, error) {
+	token := v.token(h)
+	if token == "" {
+		return nil, fmt.Errorf("token missing in %s", v.header)
+	}
+	claims, err := v.keys.Verify(token, v.requirement.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+	err = claims.Validate(now, time.Duration(v.requirement.Leeway)*time.Second, v.requirement.Issuer, v.requirement.Audience)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// authorize returns the first required claim without an allowed value
+func (v *jwtVerifier) authorize(claims jwt.Claims) (string, bool) {
+	for name, allowed := range v.requirement.RequiredClaims {
+		if !slices.ContainsFunc(claims.Strings(name), func(value string) bool {
+			return slices.Contains(allowed, value)
+		}) {
+			return name, false
+		}
+	}
+	return "", true
+}
+
+// logged picks the claims to copy into the request log
+func (v *jwtVerifier) logged(claims jwt.Claims) map[string]any {
+	selected := map[string]any{}
+	for _, name := range v.requirement.LogClaims {
+		if value, exists := claims[name]; exists {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// jwtMiddlewareFunc requires a valid token on ingress. A nil verifier disables it
+func jwtMiddlewareFunc(verifier *jwtVerifier, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if verifier == nil {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			claims, err := verifier.verify(r.Header, time.Now())
+			if err != nil {
+				crw.egressBody.Write([]byte(`{"error":"unauthorized invalid token"}`))
+				crw.egressHeaders.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				crw.egressStatusCode = http.StatusUnauthorized
+
+				crw.requestErrorObject.AssignField("jwt", err.Error())
+				return
+			}
+
+			if len(verifier.requirement.LogClaims) != 0 {
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "jwt_claims", verifier.logged(claims))
+			}
+
+			claim, allowed := verifier.authorize(claims)
+			if !allowed {
+				crw.egressBody.Write([]byte(`{"error":"forbidden"}`))
+				crw.egressStatusCode = http.StatusForbidden
+
+				crw.requestErrorObject.AssignField("jwt", "claim "+claim+" not allowed")
+				logger.Warn(
+					"jwt claim not allowed",
+					slog.String("transaction_id", crw.transactionID),
+					slog.String("claim", claim),
+				)
+				return
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
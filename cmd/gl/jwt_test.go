@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func hs256Token(secret string, claims map[string]any) string {
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func Test_jwtMiddleware(t *testing.T) {
+	requirement := &router.JWTRequirement{
+		Secret:         "a-shared-secret",
+		Issuer:         "https://idp.example.com",
+		Audience:       "gl",
+		LogClaims:      []string{"sub", "groups"},
+		RequiredClaims: map[string][]string{"groups": {"llm-users"}},
+	}
+	valid := map[string]any{
+		"sub":    "alice",
+		"groups": []string{"staff", "llm-users"},
+		"iss":    "https://idp.example.com",
+		"aud":    "gl",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	with := func(key string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+	without := func(key string) map[string]any {
+		claims := with(key, nil)
+		delete(claims, key)
+		return claims
+	}
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedNext   bool
+		expectedStatus int
+		expectedClaims string
+	}{
+		{
+			name:           "valid token",
+			authorization:  "Bearer " + hs256Token("a-shared-secret", valid),
+			expectedNext:   true,
+			expectedStatus: http.StatusOK,
+			expectedClaims: `{"sub":"alice","groups":["staff","llm-users"]}`,
+		},
+		{
+			name:           "missing token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong secret",
+			authorization:  "Bearer " + hs256Token("another-secret", valid),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "expired",
+			authorization:  "Bearer " + hs256Token("a-shared-secret", with("exp", time.Now().Add(-time.Hour).Unix())),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "no exp",
+			authorization:  "Bearer " + hs256Token("a-shared-secret", without("exp")),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong audience",
+			authorization:  "Bearer " + hs256Token("a-shared-secret", with("aud", "other")),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "required claim missing",
+			authorization:  "Bearer " + hs256Token("a-shared-secret", with("groups", []string{"staff"})),
+			expectedStatus: http.StatusForbidden,
+			expectedClaims: `{"sub":"alice","groups":["staff"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := newJWTVerifier(requirement)
+			assert.NoError(t, err)
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressHeaders:      http.Header{},
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			jwtMiddlewareFunc(verifier, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			assert.Equal(t, tt.expectedNext, nextCalled)
+			assert.Equal(t, tt.expectedStatus, crw.egressStatusCode)
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer error="invalid_token"`, crw.egressHeaders.Get("WWW-Authenticate"))
+			}
+
+			claims, err := crw.requestObject.GetField("jwt_claims")
+			if tt.expectedClaims == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedClaims, string(claims))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+// Error categories written to the log as error_category
+const (
+	ERROR_CATEGORY_REQUEST_TOO_LARGE  = "request_too_large"
+	ERROR_CATEGORY_RESPONSE_TOO_LARGE = "response_too_large"
+	ERROR_CATEGORY_UPSTREAM_TIMEOUT   = "upstream_timeout"
+)
+
+// setErrorCategory keeps the first category of the request
+func (crw *GechologResponseWriter) setErrorCategory(category string, detail string) {
+	logger.Warn(
+		"request limit exceeded",
+		slog.String("transaction_id", crw.transactionID),
+		slog.String("error_category", category),
+		slog.String("detail", detail),
+	)
+	if crw.errorCategory != "" {
+		return
+	}
+	crw.errorCategory = category
+	crw.rootErrorObject.AssignField(category, detail)
+}
+
+// limitsMiddlewareFunc caps the ingress body and hands the outbound limits to the request handler. Nil limits disables it
+func limitsMiddlewareFunc(limits *router.Limits, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if limits != nil {
+				crw.limits = limits
+				if limits.MaxRequestBytes > 0 {
+					r.Body = http.MaxBytesReader(crw, r.Body, limits.MaxRequestBytes)
+				}
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_limitsMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		limits           *router.Limits
+		body             string
+		expectedNext     bool
+		expectedStatus   int
+		expectedCategory string
+	}{
+		{
+			name:         "no limits",
+			body:         `{"prompt":"hello"}`,
+			expectedNext: true,
+		},
+		{
+			name:         "within limit",
+			limits:       &router.Limits{MaxRequestBytes: 100},
+			body:         `{"prompt":"hello"}`,
+			expectedNext: true,
+		},
+		{
+			name:             "request too large",
+			limits:           &router.Limits{MaxRequestBytes: 10},
+			body:             `{"prompt":"hello"}`,
+			expectedStatus:   http.StatusRequestEntityTooLarge,
+			expectedCategory: ERROR_CATEGORY_REQUEST_TOO_LARGE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:      httptest.NewRecorder(),
+				egressBody:          bytes.NewBufferString(""),
+				requestObject:       gechologobject.New(),
+				requestErrorObject:  gechologobject.New(),
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+				rootErrorObject:     gechologobject.New(),
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			handler := limitsMiddlewareFunc(tt.limits, &state{m: &sync.Mutex{}})(ingressEgressPayloadMiddlewareFunc(0, &state{m: &sync.Mutex{}})(next))
+			handler.ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedNext, nextCalled)
+			assert.Equal(t, tt.expectedCategory, crw.errorCategory)
+			if !tt.expectedNext {
+				assert.Equal(t, tt.expectedStatus, crw.egressStatusCode)
+				assert.JSONEq(t, `{"error":"request body too large"}`, crw.egressBody.String())
+			}
+		})
+	}
+}
+
+func Test_standardRequestFunc_Limits(t *testing.T) {
+	tests := []struct {
+		name               string
+		limits             *router.Limits
+		delay              time.Duration
+		expectedStatusCode int
+		expectedCategory   string
+	}{
+		{
+			name:               "no limits",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "within limits",
+			limits:             &router.Limits{Timeout: 1000, MaxResponseBytes: 100},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "upstream timeout",
+			limits:             &router.Limits{Timeout: 20},
+			delay:              200 * time.Millisecond,
+			expectedStatusCode: http.StatusGatewayTimeout,
+			expectedCategory:   ERROR_CATEGORY_UPSTREAM_TIMEOUT,
+		},
+		{
+			name:               "response too large",
+			limits:             &router.Limits{MaxResponseBytes: 10},
+			expectedStatusCode: http.StatusBadGateway,
+			expectedCategory:   ERROR_CATEGORY_RESPONSE_TOO_LARGE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.Write([]byte(`{"answer":"a rather long answer"}`))
+			}))
+			defer server.Close()
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				outboundBody:       bytes.NewBufferString(`{}`),
+				outboundHeaders:    http.Header{},
+				inboundBody:        bytes.NewBufferString(""),
+				inboundHeaders:     http.Header{},
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+				rootErrorObject:    gechologobject.New(),
+				upstreams:          newTargetBalancer(router.OutboundNode{Url: server.URL}).candidates(),
+				limits:             tt.limits,
+			}
+
+			req, err := http.NewRequest("POST", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+
+			assert.Equal(t, tt.expectedStatusCode, crw.inboundStatusCode)
+			assert.Equal(t, tt.expectedCategory, crw.errorCategory)
+			if tt.expectedStatusCode == http.StatusOK {
+				assert.JSONEq(t, `{"answer":"a rather long answer"}`, crw.inboundBody.String())
+			}
+		})
+	}
+}
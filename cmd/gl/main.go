@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/direktoren/gecholog/internal/glconfig"
@@ -50,10 +52,14 @@ type serviceBusConfig struct {
 	TopicExactIsAlive string `json:"topic_exact_isalive" validate:"required,nefield=Topic,nefield=TopicExactLogger,alphanumdot"`
 	TopicExactLogger  string `json:"topic_exact_logger" validate:"required,nefield=Topic,nefield=TopicExactIsAlive,alphanumdot"`
 	Token             string `json:"token" validate:"required,ascii"`
+	TopicExactReload  string `json:"topic_exact_reload,omitempty" validate:"omitempty,nefield=Topic,nefield=TopicExactIsAlive,nefield=TopicExactLogger,alphanumdot"`
 }
 
 func (s serviceBusConfig) String() string {
 	str := fmt.Sprintf("hostname:%s topic:%s topic_exact_isalive:%s topic_logger_exact:%s", s.Hostname, s.Topic, s.TopicExactIsAlive, s.TopicExactLogger)
+	if s.TopicExactReload != "" {
+		str += fmt.Sprintf(" topic_exact_reload:%s", s.TopicExactReload)
+	}
 	if s.Token != "" {
 		str += " token:[*****MASKED*****]"
 	}
@@ -66,6 +72,10 @@ type tlsUserConfig struct {
 		Enabled         bool   `json:"enabled"`
 		CertificateFile string `json:"certificate_file" validate:"required_if=Enabled true|file"`
 		PrivateKeyFile  string `json:"private_key_file" validate:"required_if=Enabled true|file"`
+
+		// Client certificates are verified against client_ca_files. request verifies a certificate if sent, require demands one
+		ClientAuth    string   `json:"client_auth,omitempty" validate:"excluded_unless=Enabled true,omitempty,oneof=request require"`
+		ClientCAFiles []string `json:"client_ca_files,omitempty" validate:"required_with=ClientAuth,unique,dive,file"`
 	} `json:"ingress"`
 	Outbound struct {
 		InsecureFlag       bool     `json:"insecure"`
@@ -75,7 +85,11 @@ type tlsUserConfig struct {
 }
 
 func (t tlsUserConfig) String() string {
-	s := fmt.Sprintf("ingress:{%s} ", fmt.Sprintf("enabled:%v certificate_file:%s private_key_file:%s ", t.Ingress.Enabled, t.Ingress.CertificateFile, t.Ingress.PrivateKeyFile))
+	ingress := fmt.Sprintf("enabled:%v certificate_file:%s private_key_file:%s ", t.Ingress.Enabled, t.Ingress.CertificateFile, t.Ingress.PrivateKeyFile)
+	if t.Ingress.ClientAuth != "" {
+		ingress += fmt.Sprintf("client_auth:%s client_ca_files:%v ", t.Ingress.ClientAuth, t.Ingress.ClientCAFiles)
+	}
+	s := fmt.Sprintf("ingress:{%s} ", ingress)
 	s += fmt.Sprintf("outbound:{%s}", fmt.Sprintf("insecure:%v system_cert_pool:%v cert_files:%v", t.Outbound.InsecureFlag, t.Outbound.SystemCertPoolFlag, t.Outbound.CertFiles))
 	return s
 }
@@ -86,10 +100,12 @@ type isAliveBody struct {
 
 	LastError     string
 	LastErrorTime string
+
+	CircuitBreakers map[string]string `json:",omitempty"`
 }
 
 type processorsMatrix struct {
-	Processors []([]processorconfiguration.ProcessorConfiguration) `json:"processors" validate:"dive,unique=Name,unique=ServiceBusTopic,dive"`
+	Processors []([]processorconfiguration.ProcessorConfiguration) `json:"processors" validate:"dive,unique=Name,uniquenonempty=ServiceBusTopic,dive"`
 }
 
 type filter struct {
@@ -114,6 +130,8 @@ type gl_config struct {
 	ServiceBusConfig serviceBusConfig `json:"service_bus" validate:"required"`
 
 	Port            int    `json:"gl_port" validate:"min=1,max=65535"`
+	MetricsPort     int    `json:"metrics_port,omitempty" validate:"omitempty,min=1,max=65535,nefield=Port"`
+	ShutdownTimeout int    `json:"shutdown_timeout,omitempty" validate:"omitempty,min=1"`
 	SessionIDHeader string `json:"session_id_header" validate:"required,ascii,excludesall= /()<>@;:\\\"[]?="`
 
 	MaskedHeaders    []string `json:"masked_headers" validate:"unique,dive,ascii,excludesall= /()<>@;:\\\"[]?="`
@@ -125,18 +143,31 @@ type gl_config struct {
 	LogUnauthorized bool            `json:"log_unauthorized"`
 	Routers         []router.Router `json:"routers" validate:"unique=Path,gt=0,dive"`
 
+	// Payloads that are not json are logged as metadata, and inlined as base64 up to this many bytes
+	InlineBinaryMaxBytes int `json:"inline_binary_max_bytes,omitempty" validate:"omitempty,min=1"`
+
 	RequestProcessors  processorsMatrix `json:"request"`
 	ResponseProcessors processorsMatrix `json:"response"`
 	Logger             finalLogger      `json:"logger"`
 
+	Tracing *tracingConfig `json:"tracing,omitempty" validate:"omitempty"`
+
+	Compression *compressionConfig `json:"compression,omitempty" validate:"omitempty"`
+
+	ConsumerHeader string     `json:"consumer_header,omitempty" validate:"omitempty,ascii,excludesall= /()<>@;:\\\"[]?="`
+	Consumers      []consumer `json:"consumers,omitempty" validate:"omitempty,unique=Name,dive"`
+
 	IsAliveData isAliveBody
 	//	performanceLog *logrus.Logger
-	client    *http.Client
-	tlsConfig *tls.Config
-	m         sync.Mutex
+	client             *http.Client
+	tlsConfig          *tls.Config
+	ingressCertificate *tls.Certificate
+	ingressClientCAs   *x509.CertPool
+	m                  sync.Mutex
 
 	sha256       string
 	checksumFile string
+	configFile   string
 }
 
 // Configuration stringer
@@ -147,23 +178,69 @@ func (c *gl_config) String() string {
 	s += fmt.Sprintf("tls:%s ", c.TlsUserConfig.String())
 	s += fmt.Sprintf("service_bus:%s ", c.ServiceBusConfig.String())
 	s += fmt.Sprintf("gl_port:%d ", c.Port)
+	if c.MetricsPort != 0 {
+		s += fmt.Sprintf("metrics_port:%d ", c.MetricsPort)
+	}
+	if c.ShutdownTimeout != 0 {
+		s += fmt.Sprintf("shutdown_timeout:%d ", c.ShutdownTimeout)
+	}
 	s += fmt.Sprintf("session_id_header:%s ", c.SessionIDHeader)
 	s += fmt.Sprintf("masked_headers:%v ", c.MaskedHeaders)
 	s += fmt.Sprintf("remove_headers:%v ", c.RemoveHeaders)
 	s += fmt.Sprintf("log_unauthorized:%v ", c.LogUnauthorized)
+	if c.InlineBinaryMaxBytes != 0 {
+		s += fmt.Sprintf("inline_binary_max_bytes:%d ", c.InlineBinaryMaxBytes)
+	}
 	for i, r := range c.Routers {
 		s += fmt.Sprintf("router %d:{%s} ", i, r.String())
 	}
 	s += fmt.Sprintf("request:%v ", c.RequestProcessors)
 	s += fmt.Sprintf("response:%v ", c.ResponseProcessors)
 	s += fmt.Sprintf("logger:%v ", c.Logger)
+	if c.Tracing != nil {
+		s += fmt.Sprintf("tracing:{%s} ", c.Tracing.String())
+	}
+	if c.Compression != nil {
+		s += fmt.Sprintf("compression:{%s} ", c.Compression.String())
+	}
+	if c.ConsumerHeader != "" {
+		s += fmt.Sprintf("consumer_header:%s ", c.ConsumerHeader)
+	}
+	for i, consumer := range c.Consumers {
+		s += fmt.Sprintf("consumer %d:{%s} ", i, consumer.String())
+	}
 	return s
 }
 
 func (c *gl_config) Validate() validate.ValidationErrors {
 	// Add map validation as well
 	v := validate.New()
-	return validate.ValidateStruct(v, c)
+	errors := validate.ValidateStruct(v, c)
+
+	// Processor conditions must compile, status codes are only known to response processors
+	for stage, matrix := range map[string]processorsMatrix{"RequestProcessors": c.RequestProcessors, "ResponseProcessors": c.ResponseProcessors} {
+		for i, row := range matrix.Processors {
+			for j, p := range row {
+				if p.Condition == nil {
+					continue
+				}
+				field := fmt.Sprintf("gl_config.%s.Processors[%d][%d].Condition", stage, i, j)
+				if _, err := p.Condition.Requirements(); err != nil {
+					if errors == nil {
+						errors = validate.ValidationErrors{}
+					}
+					errors[field+".Match"] = err.Error()
+				}
+				if stage == "RequestProcessors" && len(p.Condition.StatusCodes) != 0 {
+					if errors == nil {
+						errors = validate.ValidationErrors{}
+					}
+					errors[field+".StatusCodes"] = "status codes are not known to request processors"
+				}
+			}
+		}
+	}
+	return errors
 }
 
 func (g *gl_config) setLastError(err error, t time.Time) {
@@ -184,7 +261,10 @@ func (g *gl_config) setLastTransactionID(tid string) {
 type processorLog struct {
 	Required  bool        `json:"required"`
 	Completed bool        `json:"completed"`
+	Skipped   bool        `json:"skipped,omitempty"` // the condition of the processor did not match
 	Timestamp timer.Timer `json:"timestamp"`
+
+	outcome string // for metrics
 }
 
 func noTrailingSlashHandlerFunc(path string) (string, func(w http.ResponseWriter, r *http.Request)) {
@@ -219,78 +299,28 @@ func (cw *CustomWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-// ----------- do function - Sets up service bus, isalive, fires of http(s) server  -----------------
-
-func do(ctx context.Context, cancelTheContext context.CancelFunc) {
-
-	// Create NATS client options
-	opts := nats.GetDefaultOptions()
-	opts.Url = globalConfig.ServiceBusConfig.Hostname
-	opts.Token = globalConfig.ServiceBusConfig.Token
-	// Set reconnection options
-	opts.ReconnectWait = 3 * time.Second // Wait 3 seconds before trying to reconnect
-	opts.MaxReconnect = 3                // Negative value means unlimited reconnect attempts
-
-	opts.ClosedCB = func(_ *nats.Conn) {
-		logger.Warn(
-			"connection to NATS closed",
-		)
-
-		cancelTheContext()
-	}
-	opts.ReconnectedCB = func(nc *nats.Conn) {
-		logger.Info(
-			"reconnected to NATS",
-		)
-
-	}
-	opts.DisconnectedErrCB = func(_ *nats.Conn, err error) {
-		if err != nil {
-			logger.Error(
-				"disconnected from NATS",
-				slog.Any("error", err),
-			)
-			return
-		}
-		logger.Warn(
-			"disconnected from NATS",
-		)
-
-		//cancelTheContext()
-	}
-	nc, err := opts.Connect()
-
-	//nc, err := nats.Connect(, nats.Token(globalConfig.ServiceBusConfig.Token))
-	if err != nil {
-		logger.Error(
-			"issue connecting to nats-server",
-			slog.Any("error", err),
-		)
+// ----------- buildGateway - Builds the router mux from a configuration  -----------------
+
+// gateway is everything built from one configuration. Requests keep the gateway they started on
+type gateway struct {
+	handler     http.Handler
+	breakers    *breakerRegistry
+	limiters    map[string]*rateLimiter  // by router path
+	caches      map[string]responseCache // by router path
+	certificate *tls.Certificate
+	clientAuth  tls.ClientAuthType
+	clientCAs   *x509.CertPool
+}
 
-		cancelTheContext()
-		return
-	}
-	defer nc.Close()
+// buildGateway builds the gateway of the configuration. previous is the running gateway on a reload,
+// its circuit breakers, rate limiters and memory caches are kept where the configuration is unchanged
+func buildGateway(ctx context.Context, nc *nats.Conn, config *gl_config, previous *gateway, s *state) (*gateway, error) {
 
-	// publish to topic
-	err = nc.Publish(globalConfig.ServiceBusConfig.Topic, []byte("running & connected to message bus"))
+	caller, err := newProcessorCaller(nc, config)
 	if err != nil {
-		logger.Error(
-			"error publishing status to channel",
-			slog.Any("error", err),
-		)
-
-		cancelTheContext()
-		return
+		return nil, fmt.Errorf("error creating processor clients: %v", err)
 	}
 
-	logger.Info(
-		"service bus initialized",
-	)
-	logger.Info(
-		"starting the service",
-	)
-
 	buildProcessorsMiddleware := func(async bool, pm processorsMiddlewareFunc, processors [][]processorconfiguration.ProcessorConfiguration, s *state) (func(http.Handler) http.Handler, int) {
 		m := func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -310,7 +340,7 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 				continue
 			}
 			count++
-			layer := pm(ctx, nc, filteredRow, s)
+			layer := pm(ctx, caller, filteredRow, s)
 
 			previousMiddleware := m
 			m = func(next http.Handler) http.Handler {
@@ -320,27 +350,25 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 		return m, count
 	}
 
-	s := state{m: &sync.Mutex{}}
-
 	// Build the SYNC requestProcessorMiddleware
-	requestProcessorsMiddleware, countRequestSync := buildProcessorsMiddleware(false, requestProcessorMiddlewareFunc, globalConfig.RequestProcessors.Processors, &s)
+	requestProcessorsMiddleware, countRequestSync := buildProcessorsMiddleware(false, requestProcessorMiddlewareFunc, config.RequestProcessors.Processors, s)
 	logger.Info("adding request processor layer", slog.Int("layer", countRequestSync))
 
 	// Build the ASYNC requestProcessorMiddleware
-	requestProcessorsMiddlewareAsync, countRequestAsync := buildProcessorsMiddleware(true, requestProcessorMiddlewareFunc, globalConfig.RequestProcessors.Processors, &s)
+	requestProcessorsMiddlewareAsync, countRequestAsync := buildProcessorsMiddleware(true, requestProcessorMiddlewareFunc, config.RequestProcessors.Processors, s)
 	logger.Info("adding async request processor layer", slog.Int("layer", countRequestAsync))
 
 	// Build the SYNC responseProcessorMiddleware
-	responseProcessorsMiddleware, countResponseSync := buildProcessorsMiddleware(false, responseProcessorMiddlewareFunc, globalConfig.ResponseProcessors.Processors, &s)
+	responseProcessorsMiddleware, countResponseSync := buildProcessorsMiddleware(false, responseProcessorMiddlewareFunc, config.ResponseProcessors.Processors, s)
 	logger.Info("adding response processor layer", slog.Int("layer", countResponseSync))
 
 	// Build the ASYNC responseProcessorMiddleware
-	responseProcessorsMiddlewareAsync, countResponseAsync := buildProcessorsMiddleware(true, responseProcessorMiddlewareFunc, globalConfig.ResponseProcessors.Processors, &s)
+	responseProcessorsMiddlewareAsync, countResponseAsync := buildProcessorsMiddleware(true, responseProcessorMiddlewareFunc, config.ResponseProcessors.Processors, s)
 	logger.Info("adding async response processor layer", slog.Int("layer", countResponseAsync))
 
 	loggingPostProcessorFunction := loggingPostProcessorFunc(
 		nc,
-		globalConfig.ServiceBusConfig.TopicExactLogger,
+		config.ServiceBusConfig.TopicExactLogger,
 		globalConfig.setLastError,
 		globalConfig.setLastTransactionID,
 		requestProcessorsMiddlewareAsync(
@@ -348,67 +376,200 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), // noop
 			),
 		),
-		globalConfig.Logger,
-		&s,
+		config.Logger,
+		s,
 	)
 	if loggingPostProcessorFunction == nil {
-		logger.Error("error creating logging post processor")
-		cancelTheContext()
-		return
+		return nil, fmt.Errorf("error creating logging post processor")
 	}
-	loggingMiddleware := loggingMiddlewareFunc(loggingPostProcessorFunction, globalConfig.LogUnauthorized)
+	loggingMiddleware := loggingMiddlewareFunc(loggingPostProcessorFunction, config.LogUnauthorized)
 	if loggingMiddleware == nil {
-		logger.Error("error creating logging middleware")
-		cancelTheContext()
-		return
+		return nil, fmt.Errorf("error creating logging middleware")
 	}
-	sessionMiddleware := sessionMiddlewareFunc(globalConfig.GatewayID, globalConfig.SessionIDHeader, &s)
+	sessionMiddleware := sessionMiddlewareFunc(config.GatewayID, config.SessionIDHeader, s)
 	if sessionMiddleware == nil {
-		logger.Error("error creating session middleware")
-		cancelTheContext()
-		return
+		return nil, fmt.Errorf("error creating session middleware")
 	}
-	standardRequestHandler := standardRequestFunc(globalConfig.client)
+	tracingMiddleware := tracingMiddlewareFunc(config.Tracing, newTraceExporter(config.Tracing), spanExports, s)
+	if tracingMiddleware == nil {
+		return nil, fmt.Errorf("error creating tracing middleware")
+	}
+	standardRequestHandler := standardRequestFunc(config.client)
 	if standardRequestHandler == nil {
-		logger.Error("error creating request handler")
-		cancelTheContext()
-		return
+		return nil, fmt.Errorf("error creating request handler")
 	}
 	echoRequestHandler := echoRequestFunc()
 
+	egressResponseMiddleware := egressResponseMiddlewareFunc(config.Compression, s)
+	if egressResponseMiddleware == nil {
+		return nil, fmt.Errorf("error creating egress response middleware")
+	}
+	ingressEgressPayloadMiddleware := ingressEgressPayloadMiddlewareFunc(config.InlineBinaryMaxBytes, s)
+	if ingressEgressPayloadMiddleware == nil {
+		return nil, fmt.Errorf("error creating ingress payload middleware")
+	}
+	outboundInboundPayloadMiddleware := outboundInboundPayloadMiddlewareFunc(config.InlineBinaryMaxBytes, s)
+	if outboundInboundPayloadMiddleware == nil {
+		return nil, fmt.Errorf("error creating outbound payload middleware")
+	}
+
+	paths := []string{}
+	for _, r := range config.Routers {
+		paths = append(paths, r.Path)
+	}
+	consumers, err := newConsumerIndex(config.ConsumerHeader, config.Consumers, paths)
+	if err != nil {
+		return nil, fmt.Errorf("error creating consumers: %v", err)
+	}
+
+	// Circuit breakers report state changes on the status topic
+	breakers := newBreakerRegistry(func(url string, from string, to string) {
+		logger.Warn("circuit breaker state changed", slog.String("url", url), slog.String("from", from), slog.String("to", to))
+		err := nc.Publish(config.ServiceBusConfig.Topic, []byte(fmt.Sprintf("circuit breaker %s changed from %s to %s", url, from, to)))
+		if err != nil {
+			logger.Error("error publishing status to channel", slog.Any("error", err))
+			glMetrics.natsPublishFailures.Inc(config.ServiceBusConfig.Topic)
+		}
+	})
+	if previous != nil {
+		breakers.keep(previous.breakers)
+	}
+	limiters := map[string]*rateLimiter{}
+	caches := map[string]responseCache{}
+
+	// One balancer and outbound client per router, shared by all requests routed to it
+	balancers := map[string]*targetBalancer{}
+	clients := map[string]*http.Client{}
+	for _, currentRouter := range config.Routers {
+		balancers[currentRouter.Path] = newTargetBalancer(currentRouter.Outbound)
+		if currentRouter.CircuitBreaker != nil {
+			balancers[currentRouter.Path].attachBreakers(breakers, *currentRouter.CircuitBreaker)
+		}
+		client, err := outboundClient(config, currentRouter.Outbound)
+		if err != nil {
+			return nil, fmt.Errorf("error creating outbound client for %s: %v", currentRouter.Path, err)
+		}
+		clients[currentRouter.Path] = client
+	}
+
 	// Start web service
 	mux := http.NewServeMux()
+	dispatcher := newMirrorDispatcher(mux, MAX_MIRRORS_IN_FLIGHT)
 
-	for _, currentRouter := range globalConfig.Routers {
+	for _, currentRouter := range config.Routers {
 
 		requestHandler := standardRequestHandler
 		if currentRouter.Path == "/echo" || strings.HasPrefix(currentRouter.Path, "/echo/") {
 			// Special case path
 			requestHandler = echoRequestHandler
 		}
-		ingressEgressHeaderMiddleware := ingressEgressHeaderMiddlewareFunc(currentRouter.Ingress.Headers, globalConfig.removeHeadersMap, globalConfig.maskedHeadersMap, globalConfig.SessionIDHeader, &s)
-		outboundQueryParametersMiddleware := outboundQueryParametersMiddlewareFunc(currentRouter.Outbound, &s)
-		outboundInboundHeaderMiddleware := outboundInboundHeaderMiddlewareFunc(currentRouter.Outbound.Headers, globalConfig.removeHeadersMap, globalConfig.maskedHeadersMap, globalConfig.SessionIDHeader, &s)
-		ingressPathMiddleware := ingressPathMiddlewareFunc(currentRouter, &s)
-		outboundInboundPathMiddleware := outboundInboundPathMiddlewareFunc(currentRouter, globalConfig.Routers, &s)
+		ingressEgressHeaderMiddleware := ingressEgressHeaderMiddlewareFunc(currentRouter.Ingress.Headers, config.removeHeadersMap, config.maskedHeadersMap, config.SessionIDHeader, s)
+		outboundQueryParametersMiddleware := outboundQueryParametersMiddlewareFunc(currentRouter.Outbound, s)
+		outboundInboundHeaderMiddleware := outboundInboundHeaderMiddlewareFunc(currentRouter.Outbound.Headers, config.removeHeadersMap, config.maskedHeadersMap, config.SessionIDHeader, s)
+		ingressPathMiddleware := ingressPathMiddlewareFunc(currentRouter, s)
+		consumerMiddleware := consumerMiddlewareFunc(consumers, currentRouter.Path, s)
+
+		verifier, err := newJWTVerifier(currentRouter.Ingress.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("error loading jwt keys for %s: %v", currentRouter.Path, err)
+		}
+		jwtMiddleware := jwtMiddlewareFunc(verifier, s)
+
+		matcher, err := newClientCertificateMatcher(currentRouter.Ingress.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling client certificate patterns for %s: %v", currentRouter.Path, err)
+		}
+		if matcher != nil && config.TlsUserConfig.Ingress.ClientAuth == "" {
+			return nil, fmt.Errorf("router %s requires a client certificate but tls.ingress.client_auth is not set", currentRouter.Path)
+		}
+		clientCertificateMiddleware := clientCertificateMiddlewareFunc(matcher, s)
+
+		rules, err := compileRoutingRules(currentRouter.Rules, paths)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling routing rules for %s: %v", currentRouter.Path, err)
+		}
+		routingMiddleware := routingMiddlewareFunc(rules, s)
+		err = checkMirror(currentRouter, config.Routers)
+		if err != nil {
+			return nil, fmt.Errorf("error adding mirror for %s: %v", currentRouter.Path, err)
+		}
+		mirrorMiddleware := mirrorMiddlewareFunc(currentRouter.Mirror, dispatcher, config.SessionIDHeader, s)
+		err = checkFallback(currentRouter, config.Routers)
+		if err != nil {
+			return nil, fmt.Errorf("error adding fallback for %s: %v", currentRouter.Path, err)
+		}
+		fallbackMiddleware := fallbackMiddlewareFunc(currentRouter, config.Routers, s)
+		outboundInboundPathMiddleware := outboundInboundPathMiddlewareFunc(currentRouter, config.Routers, balancers, clients, s)
+		limitsMiddleware := limitsMiddlewareFunc(currentRouter.Limits, s)
+		streamingMiddleware := streamingMiddlewareFunc(currentRouter.Stream, config.removeHeadersMap, config.SessionIDHeader, s)
+
+		var cache responseCache
+		if currentRouter.Cache != nil {
+			var previousCache responseCache
+			if previous != nil {
+				previousCache = previous.caches[currentRouter.Path]
+			}
+			var err error
+			cache, err = keepResponseCache(previousCache, *currentRouter.Cache)
+			if err != nil {
+				return nil, fmt.Errorf("error creating cache for %s: %v", currentRouter.Path, err)
+			}
+			caches[currentRouter.Path] = cache
+		}
+		cacheMiddleware := cacheMiddlewareFunc(cache, currentRouter.Cache, cacheIdentityHeaders(currentRouter), s)
+
+		var limiter *rateLimiter
+		if currentRouter.RateLimit != nil {
+			var previousLimiter *rateLimiter
+			if previous != nil {
+				previousLimiter = previous.limiters[currentRouter.Path]
+			}
+			limiter = keepRateLimiter(previousLimiter, *currentRouter.RateLimit)
+			limiters[currentRouter.Path] = limiter
+		}
+		rateLimitMiddleware := rateLimitMiddlewareFunc(limiter, currentRouter.RateLimit, s)
 
 		logger.Info("building router", slog.String("path", currentRouter.Path))
 		// Build the handler
 		handler := loggingMiddleware(
 			sessionMiddleware(
-				egressResponseMiddleware(
-					ingressEgressPayloadMiddleware(
-						ingressPathMiddleware(
-							ingressEgressHeaderMiddleware(
-								ingressQueryParametersMiddleware(
-									requestProcessorsMiddleware(
-										responseProcessorsMiddleware(
-											outboundInboundPathMiddleware(
-												outboundQueryParametersMiddleware(
-													outboundInboundHeaderMiddleware(
-														outboundInboundPayloadMiddleware(
-															controlFieldMiddleware(
-																requestHandler,
+				tracingMiddleware(
+					egressResponseMiddleware(
+						limitsMiddleware(
+							ingressEgressPayloadMiddleware(
+								ingressPathMiddleware(
+									ingressEgressHeaderMiddleware(
+										clientCertificateMiddleware(
+											consumerMiddleware(
+												jwtMiddleware(
+													rateLimitMiddleware(
+														ingressQueryParametersMiddleware(
+															mirrorMiddleware(
+																routingMiddleware(
+																	requestProcessorsMiddleware(
+																		responseProcessorsMiddleware(
+																			fallbackMiddleware(
+																				outboundInboundPathMiddleware(
+																					outboundQueryParametersMiddleware(
+																						outboundInboundHeaderMiddleware(
+																							outboundInboundPayloadMiddleware(
+																								controlFieldMiddleware(
+																									translationMiddleware(
+																										cacheMiddleware(
+																											streamingMiddleware(
+																												requestHandler,
+																											),
+																										),
+																									),
+																								),
+																							),
+																						),
+																					),
+																				),
+																			),
+																		),
+																	),
+																),
 															),
 														),
 													),
@@ -424,19 +585,147 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 			),
 		)
 
-		mux.Handle(currentRouter.Path, handler)
+		err = handle(mux, currentRouter.Path, handler)
+		if err != nil {
+			return nil, fmt.Errorf("error adding router %s: %v", currentRouter.Path, err)
+		}
 		// If the path is /test/it/ then /test/it will return something bad request ish
-		noSlash, noTrailingSlashHandler := noTrailingSlashHandlerFunc(currentRouter.Path)
-		mux.HandleFunc(noSlash, noTrailingSlashHandler)
+		if strings.HasSuffix(currentRouter.Path, "/") && currentRouter.Path != "/" {
+			noSlash, noTrailingSlashHandler := noTrailingSlashHandlerFunc(currentRouter.Path)
+			err = handle(mux, noSlash, http.HandlerFunc(noTrailingSlashHandler))
+			if err != nil {
+				return nil, fmt.Errorf("error adding router %s: %v", currentRouter.Path, err)
+			}
+		}
 
 		logger.Info(
 			"adding router",
 			slog.Group("router",
 				slog.String("path", currentRouter.Path),
 				slog.String("ingress", currentRouter.Ingress.String()),
-				slog.String("egress", currentRouter.Outbound.String())),
+				slog.String("egress", currentRouter.Outbound.String()),
+				slog.Bool("stream", currentRouter.Stream)),
+		)
+	}
+
+	return &gateway{
+		handler:     mux,
+		breakers:    breakers,
+		limiters:    limiters,
+		caches:      caches,
+		certificate: config.ingressCertificate,
+		clientAuth:  ingressClientAuth(config.TlsUserConfig.Ingress.ClientAuth),
+		clientCAs:   config.ingressClientCAs,
+	}, nil
+}
+
+// setLogLevel applies the log level of the configuration
+func setLogLevel(level string) {
+	var newLogLevel slog.LevelVar
+	err := json.Unmarshal([]byte("\""+level+"\""), &newLogLevel)
+
+	if err == nil && newLogLevel != *logLevel {
+
+		logger.Info(
+			"log level changed",
+			slog.String("log_level", newLogLevel.Level().String()),
+		)
+		logLevel.Set(newLogLevel.Level())
+
+	}
+}
+
+// ----------- do function - Sets up service bus, isalive, fires of http(s) server  -----------------
+
+func do(ctx context.Context, cancelTheContext context.CancelFunc) {
+
+	// Catch SIGHUP early so it doesn't terminate the service
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	defer signal.Stop(reloadSignal)
+
+	// Create NATS client options
+	opts := nats.GetDefaultOptions()
+	opts.Url = globalConfig.ServiceBusConfig.Hostname
+	opts.Token = globalConfig.ServiceBusConfig.Token
+	// Set reconnection options
+	opts.ReconnectWait = 3 * time.Second // Wait 3 seconds before trying to reconnect
+	opts.MaxReconnect = 3                // Negative value means unlimited reconnect attempts
+
+	opts.ClosedCB = func(_ *nats.Conn) {
+		logger.Warn(
+			"connection to NATS closed",
+		)
+
+		cancelTheContext()
+	}
+	opts.ReconnectedCB = func(nc *nats.Conn) {
+		logger.Info(
+			"reconnected to NATS",
+		)
+
+	}
+	opts.DisconnectedErrCB = func(_ *nats.Conn, err error) {
+		if err != nil {
+			logger.Error(
+				"disconnected from NATS",
+				slog.Any("error", err),
+			)
+			return
+		}
+		logger.Warn(
+			"disconnected from NATS",
+		)
+
+		//cancelTheContext()
+	}
+	nc, err := opts.Connect()
+
+	//nc, err := nats.Connect(, nats.Token(globalConfig.ServiceBusConfig.Token))
+	if err != nil {
+		logger.Error(
+			"issue connecting to nats-server",
+			slog.Any("error", err),
 		)
+
+		cancelTheContext()
+		return
 	}
+	defer nc.Close()
+
+	// publish to topic
+	err = nc.Publish(globalConfig.ServiceBusConfig.Topic, []byte("running & connected to message bus"))
+	if err != nil {
+		logger.Error(
+			"error publishing status to channel",
+			slog.Any("error", err),
+		)
+
+		cancelTheContext()
+		return
+	}
+
+	logger.Info(
+		"service bus initialized",
+	)
+	logger.Info(
+		"starting the service",
+	)
+
+	s := state{m: &sync.Mutex{}}
+
+	// Processors keep running while the server drains
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+
+	firstGateway, err := buildGateway(serveCtx, nc, &globalConfig, nil, &s)
+	if err != nil {
+		logger.Error("error building gateway", slog.Any("error", err))
+		cancelTheContext()
+		return
+	}
+	gateways := &swappableHandler{}
+	gateways.swap(firstGateway)
 
 	// Create a custom writer using the slog logger
 	customWriter := &CustomWriter{logger: logger, supress: 1}
@@ -446,30 +735,65 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 
 	httpServer := &http.Server{
 		Addr:           fmt.Sprintf(":%d", globalConfig.Port),
-		Handler:        mux,
+		Handler:        gateways,
 		ErrorLog:       customLogger,
 		ReadTimeout:    200 * time.Second,
 		WriteTimeout:   200 * time.Second,
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig: &tls.Config{
+			// The certificate and client verification follow the configuration on reload
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				current := gateways.current()
+				return &tls.Config{
+					Certificates: []tls.Certificate{*current.certificate},
+					ClientAuth:   current.clientAuth,
+					ClientCAs:    current.clientCAs,
+					NextProtos:   []string{"h2", "http/1.1"},
+				}, nil
+			},
+		},
 	}
 
 	go func() {
 
 		if !globalConfig.TlsUserConfig.Ingress.Enabled {
 			err := httpServer.ListenAndServe()
-			if err != nil {
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				logger.Error("error starting http server", slog.Any("error", err))
 				cancelTheContext()
 			}
 			return
 		}
-		err := httpServer.ListenAndServeTLS(globalConfig.TlsUserConfig.Ingress.CertificateFile, globalConfig.TlsUserConfig.Ingress.PrivateKeyFile)
-		if err != nil {
+		err := httpServer.ListenAndServeTLS("", "")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("error starting https server", slog.Any("error", err))
 			cancelTheContext()
 		}
 
 	}()
+	servers := []*http.Server{httpServer}
+
+	if globalConfig.MetricsPort != 0 {
+		// Separate admin port, not reachable through the routers
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", glMetrics.registry.Handler())
+		metricsServer := &http.Server{
+			Addr:         fmt.Sprintf(":%d", globalConfig.MetricsPort),
+			Handler:      metricsMux,
+			ErrorLog:     customLogger,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("error starting metrics server", slog.Any("error", err))
+				cancelTheContext()
+			}
+		}()
+		servers = append(servers, metricsServer)
+		logger.Info("metrics server initialized", slog.Int("port", globalConfig.MetricsPort))
+	}
 
 	logger.Info(
 		"http(s) server initialized",
@@ -477,28 +801,20 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 
 	_ = nc.Publish(globalConfig.ServiceBusConfig.Topic, []byte("HTTP(s) server listening"))
 
-	var newLogLevel slog.LevelVar
-	err = json.Unmarshal([]byte("\""+globalConfig.LogLevel+"\""), &newLogLevel)
-
-	if err == nil && newLogLevel != *logLevel {
-
-		logger.Info(
-			"log level changed",
-			slog.String("log_level", newLogLevel.Level().String()),
-		)
-		logLevel.Set(newLogLevel.Level())
+	setLogLevel(globalConfig.LogLevel)
 
-	}
 	// Start Isalive service
 	sub, err := nc.Subscribe(globalConfig.ServiceBusConfig.TopicExactIsAlive, func(msg *nats.Msg) {
 
+		body := globalConfig.IsAliveData
+		body.CircuitBreakers = gateways.current().breakers.states()
 		response := struct {
 			Status int
 			Body   isAliveBody
 			Error  error
 		}{
 			Status: http.StatusOK,
-			Body:   globalConfig.IsAliveData,
+			Body:   body,
 			Error:  nil,
 		}
 
@@ -517,10 +833,69 @@ func do(ctx context.Context, cancelTheContext context.CancelFunc) {
 	}
 	defer sub.Unsubscribe()
 
-	healthyChecksumHandler(ctx, cancelTheContext) // Write the checksum to a file, IE we are healthy
+	configReloader := &reloader{
+		filename: globalConfig.configFile,
+		gateways: gateways,
+		build: func(config *gl_config) (*gateway, error) {
+			return buildGateway(serveCtx, nc, config, gateways.current(), &s)
+		},
+		report: func(status string) {
+			err := nc.Publish(globalConfig.ServiceBusConfig.Topic, []byte(status))
+			if err != nil {
+				logger.Error("error publishing status to channel", slog.Any("error", err))
+				glMetrics.natsPublishFailures.Inc(globalConfig.ServiceBusConfig.Topic)
+			}
+		},
+	}
+
+	if globalConfig.ServiceBusConfig.TopicExactReload != "" {
+		reloadSub, err := nc.Subscribe(globalConfig.ServiceBusConfig.TopicExactReload, func(msg *nats.Msg) {
+			response := struct {
+				Status int
+				Error  string `json:",omitempty"`
+			}{
+				Status: http.StatusOK,
+			}
+			err := configReloader.reload("service bus")
+			if err != nil {
+				response.Status = http.StatusBadRequest
+				response.Error = err.Error()
+			}
+			if msg.Reply == "" {
+				return
+			}
+			byteString, _ := json.Marshal(&response)
+			nc.Publish(msg.Reply, byteString)
+		})
+		if err != nil {
+			logger.Error(
+				"error subscribing to reload channel",
+				slog.Any("error", err),
+			)
 
-	<-ctx.Done()
+			cancelTheContext()
+			return
+		}
+		defer reloadSub.Unsubscribe()
+	}
 
+	healthyChecksumHandler(ctx, cancelTheContext) // Write the checksum to a file, IE we are healthy
+
+	for {
+		select {
+		case <-reloadSignal:
+			configReloader.reload("SIGHUP")
+		case <-ctx.Done():
+			globalConfig.m.Lock()
+			drain := globalConfig.ShutdownTimeout
+			globalConfig.m.Unlock()
+			if drain == 0 {
+				drain = DEFAULT_SHUTDOWN_TIMEOUT
+			}
+			shutdown(servers, stopServing, nc, time.Duration(drain)*time.Millisecond)
+			return
+		}
+	}
 }
 
 // ----------- Populates configuration, basic checks -----------------
@@ -617,6 +992,171 @@ func healthyChecksumHandler(ctx context.Context, cancelTheContext context.Cancel
 	}()
 }
 
+// parseConfig loads the configuration string into g and validates it
+func parseConfig(config string, g *gl_config) error {
+	err := updateConfiguration(config, g)
+	if err != nil {
+		logger.Error(
+			"error loading configuration",
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	validRouters := []router.Router{}
+	rejectedFields := validate.ValidationErrors{}
+	for index, candidateRouter := range g.Routers {
+		e := candidateRouter.Validate()
+		if e != nil {
+			for k, v := range e {
+				rejectedFields[fmt.Sprintf("%s.Routers[%d].%s", CONFIG_NAME, index, k)] = v
+			}
+
+			continue
+		}
+		validRouters = append(validRouters, candidateRouter)
+	}
+	g.Routers = validRouters
+
+	validationErrors := g.Validate()
+	if validationErrors != nil {
+		if len(rejectedFields) != 0 {
+			logger.Warn(
+				"configuration has rejected fields",
+				slog.Any("rejected_fields", rejectedFields),
+			)
+
+		}
+		logger.Error(
+			"configuration file validation failed",
+			slog.Any("validation_errors", validationErrors),
+		)
+
+		return fmt.Errorf("error validating configuration")
+	}
+
+	// Populate maskedHeadersMap
+	g.maskedHeadersMap = map[string]struct{}{}
+	for _, header := range g.MaskedHeaders {
+		g.maskedHeadersMap[header] = struct{}{}
+	}
+
+	// Populate removeHeadersMap
+	g.removeHeadersMap = map[string]struct{}{}
+	for _, header := range g.RemoveHeaders {
+		g.removeHeadersMap[header] = struct{}{}
+	}
+
+	logger.Info(
+		"configuration file valid",
+		slog.String("configuration", g.String()),
+	)
+
+	if len(rejectedFields) != 0 {
+		logger.Warn(
+			"configuration has rejected fields",
+			slog.Any("rejected_fields", rejectedFields),
+		)
+
+	}
+
+	return nil
+}
+
+// setupTLS creates the outbound client and loads the ingress certificate of g
+func setupTLS(g *gl_config) error {
+	err := func() error {
+		// TLS outbound setup
+		if g.TlsUserConfig.Outbound.InsecureFlag {
+			// Skip TLS verification
+			g.client = &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				}}
+			return nil
+		}
+
+		caCertPool := x509.NewCertPool()
+		if g.TlsUserConfig.Outbound.SystemCertPoolFlag {
+			// Load system cert pool
+			systemCertPool, err := x509.SystemCertPool()
+			if err != nil {
+				return fmt.Errorf("config: Error loading system cert pool: %v", err)
+			}
+			caCertPool = systemCertPool
+		}
+
+		// Load custom CA certificates
+		for _, filename := range g.TlsUserConfig.Outbound.CertFiles {
+			caCert, err := os.ReadFile(filename)
+			if err != nil {
+				return fmt.Errorf("config: Error reading CA certificate file: %v", err)
+			}
+			caCertPool.AppendCertsFromPEM(caCert)
+		}
+
+		g.tlsConfig = &tls.Config{
+			RootCAs: caCertPool,
+		}
+
+		tr := &http.Transport{
+			TLSClientConfig: g.tlsConfig,
+		}
+		g.client = &http.Client{Transport: tr}
+		return nil
+	}()
+	if err != nil {
+		logger.Error(
+			"error setting up TLS outbound",
+			slog.Any("error", err),
+		)
+
+		return fmt.Errorf("config: Error setting up TLS: %v", err)
+	}
+
+	// tls inbound setup
+	if g.TlsUserConfig.Ingress.Enabled {
+		// Check if the certificate and key files exist and are readable
+		file, err := os.Open(g.TlsUserConfig.Ingress.CertificateFile)
+		if err != nil {
+			logger.Error("cannot read certificate file", slog.Any("error", err), slog.String("file", g.TlsUserConfig.Ingress.CertificateFile))
+			return fmt.Errorf("cannot read certificate file: %v", err)
+		}
+		file.Close()
+		file, err = os.Open(g.TlsUserConfig.Ingress.PrivateKeyFile)
+		if err != nil {
+			logger.Error("cannot read private key file", slog.Any("error", err), slog.String("file", g.TlsUserConfig.Ingress.PrivateKeyFile))
+			return fmt.Errorf("cannot read private key file: %v", err)
+		}
+		file.Close()
+
+		certificate, err := tls.LoadX509KeyPair(g.TlsUserConfig.Ingress.CertificateFile, g.TlsUserConfig.Ingress.PrivateKeyFile)
+		if err != nil {
+			logger.Error("cannot load certificate", slog.Any("error", err), slog.String("file", g.TlsUserConfig.Ingress.CertificateFile))
+			return fmt.Errorf("cannot load certificate: %v", err)
+		}
+		g.ingressCertificate = &certificate
+
+		if g.TlsUserConfig.Ingress.ClientAuth != "" {
+			g.ingressClientCAs = x509.NewCertPool()
+			for _, filename := range g.TlsUserConfig.Ingress.ClientCAFiles {
+				caCert, err := os.ReadFile(filename)
+				if err != nil {
+					logger.Error("cannot read client CA file", slog.Any("error", err), slog.String("file", filename))
+					return fmt.Errorf("cannot read client CA file: %v", err)
+				}
+				if !g.ingressClientCAs.AppendCertsFromPEM(caCert) {
+					logger.Error("no certificates in client CA file", slog.String("file", filename))
+					return fmt.Errorf("no certificates in client CA file %s", filename)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func setupConfig(fs *flag.FlagSet, args []string) error {
 
 	// Select flag options and parse
@@ -675,74 +1215,12 @@ func setupConfig(fs *flag.FlagSet, args []string) error {
 	if err != nil {
 		return err
 	}
-	err = updateConfiguration(config, &globalConfig)
+	err = parseConfig(config, &globalConfig)
 	if err != nil {
-		logger.Error(
-			"error loading configuration",
-			slog.Any("error", err),
-		)
-
 		return err
 	}
-
-	validRouters := []router.Router{}
-	rejectedFields := validate.ValidationErrors{}
-	for index, candidateRouter := range globalConfig.Routers {
-		e := candidateRouter.Validate()
-		if e != nil {
-			for k, v := range e {
-				rejectedFields[fmt.Sprintf("%s.Routers[%d].%s", CONFIG_NAME, index, k)] = v
-			}
-
-			continue
-		}
-		validRouters = append(validRouters, candidateRouter)
-	}
-	globalConfig.Routers = validRouters
-
-	validationErrors := globalConfig.Validate()
-	if validationErrors != nil {
-		if len(rejectedFields) != 0 {
-			logger.Warn(
-				"configuration has rejected fields",
-				slog.Any("rejected_fields", rejectedFields),
-			)
-
-		}
-		logger.Error(
-			"configuration file validation failed",
-			slog.Any("validation_errors", validationErrors),
-		)
-
-		return fmt.Errorf("error validating configuration")
-	}
-
-	// Populate maskedHeadersMap
-	globalConfig.maskedHeadersMap = map[string]struct{}{}
-	for _, header := range globalConfig.MaskedHeaders {
-		globalConfig.maskedHeadersMap[header] = struct{}{}
-	}
-
-	// Populate removeHeadersMap
-	globalConfig.removeHeadersMap = map[string]struct{}{}
-	for _, header := range globalConfig.RemoveHeaders {
-		globalConfig.removeHeadersMap[header] = struct{}{}
-	}
 	protectedheader.AddMaskedHeadersOnce(globalConfig.MaskedHeaders)
 
-	logger.Info(
-		"configuration file valid",
-		slog.String("configuration", globalConfig.String()),
-	)
-
-	if len(rejectedFields) != 0 {
-		logger.Warn(
-			"configuration has rejected fields",
-			slog.Any("rejected_fields", rejectedFields),
-		)
-
-	}
-
 	if validateFlag {
 		// Validation of config successful, exit
 		os.Exit(0)
@@ -787,76 +1265,16 @@ func setupConfig(fs *flag.FlagSet, args []string) error {
 		"configuration file checksum calculated",
 		slog.String("checksum", globalConfig.sha256),
 	)
+	globalConfig.configFile = configFilename.Value
 	// sha256sum config.json        # Linux
 	// shasum -a 256 config.json	# Mac
 
 	// Binary specific setup
 	// ...
 
-	err = func() error {
-		// TLS outbound setup
-		if globalConfig.TlsUserConfig.Outbound.InsecureFlag {
-			// Skip TLS verification
-			globalConfig.client = &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				}}
-			return nil
-		}
-
-		caCertPool := x509.NewCertPool()
-		if globalConfig.TlsUserConfig.Outbound.SystemCertPoolFlag {
-			// Load system cert pool
-			systemCertPool, err := x509.SystemCertPool()
-			if err != nil {
-				return fmt.Errorf("config: Error loading system cert pool: %v", err)
-			}
-			caCertPool = systemCertPool
-		}
-
-		// Load custom CA certificates
-		for _, filename := range globalConfig.TlsUserConfig.Outbound.CertFiles {
-			caCert, err := os.ReadFile(filename)
-			if err != nil {
-				return fmt.Errorf("config: Error reading CA certificate file: %v", err)
-			}
-			caCertPool.AppendCertsFromPEM(caCert)
-		}
-
-		globalConfig.tlsConfig = &tls.Config{
-			RootCAs: caCertPool,
-		}
-
-		tr := &http.Transport{
-			TLSClientConfig: globalConfig.tlsConfig,
-		}
-		globalConfig.client = &http.Client{Transport: tr}
-		return nil
-	}()
+	err = setupTLS(&globalConfig)
 	if err != nil {
-		logger.Error(
-			"error setting up TLS outbound",
-			slog.Any("error", err),
-		)
-
-		return fmt.Errorf("config: Error setting up TLS: %v", err)
-	}
-
-	// tls inbound setup
-	if globalConfig.TlsUserConfig.Ingress.Enabled {
-		// Check if the certificate and key files exist and are readable
-		file, err := os.Open(globalConfig.TlsUserConfig.Ingress.CertificateFile)
-		if err != nil {
-			logger.Error("cannot read certificate file", slog.Any("error", err), slog.String("file", globalConfig.TlsUserConfig.Ingress.CertificateFile))
-			return fmt.Errorf("cannot read certificate file: %v", err)
-		}
-		file.Close()
-		file, err = os.Open(globalConfig.TlsUserConfig.Ingress.PrivateKeyFile)
-		if err != nil {
-			logger.Error("cannot read private key file", slog.Any("error", err), slog.String("file", globalConfig.TlsUserConfig.Ingress.PrivateKeyFile))
-			return fmt.Errorf("cannot read private key file: %v", err)
-		}
-		file.Close()
+		return err
 	}
 
 	// Find if we have any required processors
@@ -898,14 +1316,18 @@ func main() {
 	ctx, cancelFunction := context.WithCancel(context.Background())
 	defer cancelFunction()
 
-	go do(ctx, cancelFunction)
+	done := make(chan struct{})
+	go func() {
+		do(ctx, cancelFunction)
+		close(done)
+	}()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	select {
 	case <-c:
 		cancelFunction()
-		time.Sleep(1 * time.Second) // Allow one second for everyone to cleanup
 	case <-ctx.Done():
 	}
+	<-done // Wait for the drain and the pending logs
 }
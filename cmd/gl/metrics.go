@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/direktoren/gecholog/internal/metrics"
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	PROCESSOR_COMPLETED = "completed"
+	PROCESSOR_TIMEOUT   = "timeout"
+	PROCESSOR_FAILED    = "failed"
+)
+
+type gatewayMetrics struct {
+	registry *metrics.Registry
+
+	requests            metrics.Counter
+	requestDuration     metrics.Histogram
+	inFlight            metrics.Gauge
+	processorDuration   metrics.Histogram
+	natsPublishFailures metrics.Counter
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	r := metrics.NewRegistry()
+	return &gatewayMetrics{
+		registry: r,
+		requests: r.NewCounter(
+			"gl_requests_total",
+			"Requests handled by a router.",
+			"router", "status_code", "upstream",
+		),
+		requestDuration: r.NewHistogram(
+			"gl_request_duration_seconds",
+			"Time from ingress to egress.",
+			[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+			"router", "status_code", "upstream",
+		),
+		inFlight: r.NewGauge(
+			"gl_requests_in_flight",
+			"Requests currently being handled.",
+		),
+		processorDuration: r.NewHistogram(
+			"gl_processor_duration_seconds",
+			"Time waiting for a processor.",
+			[]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			"processor", "stage", "outcome",
+		),
+		natsPublishFailures: r.NewCounter(
+			"gl_nats_publish_failures_total",
+			"Messages that could not be published to the service bus.",
+			"subject",
+		),
+	}
+}
+
+var glMetrics = newGatewayMetrics()
+
+// upstreamLabel drops the path and query parameters of the outbound url
+func upstreamLabel(u url.URL) string {
+	if u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// observeRequest records a finished request for the router
+func (gm *gatewayMetrics) observeRequest(routerPath string, crw *GechologResponseWriter) {
+	statusCode := crw.egressStatusCode
+	if crw.streamed {
+		statusCode = crw.inboundStatusCode
+	}
+	labels := []string{routerPath, fmt.Sprintf("%d", statusCode), upstreamLabel(crw.outboundURL)}
+	gm.requests.Inc(labels...)
+	gm.requestDuration.Observe(crw.ingressEgressTimer.GetStop().Sub(crw.ingressEgressTimer.GetStart()).Seconds(), labels...)
+}
+
+// processorOutcome classifies the result of a processor call
+func processorOutcome(err error, completed bool) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+		return PROCESSOR_TIMEOUT
+	}
+	if err != nil || !completed {
+		return PROCESSOR_FAILED
+	}
+	return PROCESSOR_COMPLETED
+}
+
+// observeProcessors records the processors that were called
+func (gm *gatewayMetrics) observeProcessors(stage string, processors []processorconfiguration.ProcessorConfiguration, logEntries []processorLog) {
+	for i, p := range processors {
+		if logEntries[i].outcome == "" {
+			// Not called, no data for the processor
+			continue
+		}
+		duration := logEntries[i].Timestamp.GetStop().Sub(logEntries[i].Timestamp.GetStart()).Seconds()
+		gm.processorDuration.Observe(duration, p.Name, stage, logEntries[i].outcome)
+	}
+}
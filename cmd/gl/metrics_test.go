@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_processorOutcome(t *testing.T) {
+	type testCase struct {
+		name      string
+		err       error
+		completed bool
+		want      string
+	}
+
+	testCases := []testCase{
+		{name: "completed", completed: true, want: PROCESSOR_COMPLETED},
+		{name: "context deadline", err: context.DeadlineExceeded, want: PROCESSOR_TIMEOUT},
+		{name: "wrapped deadline", err: fmt.Errorf("request: %w", context.DeadlineExceeded), want: PROCESSOR_TIMEOUT},
+		{name: "nats timeout", err: nats.ErrTimeout, want: PROCESSOR_TIMEOUT},
+		{name: "no responders", err: nats.ErrNoResponders, want: PROCESSOR_FAILED},
+		{name: "bad response", completed: false, want: PROCESSOR_FAILED},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, processorOutcome(tc.err, tc.completed))
+		})
+	}
+}
+
+func Test_gatewayMetrics(t *testing.T) {
+	gm := newGatewayMetrics()
+
+	start := time.Now()
+	crw := &GechologResponseWriter{
+		egressStatusCode: http.StatusOK,
+		outboundURL:      url.URL{Scheme: "https", Host: "api.example.com", Path: "/v1/chat", RawQuery: "api-key=secret"},
+	}
+	crw.ingressEgressTimer.SetStart(start)
+	crw.ingressEgressTimer.SetStop(start.Add(300 * time.Millisecond))
+	gm.observeRequest("/service/standard/", crw)
+
+	logEntries := []processorLog{{outcome: PROCESSOR_TIMEOUT}, {}}
+	logEntries[0].Timestamp.SetStart(start)
+	logEntries[0].Timestamp.SetStop(start.Add(2 * time.Second))
+	gm.observeProcessors("request", []processorconfiguration.ProcessorConfiguration{{Name: "slow"}, {Name: "skipped"}}, logEntries)
+
+	b := strings.Builder{}
+	assert.NoError(t, gm.registry.Write(&b))
+	exposition := b.String()
+
+	assert.Contains(t, exposition, `gl_requests_total{router="/service/standard/",status_code="200",upstream="https://api.example.com"} 1`)
+	assert.Contains(t, exposition, `gl_request_duration_seconds_bucket{router="/service/standard/",status_code="200",upstream="https://api.example.com",le="0.5"} 1`)
+	assert.Contains(t, exposition, `gl_processor_duration_seconds_count{processor="slow",stage="request",outcome="timeout"} 1`)
+	assert.NotContains(t, exposition, "skipped")
+	assert.NotContains(t, exposition, "secret")
+}
@@ -11,7 +11,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"path"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +22,7 @@ import (
 	"github.com/direktoren/gecholog/internal/sessionid"
 	"github.com/direktoren/gecholog/internal/store"
 	"github.com/direktoren/gecholog/internal/timer"
+	"github.com/direktoren/gecholog/internal/tracing"
 	"github.com/nats-io/nats.go"
 )
 
@@ -64,6 +64,38 @@ type GechologResponseWriter struct {
 
 	transactionID string
 	sessionID     string
+
+	stream   *streamSettings
+	streamed bool
+
+	upstreams       []upstreamTarget
+	outboundSubPath string
+	retry           *router.RetryPolicy
+
+	trace *traceState
+
+	limits        *router.Limits
+	errorCategory string
+
+	consumer string
+
+	routingTarget  string
+	pathParameters map[string]string
+
+	translation string
+
+	ingressBinary []byte // the ingress payload when it is not json
+	inboundBinary bool
+
+	mirrorOf     string // transaction id of the primary request of a shadow request
+	mirrorTarget string
+
+	outboundError string // set when the outbound call got no response
+
+	outboundRouter string       // path of the router that makes the outbound call
+	client         *http.Client // client of the outbound router, nil for the client of the request handler
+
+	method string // the ingress method, also for the post processors
 }
 
 type state struct {
@@ -207,6 +239,18 @@ func loggingPostProcessorFunc(nc *nats.Conn, subject string, recordLastError fun
 
 		store.Store(&crw.rootObject, &crw.rootErrorObject, "session_id", json.RawMessage("\""+crw.sessionID+"\""))
 		store.Store(&crw.rootObject, &crw.rootErrorObject, "transaction_id", json.RawMessage("\""+crw.transactionID+"\""))
+		if crw.trace != nil {
+			store.Store(&crw.rootObject, &crw.rootErrorObject, "trace_id", crw.trace.server.TraceIDString())
+		}
+		if crw.errorCategory != "" {
+			store.Store(&crw.rootObject, &crw.rootErrorObject, "error_category", crw.errorCategory)
+		}
+		if crw.consumer != "" {
+			store.Store(&crw.rootObject, &crw.rootErrorObject, "consumer", crw.consumer)
+		}
+		if crw.mirrorOf != "" {
+			store.Store(&crw.rootObject, &crw.rootErrorObject, "mirror_of", crw.mirrorOf)
+		}
 
 		// Apply the final logger filters to requestObject
 
@@ -259,6 +303,9 @@ func loggingPostProcessorFunc(nc *nats.Conn, subject string, recordLastError fun
 		crw.egressPostTimer.Stop() // <------- POST POINT
 
 		store.Store(&crw.rootObject, &crw.rootErrorObject, "egress_post_timer", crw.egressPostTimer)
+		if crw.trace != nil {
+			defer crw.trace.export(crw)
+		}
 		store.StoreInArray(&crw.rootObject, &crw.rootErrorObject, "error", &crw.rootErrorObject)
 
 		rootBytes, err := json.Marshal(&crw.rootObject)
@@ -285,6 +332,7 @@ func loggingPostProcessorFunc(nc *nats.Conn, subject string, recordLastError fun
 			)
 
 			recordLastError(fmt.Errorf("logger: Problem sending log to service bus: %v", err), time.Now())
+			glMetrics.natsPublishFailures.Inc(subject)
 
 			return
 		}
@@ -309,6 +357,8 @@ func loggingMiddlewareFunc(postProcessingAndFinalizeLogging loggingPostProcessor
 
 			crw := &GechologResponseWriter{
 				ResponseWriter: w,
+				method:         r.Method,
+				mirrorOf:       shadowOf(r).mirrorOf,
 
 				outboundBody:    bytes.NewBufferString(""),
 				outboundHeaders: http.Header{},
@@ -345,16 +395,21 @@ func loggingMiddlewareFunc(postProcessingAndFinalizeLogging loggingPostProcessor
 			}
 
 			crw.ingressEgressTimer.Start()
+			glMetrics.inFlight.Inc()
 
 			// Call the next handler in the chain
 			next.ServeHTTP(crw, r)
 
 			crw.ingressEgressTimer.Stop()
+			glMetrics.inFlight.Dec()
+			if crw.mirrorOf == "" {
+				glMetrics.observeRequest(r.Pattern, crw)
+			}
 
 			if crw.egressStatusCode == http.StatusUnauthorized && !logUnauthorized {
 				return
 			}
-			go postProcessingAndFinalizeLogging(crw)
+			pendingLogs.finalize(postProcessingAndFinalizeLogging, crw)
 
 		})
 	}
@@ -417,39 +472,58 @@ func sessionMiddlewareFunc(gatewayID string, sesssionHeader string, s *state) fu
 	}
 }
 
-func egressResponseMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		crw, ok := w.(*GechologResponseWriter)
-		if !ok {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
-			return
-		}
+// egressResponseMiddlewareFunc writes the egress response, compressed if configured and accepted by the client
+func egressResponseMiddlewareFunc(compression *compressionConfig, s *state) func(http.Handler) http.Handler {
 
-		next.ServeHTTP(crw, r)
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
 
-		for key, values := range crw.egressHeaders {
-			for _, value := range values {
-				crw.Header().Add(key, value)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
 			}
-		}
 
-		if crw.egressBody == nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			logger.Error("egress body is nil")
-			return
-		}
+			next.ServeHTTP(crw, r)
 
-		crw.Header().Set("Content-Length", fmt.Sprintf("%d", crw.egressBody.Len()))
-		crw.WriteHeader(crw.egressStatusCode)
-		_, err := io.Copy(crw, crw.egressBody)
-		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			logger.Error("failed to write body", slog.Any("error", err))
-			return
-		}
+			if crw.streamed {
+				// Headers and body have already been written by the stream
+				return
+			}
 
-	})
+			for key, values := range crw.egressHeaders {
+				for _, value := range values {
+					crw.Header().Add(key, value)
+				}
+			}
+
+			if crw.egressBody == nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("egress body is nil")
+				return
+			}
+
+			encoding := compressEgress(compression, crw, r)
+			if encoding != "" {
+				store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_content_encoding", encoding)
+			}
+
+			crw.Header().Set("Content-Length", fmt.Sprintf("%d", crw.egressBody.Len()))
+			crw.WriteHeader(crw.egressStatusCode)
+			_, err := io.Copy(crw, crw.egressBody)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to write body", slog.Any("error", err))
+				return
+			}
+
+		})
+	}
 }
 
 func ingressEgressHeaderMiddlewareFunc(requiredIngressHeaders protectedheader.ProtectedHeader, removeHeadersMap map[string]struct{}, maskedHeadersMap map[string]struct{}, sessionHeader string, s *state) func(http.Handler) http.Handler {
@@ -484,6 +558,13 @@ func ingressEgressHeaderMiddlewareFunc(requiredIngressHeaders protectedheader.Pr
 		return nil
 	}
 
+	// Compile the patterns once per router
+	requirements, err := protectedheader.CompileRequirements(requiredIngressHeaders)
+	if err != nil {
+		logger.Error("invalid ingress header requirement", slog.Any("error", err))
+		return nil
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			crw, ok := w.(*GechologResponseWriter)
@@ -516,7 +597,7 @@ func ingressEgressHeaderMiddlewareFunc(requiredIngressHeaders protectedheader.Pr
 				store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_headers", &finalEgressHeaders)
 			}()
 
-			if ok, unauthorizedMsg := protectedheader.EqualIfNonEmptyExistsIfCatchall(requiredIngressHeaders, candidateSet); !ok {
+			if ok, unauthorizedMsg := requirements.Check(candidateSet); !ok {
 				crw.egressBody.Write([]byte(`{"error":"unauthorized ` + unauthorizedMsg + `"}`))
 				crw.egressStatusCode = http.StatusUnauthorized
 
@@ -607,128 +688,172 @@ func ingressEgressHeaderMiddlewareFunc(requiredIngressHeaders protectedheader.Pr
 	}
 }
 
-func ingressEgressPayloadMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		crw, ok := w.(*GechologResponseWriter)
-		if !ok {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
-			return
-		}
-
-		ingressBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			crw.egressBody.Write([]byte(`{"error":"internal server error"}`))
-			crw.egressStatusCode = http.StatusInternalServerError
+// ingressEgressPayloadMiddlewareFunc reads the ingress payload. Payloads that are not json are passed
+// through unchanged and logged as metadata, inlined up to inlineMaxBytes
+func ingressEgressPayloadMiddlewareFunc(inlineMaxBytes int, s *state) func(http.Handler) http.Handler {
 
-			logger.Error("failed to read body", slog.Any("error", err))
-			return
-		}
-		if !json.Valid(ingressBytes) {
-			ingressBytes = []byte(fmt.Sprintf("%q", ingressBytes))
-			crw.requestErrorObject.AssignField("ingress_payload", "Payload not a valid json")
-		}
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
 
-		// Populate ingress_payload field so we can send to processors
-		store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_payload", ingressBytes)
-		store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", ingressBytes)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
 
-		next.ServeHTTP(crw, r)
+			ingressBytes, err := io.ReadAll(r.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				crw.egressBody.Write([]byte(`{"error":"request body too large"}`))
+				crw.egressStatusCode = http.StatusRequestEntityTooLarge
 
-		if crw.egressBody.Len() == 0 {
-			// If egress body is not already set, we fetch it from the responseObject
-			egressBytes, err := crw.responseObject.GetField("egress_payload")
-			if err != nil {
-				crw.responseErrorObject.AssignField("egress_payload", err.Error())
-				egressBytes = json.RawMessage(`{"error":"internal server error"}`)
-				crw.egressStatusCode = http.StatusInternalServerError
+				crw.setErrorCategory(ERROR_CATEGORY_REQUEST_TOO_LARGE, fmt.Sprintf("ingress payload exceeds %d bytes", maxBytesErr.Limit))
+				return
 			}
-			_, err = crw.egressBody.Write(egressBytes)
 			if err != nil {
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				crw.responseErrorObject.AssignField("egress_payload", err.Error())
+				crw.egressBody.Write([]byte(`{"error":"internal server error"}`))
+				crw.egressStatusCode = http.StatusInternalServerError
 
-				logger.Error("failed to write body", slog.Any("error", err))
+				logger.Error("failed to read body", slog.Any("error", err))
 				return
 			}
-		}
+			if !json.Valid(ingressBytes) {
+				crw.ingressBinary = ingressBytes
+				ingressBytes = binaryPayloadLog(r.Header.Get("Content-Type"), ingressBytes, inlineMaxBytes)
+			}
 
-		defer func() {
-			store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_status_code", crw.egressStatusCode)
-			if crw.egressStatusCode != http.StatusOK {
-				crw.responseErrorObject.AssignField("egress_status_code", fmt.Sprintf("%d", crw.egressStatusCode))
+			// Populate ingress_payload field so we can send to processors
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_payload", ingressBytes)
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", ingressBytes)
+
+			next.ServeHTTP(crw, r)
+
+			if crw.egressBody.Len() == 0 && crw.inboundBinary {
+				// Payloads that are not json are passed through unchanged
+				egressPayload, egressErr := crw.responseObject.GetField("egress_payload")
+				inboundPayload, inboundErr := crw.responseObject.GetField("inbound_payload")
+				if egressErr == nil && inboundErr == nil && !sameJSON(egressPayload, inboundPayload) {
+					crw.responseErrorObject.AssignField("egress_payload", BINARY_PAYLOAD_CHANGED)
+				}
+				crw.egressBody.Write(crw.inboundBody.Bytes())
 			}
-		}()
+			if crw.egressBody.Len() == 0 {
+				// If egress body is not already set, we fetch it from the responseObject
+				egressBytes, err := crw.responseObject.GetField("egress_payload")
+				if err != nil {
+					crw.responseErrorObject.AssignField("egress_payload", err.Error())
+					egressBytes = json.RawMessage(`{"error":"internal server error"}`)
+					crw.egressStatusCode = http.StatusInternalServerError
+				}
+				_, err = crw.egressBody.Write(egressBytes)
+				if err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					crw.responseErrorObject.AssignField("egress_payload", err.Error())
 
-		if crw.egressStatusCode != http.StatusOK {
-			// if egressStatusCode is already set, we don't need to do anything more
-			return
-		}
+					logger.Error("failed to write body", slog.Any("error", err))
+					return
+				}
+			}
 
-		egressStatusCodeRaw, err := crw.responseObject.GetField("egress_status_code")
-		if err != nil {
-			crw.responseErrorObject.AssignField("egress_status_code", err.Error())
-			crw.egressStatusCode = http.StatusInternalServerError
-			return
-		}
-		var egressStatusCodeTmp int
-		err = json.Unmarshal(egressStatusCodeRaw, &egressStatusCodeTmp)
-		if err != nil {
-			crw.responseErrorObject.AssignField("egress_status_code", err.Error())
-			crw.egressStatusCode = http.StatusInternalServerError
-			return
-		}
+			defer func() {
+				store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_status_code", crw.egressStatusCode)
+				if crw.egressStatusCode != http.StatusOK {
+					crw.responseErrorObject.AssignField("egress_status_code", fmt.Sprintf("%d", crw.egressStatusCode))
+				}
+			}()
 
-		crw.egressStatusCode = egressStatusCodeTmp
+			if crw.egressStatusCode != http.StatusOK {
+				// if egressStatusCode is already set, we don't need to do anything more
+				return
+			}
 
-	})
+			egressStatusCodeRaw, err := crw.responseObject.GetField("egress_status_code")
+			if err != nil {
+				crw.responseErrorObject.AssignField("egress_status_code", err.Error())
+				crw.egressStatusCode = http.StatusInternalServerError
+				return
+			}
+			var egressStatusCodeTmp int
+			err = json.Unmarshal(egressStatusCodeRaw, &egressStatusCodeTmp)
+			if err != nil {
+				crw.responseErrorObject.AssignField("egress_status_code", err.Error())
+				crw.egressStatusCode = http.StatusInternalServerError
+				return
+			}
+
+			crw.egressStatusCode = egressStatusCodeTmp
+
+		})
+	}
 }
 
-func outboundInboundPayloadMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		crw, ok := w.(*GechologResponseWriter)
-		if !ok {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
-			return
-		}
+// outboundInboundPayloadMiddlewareFunc writes the outbound payload and logs the inbound payload. Payloads
+// that are not json are passed through unchanged and logged as metadata, inlined up to inlineMaxBytes
+func outboundInboundPayloadMiddlewareFunc(inlineMaxBytes int, s *state) func(http.Handler) http.Handler {
 
-		payload, err := crw.requestObject.GetField("outbound_payload")
-		if err != nil {
-			// We don't seem to have a valid payload
-			crw.egressBody.Write([]byte(`{"error":"internal server error"}`))
-			crw.egressStatusCode = http.StatusInternalServerError
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
 
-			crw.responseErrorObject.AssignField("outbound_payload", err.Error())
-			return
-		}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
 
-		if !json.Valid(payload) {
-			crw.requestErrorObject.AssignField("outbound_payload", "Payload not a valid json2")
-		}
-		crw.outboundBody.Write(payload)
+			payload, err := crw.requestObject.GetField("outbound_payload")
+			if err != nil {
+				// We don't seem to have a valid payload
+				crw.egressBody.Write([]byte(`{"error":"internal server error"}`))
+				crw.egressStatusCode = http.StatusInternalServerError
 
-		crw.ingressOutboundTimer.SetStart(crw.ingressEgressTimer.GetStart())
-		crw.ingressOutboundTimer.Stop()
+				crw.responseErrorObject.AssignField("outbound_payload", err.Error())
+				return
+			}
 
-		next.ServeHTTP(crw, r)
+			if crw.ingressBinary != nil {
+				// Payloads that are not json are passed through unchanged
+				if ingressPayload, err := crw.requestObject.GetField("ingress_payload"); err == nil && !sameJSON(payload, ingressPayload) {
+					crw.requestErrorObject.AssignField("outbound_payload", BINARY_PAYLOAD_CHANGED)
+				}
+				payload = crw.ingressBinary
+			} else if !json.Valid(payload) {
+				crw.requestErrorObject.AssignField("outbound_payload", "Payload not a valid json2")
+			}
+			crw.outboundBody.Write(payload)
 
-		crw.outboundInboundTimer.SetStart(crw.ingressOutboundTimer.GetStop())
-		crw.outboundInboundTimer.Stop()
+			crw.ingressOutboundTimer.SetStart(crw.ingressEgressTimer.GetStart())
+			crw.ingressOutboundTimer.Stop()
 
-		store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_status_code", crw.inboundStatusCode)
-		store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_status_code", crw.inboundStatusCode)
+			next.ServeHTTP(crw, r)
 
-		if !json.Valid(crw.inboundBody.Bytes()) {
-			crw.responseErrorObject.AssignField("inbound_payload", "Payload not a valid json3")
-			store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_payload", []byte(fmt.Sprintf("%q", crw.inboundBody.Bytes())))
-			store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_payload", []byte(fmt.Sprintf("%q", crw.inboundBody.Bytes())))
-			return
-		}
+			crw.outboundInboundTimer.SetStart(crw.ingressOutboundTimer.GetStop())
+			crw.outboundInboundTimer.Stop()
 
-		store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_payload", crw.inboundBody.Bytes())
-		store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_payload", crw.inboundBody.Bytes())
-	})
+			store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_status_code", crw.inboundStatusCode)
+			store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_status_code", crw.inboundStatusCode)
+
+			if !json.Valid(crw.inboundBody.Bytes()) {
+				crw.inboundBinary = true
+				inboundLog := binaryPayloadLog(crw.inboundHeaders.Get("Content-Type"), crw.inboundBody.Bytes(), inlineMaxBytes)
+				store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_payload", inboundLog)
+				store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_payload", inboundLog)
+				return
+			}
+
+			store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_payload", crw.inboundBody.Bytes())
+			store.Store(&crw.responseObject, &crw.responseErrorObject, "egress_payload", crw.inboundBody.Bytes())
+		})
+	}
 }
 
 func controlFieldMiddleware(next http.Handler) http.Handler {
@@ -959,7 +1084,7 @@ func outboundInboundHeaderMiddlewareFunc(staticOutboundHeaders protectedheader.P
 			}()
 
 			outboundHeaders := protectedheader.ProtectedHeader{}
-			outboundHeaders = protectedheader.AppendNew(outboundHeaders, staticOutboundHeaders)
+			outboundHeaders = protectedheader.AppendNew(outboundHeaders, expandHeaders(staticOutboundHeaders, crw.pathParameters))
 			outboundHeaders = protectedheader.AppendNew(outboundHeaders, outboundProcessedHeaders)
 
 			removedHeaders := protectedheader.ProtectedHeader{}
@@ -1100,12 +1225,12 @@ func writeData(o *gechologobject.GechoLogObject, e *gechologobject.GechoLogObjec
 	return true
 }
 
-type processorsMiddlewareFunc func(ctx context.Context, nc *nats.Conn, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler
+type processorsMiddlewareFunc func(ctx context.Context, caller *processorCaller, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler
 
-func requestProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler {
+func requestProcessorMiddlewareFunc(ctx context.Context, caller *processorCaller, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler {
 
-	if nc == nil {
-		logger.Error("nats.Conn is empty")
+	if caller == nil {
+		logger.Error("processorCaller is nil")
 		return nil
 	}
 
@@ -1119,6 +1244,12 @@ func requestProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processo
 		return nil
 	}
 
+	conditions, err := compileProcessorConditions(processor)
+	if err != nil {
+		logger.Error("invalid processor condition", slog.Any("error", err))
+		return nil
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			crw, ok := w.(*GechologResponseWriter)
@@ -1137,10 +1268,16 @@ func requestProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processo
 			}
 
 			data := make([][]byte, len(processor))
+			object := conditionObject(&crw.requestObject)
 			for i, p := range processor {
+				if !conditions[i].matches(&crw.requestObject, object, crw.method, 0) {
+					logEntries[i].Skipped = true
+					continue
+				}
 				data[i] = extractData(&crw.requestObject, p)
 			}
 
+			contexts := make([]tracing.SpanContext, len(processor))
 			wg := sync.WaitGroup{}
 			m := sync.Mutex{}
 			for i, p := range processor {
@@ -1149,34 +1286,46 @@ func requestProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processo
 					go func(i int, p processorconfiguration.ProcessorConfiguration) {
 
 						defer wg.Done()
+						var callErr error
 						response := func() []byte {
 							logEntries[i].Timestamp.Start()
 							defer logEntries[i].Timestamp.Stop()
 							logger.Debug("timeout", slog.String("processor", p.Name), slog.Any("timeout", p.Timeout), slog.Any("duration", time.Duration(p.Timeout)*time.Millisecond))
 							ctxTimeout, cancel := context.WithTimeout(ctx, time.Duration(p.Timeout)*time.Millisecond)
 							defer cancel()
-							msg, err := nc.RequestWithContext(ctxTimeout, p.ServiceBusTopic, data[i])
+							var sc *tracing.SpanContext
+							if crw.trace != nil {
+								contexts[i] = crw.trace.server.Child()
+								sc = &contexts[i]
+							}
+							answer, err := caller.call(ctxTimeout, p, data[i], sc)
 							if err != nil {
+								callErr = err
 								logger.Error("failed request processor", slog.Any("error", err))
 								m.Lock()
 								crw.requestErrorObject.AssignField(p.Name, err.Error())
 								m.Unlock()
 								return []byte{}
 							}
-							return msg.Data
+							return answer
 						}()
 						if len(response) != 0 {
 							m.Lock()
 							logEntries[i].Completed = writeData(&crw.requestObject, &crw.requestErrorObject, p, response)
 							m.Unlock()
 						}
+						logEntries[i].outcome = processorOutcome(callErr, logEntries[i].Completed)
 					}(i, p)
 				}
 			}
 			wg.Wait()
+			glMetrics.observeProcessors("request", processor, logEntries)
+			if crw.trace != nil {
+				crw.trace.processorSpans("request", processor, logEntries, contexts)
+			}
 
 			for i, p := range processor {
-				if !logEntries[i].Completed && p.Required {
+				if !logEntries[i].Completed && !logEntries[i].Skipped && p.Required {
 					crw.requestErrorObject.AssignField(p.Name, "required processor didn't run")
 					return
 				}
@@ -1194,10 +1343,10 @@ func requestProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processo
 	}
 }
 
-func responseProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler {
+func responseProcessorMiddlewareFunc(ctx context.Context, caller *processorCaller, processor []processorconfiguration.ProcessorConfiguration, s *state) func(http.Handler) http.Handler {
 
-	if nc == nil {
-		logger.Error("nats.Conn is empty")
+	if caller == nil {
+		logger.Error("processorCaller is nil")
 		return nil
 	}
 
@@ -1211,6 +1360,12 @@ func responseProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, process
 		return nil
 	}
 
+	conditions, err := compileProcessorConditions(processor)
+	if err != nil {
+		logger.Error("invalid processor condition", slog.Any("error", err))
+		return nil
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			crw, ok := w.(*GechologResponseWriter)
@@ -1231,10 +1386,16 @@ func responseProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, process
 			}
 
 			data := make([][]byte, len(processor))
+			object := conditionObject(&crw.responseObject)
 			for i, p := range processor {
+				if !conditions[i].matches(&crw.responseObject, object, crw.method, crw.inboundStatusCode) {
+					logEntries[i].Skipped = true
+					continue
+				}
 				data[i] = extractData(&crw.responseObject, p)
 			}
 
+			contexts := make([]tracing.SpanContext, len(processor))
 			wg := sync.WaitGroup{}
 			m := sync.Mutex{}
 			for i, p := range processor {
@@ -1243,36 +1404,48 @@ func responseProcessorMiddlewareFunc(ctx context.Context, nc *nats.Conn, process
 					go func(i int, p processorconfiguration.ProcessorConfiguration) {
 
 						defer wg.Done()
+						var callErr error
 						response := func() []byte {
 							logEntries[i].Timestamp.Start()
 							defer logEntries[i].Timestamp.Stop()
 							logger.Debug("timeout", slog.String("processor", p.Name), slog.Any("timeout", p.Timeout), slog.Any("duration", time.Duration(p.Timeout)*time.Millisecond), slog.String("now", time.Now().String()))
 							ctxTimeout, cancel := context.WithTimeout(ctx, time.Duration(p.Timeout)*time.Millisecond)
 							defer cancel()
-							msg, err := nc.RequestWithContext(ctxTimeout, p.ServiceBusTopic, data[i])
+							var sc *tracing.SpanContext
+							if crw.trace != nil {
+								contexts[i] = crw.trace.server.Child()
+								sc = &contexts[i]
+							}
+							answer, err := caller.call(ctxTimeout, p, data[i], sc)
 							logger.Debug("after", slog.String("processor", p.Name), slog.Any("timeout", p.Timeout), slog.Any("duration", time.Duration(p.Timeout)*time.Millisecond), slog.String("now", time.Now().String()))
 
 							if err != nil {
+								callErr = err
 								logger.Error("failed response processor", slog.Any("error", err))
 								m.Lock()
 								crw.responseErrorObject.AssignField(p.Name, err.Error())
 								m.Unlock()
 								return []byte{}
 							}
-							return msg.Data
+							return answer
 						}()
 						if len(response) != 0 {
 							m.Lock()
 							logEntries[i].Completed = writeData(&crw.responseObject, &crw.responseErrorObject, p, response)
 							m.Unlock()
 						}
+						logEntries[i].outcome = processorOutcome(callErr, logEntries[i].Completed)
 					}(i, p)
 				}
 			}
 			wg.Wait()
+			glMetrics.observeProcessors("response", processor, logEntries)
+			if crw.trace != nil {
+				crw.trace.processorSpans("response", processor, logEntries, contexts)
+			}
 
 			for i, p := range processor {
-				if !logEntries[i].Completed && p.Required {
+				if !logEntries[i].Completed && !logEntries[i].Skipped && p.Required {
 					crw.responseErrorObject.AssignField(p.Name, "required processor didn't run")
 					return
 				}
@@ -1294,6 +1467,7 @@ func ingressPathMiddlewareFunc(thisRouter router.Router, s *state) func(http.Han
 		logger.Error("state is nil")
 		return nil
 	}
+	names := thisRouter.PathParameters()
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			crw, ok := w.(*GechologResponseWriter)
@@ -1305,6 +1479,18 @@ func ingressPathMiddlewareFunc(thisRouter router.Router, s *state) func(http.Han
 
 			prefix := thisRouter.Path
 			ingressSubPath := strings.TrimPrefix(r.URL.Path, prefix)
+			if len(names) != 0 {
+				pathParameters, err := capturePathParameters(r, prefix, names)
+				if err != nil {
+					crw.egressBody.Write([]byte(`{"error":"invalid path parameter"}`))
+					crw.egressStatusCode = http.StatusBadRequest
+					logger.Warn("invalid path parameter", slog.String("transaction_id", crw.transactionID), slog.Any("error", err))
+					return
+				}
+				crw.pathParameters = pathParameters
+				ingressSubPath = strings.TrimPrefix(r.URL.Path, router.ExpandPathParameters(prefix, crw.pathParameters))
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "path_parameters", crw.pathParameters)
+			}
 
 			store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", &prefix)
 			store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_subpath", &ingressSubPath)
@@ -1315,13 +1501,23 @@ func ingressPathMiddlewareFunc(thisRouter router.Router, s *state) func(http.Han
 	}
 }
 
-func outboundInboundPathMiddlewareFunc(thisRouter router.Router, listOfRouters []router.Router, s *state) func(http.Handler) http.Handler {
+func outboundInboundPathMiddlewareFunc(thisRouter router.Router, listOfRouters []router.Router, balancers map[string]*targetBalancer, clients map[string]*http.Client, s *state) func(http.Handler) http.Handler {
 
 	if len(listOfRouters) == 0 {
 		logger.Error("listOfRouters is empty")
 		return nil
 	}
 
+	if balancers == nil {
+		logger.Error("balancers is nil")
+		return nil
+	}
+
+	if clients == nil {
+		logger.Error("clients is nil")
+		return nil
+	}
+
 	if s == nil {
 		logger.Error("state is nil")
 		return nil
@@ -1362,9 +1558,30 @@ func outboundInboundPathMiddlewareFunc(thisRouter router.Router, listOfRouters [
 					}
 				}
 			}
-			routerParsedURL, _ := url.Parse(outboundRouter.Outbound.Url)        // We trust this works since checks are made of the config
+			balancer, exists := balancers[outboundRouter.Path]
+			if !exists {
+				crw.egressBody.Write([]byte(`{"error":"internal server error"}`))
+				crw.egressStatusCode = http.StatusInternalServerError
+
+				logger.Error("no balancer for router", slog.String("path", outboundRouter.Path))
+				return
+			}
+			crw.upstreams = balancer.candidates()
+			if crw.routingTarget != "" && outboundRouter.Path == thisRouter.Path {
+				crw.upstreams = pinTarget(crw.upstreams, crw.routingTarget)
+			}
+			if crw.mirrorTarget != "" && outboundRouter.Path == thisRouter.Path {
+				crw.upstreams = pinTarget(crw.upstreams, crw.mirrorTarget)
+			}
+			if len(crw.pathParameters) != 0 {
+				crw.upstreams = expandUpstreams(crw.upstreams, crw.pathParameters)
+			}
+			crw.retry = outboundRouter.Retry
+			crw.translation = outboundRouter.Translation
+			crw.outboundRouter = outboundRouter.Path
+			crw.client = clients[outboundRouter.Path]
 			endpointParsedURL, _ := url.Parse(outboundRouter.Outbound.Endpoint) // We trust this works since checks are made of the config
-			endpointPath := endpointParsedURL.Path
+			endpointPath := router.ExpandPathParameters(endpointParsedURL.Path, crw.pathParameters)
 
 			outboundSubPath := func() string {
 
@@ -1388,12 +1605,8 @@ func outboundInboundPathMiddlewareFunc(thisRouter router.Router, listOfRouters [
 				return tmpOutboundSubPath
 			}()
 
-			crw.outboundURL.Scheme = routerParsedURL.Scheme
-			crw.outboundURL.Host = routerParsedURL.Host
-			crw.outboundURL.Path = path.Join(routerParsedURL.Path, outboundSubPath)
-			if strings.HasSuffix(strings.Trim(routerParsedURL.Path+outboundSubPath, " "), "/") {
-				crw.outboundURL.Path += "/"
-			}
+			crw.outboundSubPath = outboundSubPath
+			crw.outboundURL = outboundTargetURL(crw.upstreams[0].url, outboundSubPath)
 
 			store.Store(&crw.requestObject, &crw.requestErrorObject, "url_path", crw.outboundURL.String())
 
@@ -1404,6 +1617,63 @@ func outboundInboundPathMiddlewareFunc(thisRouter router.Router, listOfRouters [
 	}
 }
 
+// outboundErrorStatusCode maps a failed outbound call to the inbound status code
+func outboundErrorStatusCode(err error) int {
+	// Check if it's a timeout
+	if strings.Contains(err.Error(), "Client.Timeout") || strings.Contains(err.Error(), "context deadline exceeded") {
+		// 504 Gateway Timeout
+		return http.StatusGatewayTimeout
+	}
+
+	// Check for DNS resolution errors
+	var dnsErr *net.DNSError
+	if ok := errors.As(err, &dnsErr); ok {
+		// 503 Service Unavailable
+		return http.StatusServiceUnavailable
+	}
+
+	// Check if the connection was refused
+	var opErr *net.OpError
+	if ok := errors.As(err, &opErr); ok && opErr.Op == "dial" {
+		// 502 Bad Gateway (Connection refused)
+		return http.StatusBadGateway
+	}
+
+	// SSL/TLS errors
+	if strings.Contains(err.Error(), "x509: certificate") || strings.Contains(err.Error(), "tls: handshake") {
+		// 502 Bad Gateway (TLS/SSL Error)
+		return http.StatusBadGateway
+	}
+
+	// Generic network errors
+	if strings.Contains(err.Error(), "no such host") || strings.Contains(err.Error(), "network is unreachable") {
+		// 503 Service Unavailable (Network Error)
+		return http.StatusServiceUnavailable
+	}
+
+	logger.Warn("failed to make request", slog.Any("error", err))
+	return http.StatusInternalServerError
+}
+
+// Log entry for each outbound call
+type outboundAttempt struct {
+	Attempt    int         `json:"attempt"`
+	Target     int         `json:"target"`
+	Url        string      `json:"url"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Backoff    int64       `json:"backoff,omitempty"`
+	Timer      timer.Timer `json:"timer"`
+}
+
+type callOutcome int
+
+const (
+	CALL_SERVED   callOutcome = iota // Response written to crw
+	CALL_FAILOVER                    // Try the next target
+	CALL_RETRY                       // Start a new round after backoff
+)
+
 func standardRequestFunc(myClient *http.Client) http.Handler {
 
 	if myClient == nil {
@@ -1419,86 +1689,278 @@ func standardRequestFunc(myClient *http.Client) http.Handler {
 			return
 		}
 
-		outboundRequest, err := http.NewRequest(r.Method, crw.outboundURL.String(), crw.outboundBody)
-		if err != nil {
-			crw.inboundBody.WriteString(`{"error":"internal server error"}`)
-			crw.inboundStatusCode = http.StatusInternalServerError
+		payload := crw.outboundBody.Bytes()
+		rawQuery := crw.outboundURL.RawQuery
 
-			logger.Error("failed to create request", slog.Any("error", err))
-			return
+		if crw.trace != nil {
+			tracing.Inject(crw.outboundHeaders, crw.trace.outbound)
 		}
 
-		for key, values := range crw.outboundHeaders {
-			for _, value := range values {
-				outboundRequest.Header.Add(key, value)
-			}
+		attempts := []outboundAttempt{}
+		defer func() {
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_attempts", &attempts)
+		}()
+
+		if len(crw.upstreams) == 0 {
+			// No balancer in the chain, call the outbound url as is
+			fixedURL := crw.outboundURL
+			crw.upstreams = []upstreamTarget{{url: &fixedURL}}
 		}
 
-		resp, err := myClient.Do(outboundRequest)
-		if err != nil {
-			crw.inboundBody.WriteString(`{"error":"failure making request"}`)
+		policy := crw.retry
+		maxRounds := 1
+		if policy != nil {
+			maxRounds = policy.MaxAttempts
+		}
 
-			// Check if it's a timeout
-			if strings.Contains(err.Error(), "Client.Timeout") || strings.Contains(err.Error(), "context deadline exceeded") {
-				// 504 Gateway Timeout
-				crw.inboundStatusCode = http.StatusGatewayTimeout
-				return
-			}
+		// A client that goes away cancels the backoff and the attempts
+		ctx := r.Context()
+		if policy != nil && policy.Deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(policy.Deadline)*time.Millisecond)
+			defer cancel()
+		}
 
-			// Check for DNS resolution errors
-			var dnsErr *net.DNSError
-			if ok := errors.As(err, &dnsErr); ok {
-				// 503 Service Unavailable
-				crw.inboundStatusCode = http.StatusServiceUnavailable
-				return
+		// canRetry checks if there is room for another round after waiting
+		canRetry := func(round int, wait time.Duration) bool {
+			if round >= maxRounds {
+				return false
 			}
+			deadline, hasDeadline := ctx.Deadline()
+			return !hasDeadline || time.Now().Add(wait).Before(deadline)
+		}
 
-			// Check if the connection was refused
-			var opErr *net.OpError
-			if ok := errors.As(err, &opErr); ok && opErr.Op == "dial" {
-				// 502 Bad Gateway (Connection refused)
-				crw.inboundStatusCode = http.StatusBadGateway
-				return
+		var backoff time.Duration
+		for round := 1; round <= maxRounds; round++ {
+			if backoff > 0 {
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+				}
 			}
 
-			// SSL/TLS errors
-			if strings.Contains(err.Error(), "x509: certificate") || strings.Contains(err.Error(), "tls: handshake") {
-				// 502 Bad Gateway (TLS/SSL Error)
-				crw.inboundStatusCode = http.StatusBadGateway
-				return
-			}
+			wait := time.Duration(0)
+			outcome := CALL_FAILOVER
+			for n, target := range crw.upstreams {
+				lastTarget := n == len(crw.upstreams)-1
+
+				crw.outboundURL = target.requestURL(crw.outboundSubPath, rawQuery)
+				attempt := outboundAttempt{
+					Attempt: round,
+					Target:  target.index,
+					Url:     crw.outboundURL.String(),
+					Backoff: backoff.Milliseconds(),
+				}
+				backoff = 0
 
-			// Generic network errors
-			if strings.Contains(err.Error(), "no such host") || strings.Contains(err.Error(), "network is unreachable") {
-				// 503 Service Unavailable (Network Error)
-				crw.inboundStatusCode = http.StatusServiceUnavailable
-				return
-			}
+				attemptCtx, cancelAttempt := ctx, context.CancelFunc(func() {})
+				if crw.limits != nil && crw.limits.Timeout > 0 {
+					attemptCtx, cancelAttempt = context.WithTimeout(ctx, time.Duration(crw.limits.Timeout)*time.Millisecond)
+				}
 
-			crw.inboundStatusCode = http.StatusInternalServerError
-			logger.Warn("failed to make request", slog.Any("error", err))
-			return
-		}
-		defer resp.Body.Close()
+				outboundRequest, err := http.NewRequestWithContext(attemptCtx, r.Method, crw.outboundURL.String(), bytes.NewReader(payload))
+				if err != nil {
+					cancelAttempt()
+					crw.inboundBody.WriteString(`{"error":"internal server error"}`)
+					crw.inboundStatusCode = http.StatusInternalServerError
 
-		// Copy the response body to the buffer
-		_, err = io.Copy(crw.inboundBody, resp.Body)
-		if err != nil {
-			crw.inboundBody.WriteString(`{"error":"internal server error"}`)
-			crw.inboundStatusCode = http.StatusInternalServerError
+					logger.Error("failed to create request", slog.Any("error", err))
+					return
+				}
 
-			logger.Error("failed to read body", slog.Any("error", err))
-			return
-		}
+				for key, values := range crw.outboundHeaders {
+					for _, value := range values {
+						outboundRequest.Header.Add(key, value)
+					}
+				}
+				for key, values := range target.headers {
+					// Target headers overwrite the outbound headers
+					outboundRequest.Header.Del(key)
+					for _, value := range values {
+						outboundRequest.Header.Add(key, value)
+					}
+				}
 
-		// Store the status code
-		crw.inboundStatusCode = resp.StatusCode
+				outcome = func() callOutcome {
+					attempt.Timer.Start()
+					defer attempt.Timer.Stop()
 
-		// Store the headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				crw.inboundHeaders.Add(key, value)
+					if target.breaker != nil && !target.breaker.allow() {
+						attempt.Error = "circuit breaker open"
+						if !lastTarget {
+							return CALL_FAILOVER
+						}
+						statusCode, body := target.breaker.failFast()
+						crw.outboundError = attempt.Error
+						crw.inboundBody.WriteString(body)
+						crw.inboundStatusCode = statusCode
+						crw.inboundHeaders.Set("Content-Type", "application/json")
+						return CALL_SERVED
+					}
+
+					if target.balancer != nil {
+						target.balancer.acquire(target.index)
+						defer target.balancer.release(target.index)
+					}
+
+					client := myClient
+					if crw.client != nil {
+						client = crw.client
+					}
+					resp, err := client.Do(outboundRequest)
+					if target.breaker != nil {
+						switch {
+						case err != nil && (errors.Is(err, context.Canceled) || r.Context().Err() != nil):
+							// The client went away, that says nothing about the upstream
+							target.breaker.cancel()
+						case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+							target.breaker.failure()
+						default:
+							target.breaker.success()
+						}
+					}
+					if err != nil {
+						attempt.Error = err.Error()
+						if !lastTarget {
+							return CALL_FAILOVER
+						}
+						wait = retryBackoff(policy, round)
+						if policy != nil && policy.NetworkErrors && canRetry(round, wait) {
+							return CALL_RETRY
+						}
+						if errors.Is(err, context.DeadlineExceeded) {
+							crw.setErrorCategory(ERROR_CATEGORY_UPSTREAM_TIMEOUT, err.Error())
+						}
+						crw.inboundBody.WriteString(`{"error":"failure making request"}`)
+						crw.inboundStatusCode = outboundErrorStatusCode(err)
+						crw.outboundError = err.Error()
+						return CALL_SERVED
+					}
+					defer resp.Body.Close()
+
+					attempt.StatusCode = resp.StatusCode
+					if resp.StatusCode >= http.StatusInternalServerError && !lastTarget {
+						io.Copy(io.Discard, resp.Body)
+						return CALL_FAILOVER
+					}
+
+					if retryableStatusCode(policy, resp.StatusCode) {
+						var ok bool
+						wait, ok = retryWait(policy, round, resp.Header, time.Now())
+						if ok && canRetry(round, wait) {
+							io.Copy(io.Discard, resp.Body)
+							return CALL_RETRY
+						}
+					}
+
+					for key, values := range target.headers {
+						crw.outboundHeaders.Del(key)
+						for _, value := range values {
+							crw.outboundHeaders.Add(key, value)
+						}
+					}
+					store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_target", &outboundAttempt{Attempt: round, Target: target.index, Url: attempt.Url})
+
+					// Decode compressed bodies so the log and processors see the payload
+					decoded, encoding, err := decodedBody(resp.Header, resp.Body)
+					if err != nil {
+						crw.inboundBody.WriteString(`{"error":"failure decoding response"}`)
+						crw.inboundStatusCode = http.StatusBadGateway
+
+						crw.responseErrorObject.AssignField("inbound_content_encoding", err.Error())
+						return CALL_SERVED
+					}
+					if encoding != "" {
+						defer decoded.Close()
+						resp.Body = decoded
+						resp.Header.Del("Content-Encoding")
+						resp.Header.Del("Content-Length")
+						store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_content_encoding", encoding)
+					}
+
+					if crw.stream != nil && resp.StatusCode == http.StatusOK && isEventStream(resp.Header) {
+						crw.inboundStatusCode = resp.StatusCode
+						for key, values := range resp.Header {
+							for _, value := range values {
+								crw.inboundHeaders.Add(key, value)
+							}
+						}
+
+						maxResponseBytes := int64(0)
+						if crw.limits != nil {
+							maxResponseBytes = crw.limits.MaxResponseBytes
+						}
+						err = streamInboundBody(crw, resp.StatusCode, resp.Header, resp.Body, maxResponseBytes)
+						if errors.Is(err, errStreamTooLarge) {
+							crw.setErrorCategory(ERROR_CATEGORY_RESPONSE_TOO_LARGE, err.Error())
+						}
+						if err != nil {
+							// Status is already sent, keep what we received
+							crw.responseErrorObject.AssignField("inbound_payload", err.Error())
+							logger.Error("failed to stream body", slog.Any("error", err))
+						}
+						return CALL_SERVED
+					}
+
+					// Copy the response body to the buffer
+					body := io.Reader(resp.Body)
+					maxResponseBytes := int64(0)
+					if crw.limits != nil {
+						maxResponseBytes = crw.limits.MaxResponseBytes
+					}
+					if maxResponseBytes > 0 {
+						// One byte more to detect bodies above the limit
+						body = io.LimitReader(resp.Body, maxResponseBytes+1)
+					}
+					n, err := io.Copy(crw.inboundBody, body)
+					if err != nil && errors.Is(err, context.DeadlineExceeded) {
+						crw.inboundBody.Reset()
+						crw.inboundBody.WriteString(`{"error":"upstream timeout"}`)
+						crw.inboundStatusCode = http.StatusGatewayTimeout
+						crw.outboundError = err.Error()
+
+						crw.setErrorCategory(ERROR_CATEGORY_UPSTREAM_TIMEOUT, err.Error())
+						return CALL_SERVED
+					}
+					if maxResponseBytes > 0 && n > maxResponseBytes {
+						crw.inboundBody.Reset()
+						crw.inboundBody.WriteString(`{"error":"response body too large"}`)
+						crw.inboundStatusCode = http.StatusBadGateway
+
+						crw.setErrorCategory(ERROR_CATEGORY_RESPONSE_TOO_LARGE, fmt.Sprintf("inbound payload exceeds %d bytes", maxResponseBytes))
+						return CALL_SERVED
+					}
+					if err != nil {
+						crw.inboundBody.WriteString(`{"error":"internal server error"}`)
+						crw.inboundStatusCode = http.StatusInternalServerError
+
+						logger.Error("failed to read body", slog.Any("error", err))
+						return CALL_SERVED
+					}
+
+					// Store the status code
+					crw.inboundStatusCode = resp.StatusCode
+
+					// Store the headers
+					for key, values := range resp.Header {
+						for _, value := range values {
+							crw.inboundHeaders.Add(key, value)
+						}
+					}
+					return CALL_SERVED
+				}()
+				cancelAttempt()
+
+				attempts = append(attempts, attempt)
+				if outcome != CALL_FAILOVER {
+					break
+				}
+			}
+
+			if outcome != CALL_RETRY {
+				return
 			}
+			backoff = wait
 		}
 	})
 }
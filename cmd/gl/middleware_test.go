@@ -630,7 +630,7 @@ func Test_sessionMiddlewareFunc_SessionID(t *testing.T) {
 
 func Test_egressResponseMiddleware_GechologResponseWriter(t *testing.T) {
 
-	handlerToTest := egressResponseMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handlerToTest := egressResponseMiddlewareFunc(nil, &state{m: &sync.Mutex{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 	assert.NotNil(t, handlerToTest)
@@ -763,7 +763,7 @@ func Test_egressResponseMiddleware_Request(t *testing.T) {
 			}
 
 			// Create a handler and pass the request and recorder
-			handler := http.Handler(egressResponseMiddleware(tt.args.handler))
+			handler := http.Handler(egressResponseMiddlewareFunc(nil, &state{m: &sync.Mutex{}})(tt.args.handler))
 			handler.ServeHTTP(g, req)
 
 			assert.Equal(t, tt.expextedStatusCode, rr.Code)
@@ -1092,7 +1092,7 @@ func Test_ingressEgressHeaderMiddlewareFunc_RequiredHeaders(t *testing.T) {
 				t.Fatal("middleware is nil")
 			}
 
-			handlerToTest := egressResponseMiddleware(middleware(tt.args.handler))
+			handlerToTest := egressResponseMiddlewareFunc(nil, &state{m: &sync.Mutex{}})(middleware(tt.args.handler))
 			if handlerToTest == nil {
 				t.Fatal("handlerToTest is nil")
 			}
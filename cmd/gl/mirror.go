@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+// Shadow requests in flight at the same time. Mirroring is skipped when all slots are taken
+const MAX_MIRRORS_IN_FLIGHT = 64
+
+type mirrorContextKey struct{}
+
+// Carried in the context of a shadow request
+type shadowRequest struct {
+	mirrorOf string // transaction id of the primary request
+	target   string
+}
+
+// shadowOf returns the shadow request carried in the context, zero for requests that are not shadows
+func shadowOf(r *http.Request) shadowRequest {
+	shadow, _ := r.Context().Value(mirrorContextKey{}).(shadowRequest)
+	return shadow
+}
+
+// mirrorDispatcher sends shadow requests to the handler of the gateway
+type mirrorDispatcher struct {
+	handler http.Handler
+	slots   chan struct{}
+}
+
+func newMirrorDispatcher(handler http.Handler, maxInFlight int) *mirrorDispatcher {
+	return &mirrorDispatcher{
+		handler: handler,
+		slots:   make(chan struct{}, maxInFlight),
+	}
+}
+
+// dispatch serves the shadow request asynchronously and discards the response. The request is
+// tracked in pendingLogs so shutdown waits for it. False if no slot is free
+func (d *mirrorDispatcher) dispatch(r *http.Request) bool {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		return false
+	}
+	pendingLogs.track(func() {
+		defer func() { <-d.slots }()
+		d.handler.ServeHTTP(&discardResponseWriter{header: http.Header{}}, r)
+	})
+	return true
+}
+
+// discardResponseWriter is the client of a shadow request
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+// checkMirror verifies that the mirror router can be reached from the router
+func checkMirror(thisRouter router.Router, routers []router.Router) error {
+	if thisRouter.Mirror == nil || thisRouter.Mirror.Router == "" {
+		return nil
+	}
+	return checkRouterReference(thisRouter, thisRouter.Mirror.Router, routers)
+}
+
+// sampleMirror is true for percentage percent of the calls
+func sampleMirror(percentage float64) bool {
+	return rand.Float64()*100 < percentage
+}
+
+// shadowPath is the ingress path of the shadow request
+func shadowPath(mirror *router.Mirror, r *http.Request, subPath string, pathParameters map[string]string) string {
+	if mirror.Router == "" {
+		return r.URL.Path
+	}
+	return router.ExpandPathParameters(mirror.Router, pathParameters) + subPath
+}
+
+// mirrorMiddlewareFunc sends a share of the requests that passed the ingress checks and reach the outbound
+// stage to the mirror router or target once the primary request is done. Shadow requests are never
+// mirrored again and are logged with mirror_of set to the transaction id of the primary request
+func mirrorMiddlewareFunc(mirror *router.Mirror, dispatcher *mirrorDispatcher, sessionHeader string, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	if mirror != nil && dispatcher == nil {
+		logger.Error("dispatcher is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if shadow := shadowOf(r); shadow.mirrorOf != "" {
+				crw.mirrorOf = shadow.mirrorOf
+				crw.mirrorTarget = shadow.target
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			if mirror == nil || !sampleMirror(mirror.Percentage) {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			// Capture the ingress request before the chain changes it
+			body := crw.ingressBinary
+			if body == nil {
+				body, _ = crw.requestObject.GetField("ingress_payload")
+			}
+			subPath := ""
+			if raw, err := crw.requestObject.GetField("ingress_subpath"); err == nil {
+				json.Unmarshal(raw, &subPath)
+			}
+			shadowRequestPath := shadowPath(mirror, r, subPath, crw.pathParameters)
+			header := r.Header.Clone()
+			header.Del(sessionHeader)
+			header.Del("Content-Length")
+
+			next.ServeHTTP(crw, r)
+
+			if len(crw.upstreams) == 0 {
+				// Rejected before the outbound stage
+				return
+			}
+
+			ctx := context.WithValue(context.Background(), mirrorContextKey{}, shadowRequest{mirrorOf: crw.transactionID, target: mirror.Target})
+			shadowReq, err := http.NewRequestWithContext(ctx, r.Method, shadowRequestPath, bytes.NewReader(body))
+			if err != nil {
+				logger.Error("failed to create shadow request", slog.String("transaction_id", crw.transactionID), slog.Any("error", err))
+				return
+			}
+			shadowReq.URL.RawQuery = r.URL.RawQuery
+			shadowReq.Header = header
+			shadowReq.Host = r.Host
+			shadowReq.RemoteAddr = r.RemoteAddr
+			shadowReq.TLS = r.TLS
+
+			if !dispatcher.dispatch(shadowReq) {
+				logger.Warn("too many shadow requests in flight, request not mirrored", slog.String("transaction_id", crw.transactionID))
+				return
+			}
+			logger.Debug(
+				"request mirrored",
+				slog.String("transaction_id", crw.transactionID),
+				slog.String("path", shadowRequestPath),
+			)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkMirror(t *testing.T) {
+	routers := []router.Router{
+		{Path: "/service/standard/"},
+		{Path: "/service/shadow/"},
+		{Path: "/openai/deployments/{deployment}/"},
+		{Path: "/shadow/deployments/{deployment}/"},
+	}
+	tests := []struct {
+		name    string
+		router  router.Router
+		wantErr bool
+	}{
+		{name: "no mirror", router: routers[0]},
+		{name: "target", router: router.Router{Path: "/service/standard/", Mirror: &router.Mirror{Target: "https://api.example.com", Percentage: 10}}},
+		{name: "known router", router: router.Router{Path: "/service/standard/", Mirror: &router.Mirror{Router: "/service/shadow/", Percentage: 10}}},
+		{name: "unknown router", router: router.Router{Path: "/service/standard/", Mirror: &router.Mirror{Router: "/service/other/", Percentage: 10}}, wantErr: true},
+		{name: "known path parameter", router: router.Router{Path: "/openai/deployments/{deployment}/", Mirror: &router.Mirror{Router: "/shadow/deployments/{deployment}/", Percentage: 10}}},
+		{name: "unknown path parameter", router: router.Router{Path: "/service/standard/", Mirror: &router.Mirror{Router: "/shadow/deployments/{deployment}/", Percentage: 10}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMirror(tt.router, routers)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_sampleMirror(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		assert.True(t, sampleMirror(100))
+		assert.False(t, sampleMirror(0))
+	}
+}
+
+func Test_mirrorMiddlewareFunc(t *testing.T) {
+	type shadow struct {
+		request *http.Request
+		body    string
+	}
+	tests := []struct {
+		name           string
+		mirror         *router.Mirror
+		target         string
+		pathParameters map[string]string
+		subPath        string
+		reachOutbound  bool
+		expectedPath   string
+		expectedTarget string
+	}{
+		{
+			name:          "router",
+			mirror:        &router.Mirror{Router: "/service/shadow/", Percentage: 100},
+			target:        "/service/standard/v1/chat?api-version=1",
+			subPath:       "v1/chat",
+			reachOutbound: true,
+			expectedPath:  "/service/shadow/v1/chat",
+		},
+		{
+			name:           "target",
+			mirror:         &router.Mirror{Target: "https://b.example.com", Percentage: 100},
+			target:         "/service/standard/v1/chat?api-version=1",
+			subPath:        "v1/chat",
+			reachOutbound:  true,
+			expectedPath:   "/service/standard/v1/chat",
+			expectedTarget: "https://b.example.com",
+		},
+		{
+			name:           "router with path parameters",
+			mirror:         &router.Mirror{Router: "/shadow/deployments/{deployment}/", Percentage: 100},
+			target:         "/openai/deployments/gpt-4o/chat?api-version=1",
+			pathParameters: map[string]string{"deployment": "gpt-4o"},
+			subPath:        "chat",
+			reachOutbound:  true,
+			expectedPath:   "/shadow/deployments/gpt-4o/chat",
+		},
+		{
+			name:   "rejected on ingress",
+			mirror: &router.Mirror{Router: "/service/shadow/", Percentage: 100},
+			target: "/service/standard/",
+		},
+		{
+			name:          "no mirror",
+			target:        "/service/standard/",
+			reachOutbound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shadows := make(chan shadow, 1)
+			dispatcher := newMirrorDispatcher(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				shadows <- shadow{request: r, body: string(body)}
+			}), MAX_MIRRORS_IN_FLIGHT)
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressHeaders:      http.Header{},
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+				transactionID:      "TST00001_1700000000000000000_1_0",
+				pathParameters:     tt.pathParameters,
+			}
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_payload", []byte(`{"model":"gpt-4o"}`))
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_subpath", tt.subPath)
+
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(`{"model":"gpt-4o"}`))
+			r.Header.Set("Session-Id", "TST00001_1700000000000000000_1_0")
+			r.Header.Set("Api-Key", "secret")
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.reachOutbound {
+					crw.upstreams = []upstreamTarget{{}}
+				}
+			})
+			mirrorMiddlewareFunc(tt.mirror, dispatcher, "Session-Id", &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			if tt.expectedPath == "" {
+				select {
+				case <-shadows:
+					t.Fatal("unexpected shadow request")
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case s := <-shadows:
+				assert.Equal(t, tt.expectedPath, s.request.URL.Path)
+				assert.Equal(t, "api-version=1", s.request.URL.RawQuery)
+				assert.Equal(t, `{"model":"gpt-4o"}`, s.body)
+				assert.Equal(t, "secret", s.request.Header.Get("Api-Key"))
+				assert.Empty(t, s.request.Header.Get("Session-Id"))
+				info, ok := s.request.Context().Value(mirrorContextKey{}).(shadowRequest)
+				assert.True(t, ok)
+				assert.Equal(t, shadowRequest{mirrorOf: crw.transactionID, target: tt.expectedTarget}, info)
+			case <-time.After(time.Second):
+				t.Fatal("no shadow request")
+			}
+		})
+	}
+
+	t.Run("shadow request is not mirrored again", func(t *testing.T) {
+		dispatched := false
+		dispatcher := newMirrorDispatcher(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dispatched = true
+		}), MAX_MIRRORS_IN_FLIGHT)
+		crw := &GechologResponseWriter{
+			requestObject:      gechologobject.New(),
+			requestErrorObject: gechologobject.New(),
+		}
+		r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), mirrorContextKey{}, shadowRequest{mirrorOf: "TST00001_1700000000000000000_1_0", target: "https://b.example.com"}))
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw.upstreams = []upstreamTarget{{}}
+		})
+		mirrorMiddlewareFunc(&router.Mirror{Target: "https://b.example.com", Percentage: 100}, dispatcher, "Session-Id", &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.False(t, dispatched)
+		assert.Equal(t, "TST00001_1700000000000000000_1_0", crw.mirrorOf)
+		assert.Equal(t, "https://b.example.com", crw.mirrorTarget)
+	})
+
+	t.Run("unauthenticated request is not mirrored", func(t *testing.T) {
+		dispatched := false
+		dispatcher := newMirrorDispatcher(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dispatched = true
+		}), MAX_MIRRORS_IN_FLIGHT)
+		crw := &GechologResponseWriter{
+			ResponseWriter:      httptest.NewRecorder(),
+			egressBody:          bytes.NewBufferString(""),
+			egressHeaders:       http.Header{},
+			requestObject:       gechologobject.New(),
+			requestErrorObject:  gechologobject.New(),
+			responseObject:      gechologobject.New(),
+			responseErrorObject: gechologobject.New(),
+		}
+		r := httptest.NewRequest(http.MethodPost, "/service/standard/", strings.NewReader(`{"model":"gpt-4o"}`))
+		r.Header.Set("Api-Key", "wrong")
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw.upstreams = []upstreamTarget{{}}
+		})
+		mirror := mirrorMiddlewareFunc(&router.Mirror{Target: "https://b.example.com", Percentage: 100}, dispatcher, "Session-Id", &state{m: &sync.Mutex{}})
+		headers := ingressEgressHeaderMiddlewareFunc(protectedheader.ProtectedHeader{"Api-Key": []string{"secret"}}, map[string]struct{}{}, map[string]struct{}{}, "Session-Id", &state{m: &sync.Mutex{}})
+		egressResponseMiddlewareFunc(nil, &state{m: &sync.Mutex{}})(headers(mirror(next))).ServeHTTP(crw, r)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, http.StatusUnauthorized, crw.egressStatusCode)
+		assert.False(t, dispatched)
+	})
+}
+
+func Test_mirrorDispatcher(t *testing.T) {
+	release := make(chan struct{})
+	dispatcher := newMirrorDispatcher(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}), 1)
+
+	r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+	assert.True(t, dispatcher.dispatch(r))
+	assert.False(t, dispatcher.dispatch(r))
+
+	// Shutdown waits for the shadow request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, 1, pendingLogs.wait(ctx))
+
+	close(release)
+	assert.Eventually(t, func() bool { return dispatcher.dispatch(r) }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, pendingLogs.wait(context.Background()))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// outboundClient returns the client for the outbound calls of a router. Routers without
+// client certificate or proxy share the client of the configuration
+func outboundClient(config *gl_config, outbound router.OutboundNode) (*http.Client, error) {
+	if outbound.ClientCertificate == nil && outbound.Proxy == nil {
+		return config.client, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.tlsConfig != nil {
+		tlsConfig = config.tlsConfig.Clone()
+	}
+	if config.TlsUserConfig.Outbound.InsecureFlag {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if outbound.ClientCertificate != nil {
+		certificate, err := tls.LoadX509KeyPair(outbound.ClientCertificate.CertificateFile, outbound.ClientCertificate.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	if outbound.Proxy != nil {
+		proxy := httpproxy.Config{
+			HTTPProxy:  outbound.Proxy.Url,
+			HTTPSProxy: outbound.Proxy.Url,
+			NoProxy:    strings.Join(outbound.Proxy.NoProxy, ","),
+		}
+		proxyFunc := proxy.ProxyFunc()
+		tr.Proxy = func(r *http.Request) (*url.URL, error) {
+			return proxyFunc(r.URL)
+		}
+	}
+	return &http.Client{Transport: tr}, nil
+}
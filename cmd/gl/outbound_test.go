@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestKeyPair(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gl"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func Test_outboundClient(t *testing.T) {
+	config := &gl_config{client: http.DefaultClient}
+	certFile, keyFile := writeTestKeyPair(t)
+
+	t.Run("shared client", func(t *testing.T) {
+		client, err := outboundClient(config, router.OutboundNode{Url: "https://api.example.com"})
+		assert.NoError(t, err)
+		assert.Same(t, http.DefaultClient, client)
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		client, err := outboundClient(config, router.OutboundNode{
+			Url:               "https://api.example.com",
+			ClientCertificate: &router.OutboundClientCertificate{CertificateFile: certFile, PrivateKeyFile: keyFile},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, client.Transport.(*http.Transport).TLSClientConfig.Certificates, 1)
+	})
+
+	t.Run("client certificate with wrong key", func(t *testing.T) {
+		_, err := outboundClient(config, router.OutboundNode{
+			Url:               "https://api.example.com",
+			ClientCertificate: &router.OutboundClientCertificate{CertificateFile: certFile, PrivateKeyFile: certFile},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("proxy and no proxy", func(t *testing.T) {
+		client, err := outboundClient(config, router.OutboundNode{
+			Url:   "https://api.example.com",
+			Proxy: &router.Proxy{Url: "http://proxy.corp:3128", NoProxy: []string{".internal.corp", "10.0.0.0/8"}},
+		})
+		assert.NoError(t, err)
+		proxy := client.Transport.(*http.Transport).Proxy
+
+		for target, expected := range map[string]string{
+			"https://api.example.com/v1/chat":        "http://proxy.corp:3128",
+			"https://llm.internal.corp/v1/chat":      "",
+			"http://10.1.2.3:8080/v1/chat":           "",
+			"https://models.example.com:8443/invoke": "http://proxy.corp:3128",
+		} {
+			r := httptest.NewRequest(http.MethodPost, target, nil)
+			u, err := proxy(r)
+			assert.NoError(t, err)
+			if expected == "" {
+				assert.Nil(t, u, target)
+				continue
+			}
+			assert.Equal(t, expected, u.String(), target)
+		}
+	})
+
+	t.Run("request through proxy", func(t *testing.T) {
+		proxied := ""
+		proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+			w.Write([]byte(`{}`))
+		}))
+		defer proxyServer.Close()
+
+		client, err := outboundClient(config, router.OutboundNode{
+			Url:   "http://api.example.com",
+			Proxy: &router.Proxy{Url: proxyServer.URL},
+		})
+		assert.NoError(t, err)
+		resp, err := client.Get("http://api.example.com/v1/models")
+		assert.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "http://api.example.com/v1/models", proxied)
+	})
+}
+
+func Test_outboundInboundPathMiddlewareFunc_Client(t *testing.T) {
+	proxied := ""
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{}`))
+	}))
+	defer proxyServer.Close()
+
+	config := &gl_config{client: http.DefaultClient}
+	routers := []router.Router{
+		{Path: "/service/standard/", Outbound: router.OutboundNode{Url: "http://standard.example.com"}},
+		{Path: "/service/proxied/", Outbound: router.OutboundNode{Url: "http://proxied.example.com", Proxy: &router.Proxy{Url: proxyServer.URL}}},
+	}
+	balancers := map[string]*targetBalancer{}
+	clients := map[string]*http.Client{}
+	for _, r := range routers {
+		balancers[r.Path] = newTargetBalancer(r.Outbound)
+		client, err := outboundClient(config, r.Outbound)
+		assert.NoError(t, err)
+		clients[r.Path] = client
+	}
+	s := &state{m: &sync.Mutex{}}
+
+	// A processor moved the request to the proxied router
+	crw := newFallbackTestWriter()
+	store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", "/service/proxied/")
+	store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_subpath", "v1/models")
+
+	handler := outboundInboundPathMiddlewareFunc(routers[0], routers, balancers, clients, s)(
+		standardRequestFunc(http.DefaultClient),
+	)
+	handler.ServeHTTP(crw, httptest.NewRequest(http.MethodGet, "/service/standard/v1/models", nil))
+
+	assert.Same(t, clients["/service/proxied/"], crw.client)
+	assert.Equal(t, http.StatusOK, crw.inboundStatusCode)
+	assert.Equal(t, "http://proxied.example.com/v1/models", proxied)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+// capturePathParameters returns the values of the named path segments of the matched router. The values
+// are decoded, so a value that could leave the upstream path, such as an encoded ../, is an error
+func capturePathParameters(r *http.Request, pattern string, names []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, name := range names {
+		value := r.PathValue(name)
+		if err := checkPathParameter(value, strings.Contains(pattern, "{"+name+"...}")); err != nil {
+			return nil, fmt.Errorf("path parameter %s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// checkPathParameter rejects backslashes, control characters and . or .. segments. Only wildcard values may contain /
+func checkPathParameter(value string, wildcard bool) error {
+	if strings.ContainsFunc(value, func(c rune) bool { return c == '\\' || unicode.IsControl(c) }) {
+		return fmt.Errorf("backslash or control character")
+	}
+	if !wildcard && strings.Contains(value, "/") {
+		return fmt.Errorf("contains /")
+	}
+	for _, segment := range strings.Split(value, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("contains %s segment", segment)
+		}
+	}
+	return nil
+}
+
+// expandHeaders returns a copy of the headers with the path parameters filled in
+func expandHeaders(headers protectedheader.ProtectedHeader, values map[string]string) protectedheader.ProtectedHeader {
+	expanded := protectedheader.ProtectedHeader{}
+	for key, list := range headers {
+		for _, value := range list {
+			expanded[key] = append(expanded[key], router.ExpandPathParameters(value, values))
+		}
+	}
+	return expanded
+}
+
+// expandUpstreams fills in the path parameters in the url path and headers of each target
+func expandUpstreams(upstreams []upstreamTarget, values map[string]string) []upstreamTarget {
+	expanded := make([]upstreamTarget, len(upstreams))
+	for i, t := range upstreams {
+		u := *t.url
+		u.Path = router.ExpandPathParameters(u.Path, values)
+		u.RawPath = ""
+		t.url = &u
+		t.headers = expandHeaders(t.headers, values)
+		expanded[i] = t
+	}
+	return expanded
+}
+
+// handle registers the pattern on the mux. Conflicting patterns are returned as an error
+func handle(mux *http.ServeMux, pattern string, handler http.Handler) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	mux.Handle(pattern, handler)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ingressPathParameters(t *testing.T) {
+	tests := []struct {
+		name               string
+		path               string
+		target             string
+		expectedParameters string
+		expectedSubPath    string
+	}{
+		{
+			name:               "exact template",
+			path:               "/openai/deployments/{deployment}/chat/completions",
+			target:             "/openai/deployments/gpt-4o/chat/completions",
+			expectedParameters: `{"deployment":"gpt-4o"}`,
+			expectedSubPath:    `""`,
+		},
+		{
+			name:               "template prefix",
+			path:               "/openai/deployments/{deployment}/",
+			target:             "/openai/deployments/gpt-4o/chat/completions",
+			expectedParameters: `{"deployment":"gpt-4o"}`,
+			expectedSubPath:    `"chat/completions"`,
+		},
+		{
+			name:               "wildcard",
+			path:               "/files/{rest...}",
+			target:             "/files/a/b/c.json",
+			expectedParameters: `{"rest":"a/b/c.json"}`,
+			expectedSubPath:    `""`,
+		},
+		{
+			name:            "no template",
+			path:            "/service/standard/",
+			target:          "/service/standard/v1/chat",
+			expectedSubPath: `"v1/chat"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressHeaders:      http.Header{},
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			mux := http.NewServeMux()
+			assert.NoError(t, handle(mux, tt.path, ingressPathMiddlewareFunc(router.Router{Path: tt.path}, &state{m: &sync.Mutex{}})(next)))
+			mux.ServeHTTP(crw, httptest.NewRequest(http.MethodPost, tt.target, nil))
+			assert.True(t, nextCalled)
+
+			subPath, err := crw.requestObject.GetField("ingress_subpath")
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedSubPath, string(subPath))
+
+			parameters, err := crw.requestObject.GetField("path_parameters")
+			if tt.expectedParameters == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedParameters, string(parameters))
+		})
+	}
+}
+
+func Test_ingressPathParametersTraversal(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		target   string
+		expected int
+	}{
+		{
+			name:     "encoded traversal",
+			path:     "/openai/deployments/{deployment}/chat/completions",
+			target:   "/openai/deployments/..%2F..%2Fadmin/chat/completions",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "encoded dot dot",
+			path:     "/openai/deployments/{deployment}/chat/completions",
+			target:   "/openai/deployments/%2E%2E/chat/completions",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "encoded backslash",
+			path:     "/openai/deployments/{deployment}/chat/completions",
+			target:   "/openai/deployments/a%5C..%5Cadmin/chat/completions",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "encoded control character",
+			path:     "/openai/deployments/{deployment}/chat/completions",
+			target:   "/openai/deployments/gpt-4o%0D%0AX-Injected:%201/chat/completions",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "wildcard traversal",
+			path:     "/files/{rest...}",
+			target:   "/files/a/..%2F..%2Fadmin",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "wildcard",
+			path:     "/files/{rest...}",
+			target:   "/files/a/b/c.json",
+			expected: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressHeaders:      http.Header{},
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			mux := http.NewServeMux()
+			assert.NoError(t, handle(mux, tt.path, ingressPathMiddlewareFunc(router.Router{Path: tt.path}, &state{m: &sync.Mutex{}})(next)))
+			mux.ServeHTTP(crw, httptest.NewRequest(http.MethodPost, tt.target, nil))
+			assert.Equal(t, tt.expected, crw.egressStatusCode)
+			assert.Equal(t, tt.expected == http.StatusOK, nextCalled)
+		})
+	}
+}
+
+func Test_expandUpstreams(t *testing.T) {
+	balancer := newTargetBalancer(router.OutboundNode{Targets: []router.TargetNode{
+		{Url: "https://api.example.com/openai/deployments/{deployment}/", Headers: protectedheader.ProtectedHeader{"X-Deployment": {"{deployment}"}}},
+	}})
+	upstreams := balancer.candidates()
+
+	expanded := expandUpstreams(upstreams, map[string]string{"deployment": "gpt-4o"})
+	assert.Equal(t, "https://api.example.com/openai/deployments/gpt-4o/", expanded[0].url.String())
+	assert.Equal(t, []string{"gpt-4o"}, expanded[0].headers["X-Deployment"])
+	// The balancer keeps the template
+	assert.Equal(t, "/openai/deployments/{deployment}/", upstreams[0].url.Path)
+}
+
+func Test_handle(t *testing.T) {
+	mux := http.NewServeMux()
+	assert.NoError(t, handle(mux, "/a/{x}/b/", http.NotFoundHandler()))
+	assert.Error(t, handle(mux, "/a/{y}/b/", http.NotFoundHandler()))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Logged in place of a payload that is not json. Data is the base64 payload when it is inlined
+type binaryPayload struct {
+	ContentType string       `json:"content_type,omitempty"`
+	Size        int          `json:"size"`
+	SHA256      string       `json:"sha256"`
+	Parts       []binaryPart `json:"parts,omitempty"`
+	Data        string       `json:"data,omitempty"`
+}
+
+// A part of a multipart payload. Value is set for form fields that are not files
+type binaryPart struct {
+	Name        string `json:"name,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	Value       string `json:"value,omitempty"`
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// multipartParts lists the parts of a multipart payload, nil if it cannot be parsed
+func multipartParts(contentType string, body []byte) []binaryPart {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil
+	}
+	parts := []binaryPart{}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			return nil
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil
+		}
+		p := binaryPart{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        len(data),
+			SHA256:      sha256Hex(data),
+		}
+		if p.Filename == "" && utf8.Valid(data) {
+			p.Value = string(data)
+		}
+		parts = append(parts, p)
+	}
+}
+
+// Recorded when processors change the log of a payload that is not json
+const BINARY_PAYLOAD_CHANGED = "changes to a payload that is not json are not applied"
+
+// sameJSON compares two json values, ignoring formatting and key order
+func sameJSON(a []byte, b []byte) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// binaryPayloadLog returns the log value of a payload that is not json. Payloads up to inlineMaxBytes are inlined
+func binaryPayloadLog(contentType string, body []byte, inlineMaxBytes int) []byte {
+	if len(body) == 0 {
+		return []byte(`""`)
+	}
+	p := binaryPayload{
+		ContentType: contentType,
+		Size:        len(body),
+		SHA256:      sha256Hex(body),
+		Parts:       multipartParts(contentType, body),
+	}
+	if len(body) <= inlineMaxBytes {
+		p.Data = base64.StdEncoding.EncodeToString(body)
+	}
+	b, _ := json.Marshal(p)
+	return b
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_binaryPayloadLog(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("model", "whisper-1")
+	file, _ := writer.CreateFormFile("file", "speech.mp3")
+	file.Write([]byte("ID3 not really audio"))
+	writer.Close()
+
+	var logged binaryPayload
+	assert.NoError(t, json.Unmarshal(binaryPayloadLog(writer.FormDataContentType(), body.Bytes(), 0), &logged))
+	assert.Equal(t, body.Len(), logged.Size)
+	assert.Equal(t, sha256Hex(body.Bytes()), logged.SHA256)
+	assert.Empty(t, logged.Data)
+	assert.Equal(t, []binaryPart{
+		{Name: "model", Size: 9, SHA256: sha256Hex([]byte("whisper-1")), Value: "whisper-1"},
+		{Name: "file", Filename: "speech.mp3", ContentType: "application/octet-stream", Size: 20, SHA256: sha256Hex([]byte("ID3 not really audio"))},
+	}, logged.Parts)
+
+	var inlined binaryPayload
+	assert.NoError(t, json.Unmarshal(binaryPayloadLog("audio/mpeg", []byte{0xff, 0xfb, 0x90}, 16), &inlined))
+	assert.Equal(t, "//uQ", inlined.Data)
+	assert.Nil(t, inlined.Parts)
+
+	assert.Equal(t, `""`, string(binaryPayloadLog("", []byte{}, 16)))
+}
+
+func Test_payloadMiddlewares_Binary(t *testing.T) {
+	tests := []struct {
+		name            string
+		ingress         []byte
+		contentType     string
+		inbound         []byte
+		expectedIngress string
+		expectedInbound string
+	}{
+		{
+			name:            "json",
+			ingress:         []byte(`{"input":"hello"}`),
+			contentType:     "application/json",
+			inbound:         []byte(`{"text":"hello"}`),
+			expectedIngress: `{"input":"hello"}`,
+			expectedInbound: `{"text":"hello"}`,
+		},
+		{
+			name:            "binary",
+			ingress:         []byte("plain text"),
+			contentType:     "text/plain",
+			inbound:         []byte{0xff, 0xfb, 0x90, 0x00},
+			expectedIngress: `{"content_type":"text/plain","size":10,"sha256":"` + sha256Hex([]byte("plain text")) + `"}`,
+			expectedInbound: `{"content_type":"audio/mpeg","size":4,"sha256":"` + sha256Hex([]byte{0xff, 0xfb, 0x90, 0x00}) + `"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:      httptest.NewRecorder(),
+				outboundBody:        bytes.NewBufferString(""),
+				inboundBody:         bytes.NewBufferString(""),
+				inboundHeaders:      http.Header{},
+				egressBody:          bytes.NewBufferString(""),
+				egressStatusCode:    http.StatusOK,
+				requestObject:       gechologobject.New(),
+				requestErrorObject:  gechologobject.New(),
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+			}
+
+			outbound := []byte{}
+			requestHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				outbound = bytes.Clone(crw.outboundBody.Bytes())
+				crw.inboundHeaders.Set("Content-Type", "audio/mpeg")
+				crw.inboundBody.Write(tt.inbound)
+				crw.inboundStatusCode = http.StatusOK
+			})
+			s := &state{m: &sync.Mutex{}}
+			handler := ingressEgressPayloadMiddlewareFunc(0, s)(outboundInboundPayloadMiddlewareFunc(0, s)(requestHandler))
+
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", bytes.NewReader(tt.ingress))
+			r.Header.Set("Content-Type", tt.contentType)
+			handler.ServeHTTP(crw, r)
+
+			assert.Equal(t, tt.ingress, outbound)
+			assert.Equal(t, tt.inbound, crw.egressBody.Bytes())
+
+			ingress, err := crw.requestObject.GetField("ingress_payload")
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedIngress, string(ingress))
+			inbound, err := crw.responseObject.GetField("inbound_payload")
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedInbound, string(inbound))
+			assert.Empty(t, crw.requestErrorObject.FieldNames())
+			assert.Empty(t, crw.responseErrorObject.FieldNames())
+		})
+	}
+}
+
+func Test_payloadMiddlewares_BinaryChanged(t *testing.T) {
+	tests := []struct {
+		name          string
+		edit          func(payload []byte) []byte
+		expectedError bool
+	}{
+		{
+			name:          "unchanged",
+			edit:          func(payload []byte) []byte { return payload },
+			expectedError: false,
+		},
+		{
+			name: "reformatted",
+			edit: func(payload []byte) []byte {
+				var v map[string]any
+				json.Unmarshal(payload, &v)
+				b, _ := json.MarshalIndent(v, "", "  ")
+				return b
+			},
+			expectedError: false,
+		},
+		{
+			name:          "changed",
+			edit:          func(payload []byte) []byte { return []byte(`{"text":"edited"}`) },
+			expectedError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:      httptest.NewRecorder(),
+				outboundBody:        bytes.NewBufferString(""),
+				inboundBody:         bytes.NewBufferString(""),
+				inboundHeaders:      http.Header{},
+				egressBody:          bytes.NewBufferString(""),
+				egressStatusCode:    http.StatusOK,
+				requestObject:       gechologobject.New(),
+				requestErrorObject:  gechologobject.New(),
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+			}
+
+			ingress := []byte("plain text")
+			inbound := []byte{0xff, 0xfb, 0x90, 0x00}
+			outbound := []byte{}
+			requestHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				outbound = bytes.Clone(crw.outboundBody.Bytes())
+				crw.inboundHeaders.Set("Content-Type", "audio/mpeg")
+				crw.inboundBody.Write(inbound)
+				crw.inboundStatusCode = http.StatusOK
+			})
+			// Stands in for request and response processors
+			processors := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					payload, _ := crw.requestObject.GetField("outbound_payload")
+					crw.requestObject.AssignFieldRaw("outbound_payload", tt.edit(payload))
+					next.ServeHTTP(w, r)
+					payload, _ = crw.responseObject.GetField("egress_payload")
+					crw.responseObject.AssignFieldRaw("egress_payload", tt.edit(payload))
+				})
+			}
+			s := &state{m: &sync.Mutex{}}
+			handler := ingressEgressPayloadMiddlewareFunc(0, s)(processors(outboundInboundPayloadMiddlewareFunc(0, s)(requestHandler)))
+
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", bytes.NewReader(ingress))
+			r.Header.Set("Content-Type", "text/plain")
+			handler.ServeHTTP(crw, r)
+
+			// The payloads are passed through unchanged either way
+			assert.Equal(t, ingress, outbound)
+			assert.Equal(t, inbound, crw.egressBody.Bytes())
+
+			if !tt.expectedError {
+				assert.Empty(t, crw.requestErrorObject.FieldNames())
+				assert.Empty(t, crw.responseErrorObject.FieldNames())
+				return
+			}
+			requestError, err := crw.requestErrorObject.GetField("outbound_payload")
+			assert.NoError(t, err)
+			assert.JSONEq(t, `"`+BINARY_PAYLOAD_CHANGED+`"`, string(requestError))
+			responseError, err := crw.responseErrorObject.GetField("egress_payload")
+			assert.NoError(t, err)
+			assert.JSONEq(t, `"`+BINARY_PAYLOAD_CHANGED+`"`, string(responseError))
+		})
+	}
+}
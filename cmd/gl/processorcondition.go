@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+)
+
+// processorCondition is the compiled condition of a processor. A nil condition always matches
+type processorCondition struct {
+	routers        []string
+	excludeRouters []string
+	methods        []string
+	statusCodes    []int
+	match          protectedheader.Requirements
+}
+
+// compileProcessorConditions compiles the conditions of the processors, nil for processors without condition
+func compileProcessorConditions(processors []processorconfiguration.ProcessorConfiguration) ([]*processorCondition, error) {
+	conditions := make([]*processorCondition, len(processors))
+	for i, p := range processors {
+		if p.Condition == nil {
+			continue
+		}
+		match, err := p.Condition.Requirements()
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %v", p.Name, err)
+		}
+		conditions[i] = &processorCondition{
+			routers:        p.Condition.Routers,
+			excludeRouters: p.Condition.ExcludeRouters,
+			methods:        p.Condition.Methods,
+			statusCodes:    p.Condition.StatusCodes,
+			match:          match,
+		}
+	}
+	return conditions, nil
+}
+
+// conditionObject marshals the object once, when the first match condition needs it
+func conditionObject(o *gechologobject.GechoLogObject) func() []byte {
+	var b []byte
+	return func() []byte {
+		if b == nil {
+			b, _ = json.Marshal(o)
+		}
+		return b
+	}
+}
+
+// matches is true when the processor should be called. The status code is 0 for request processors
+func (c *processorCondition) matches(o *gechologobject.GechoLogObject, object func() []byte, method string, statusCode int) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.routers) != 0 || len(c.excludeRouters) != 0 {
+		glPath := ""
+		if raw, err := o.GetField("gl_path"); err == nil {
+			json.Unmarshal(raw, &glPath)
+		}
+		if len(c.routers) != 0 && !slices.Contains(c.routers, glPath) {
+			return false
+		}
+		if slices.Contains(c.excludeRouters, glPath) {
+			return false
+		}
+	}
+	if len(c.methods) != 0 && !slices.Contains(c.methods, method) {
+		return false
+	}
+	if len(c.statusCodes) != 0 && statusCode != 0 && !slices.Contains(c.statusCodes, statusCode) {
+		return false
+	}
+	if len(c.match) != 0 {
+		if ok, _ := c.match.Check(payloadValues(object(), c.match)); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_processorConditionMatches(t *testing.T) {
+	o := gechologobject.New()
+	e := gechologobject.New()
+	store.Store(&o, &e, "gl_path", "/service/standard/")
+	store.Store(&o, &e, "ingress_payload", []byte(`{"model":"gpt-4o","messages":[{"role":"user"}]}`))
+
+	tests := []struct {
+		name       string
+		condition  *processorconfiguration.Condition
+		method     string
+		statusCode int
+		expected   bool
+	}{
+		{name: "no condition", expected: true},
+		{name: "router included", condition: &processorconfiguration.Condition{Routers: []string{"/service/standard/"}}, expected: true},
+		{name: "router not included", condition: &processorconfiguration.Condition{Routers: []string{"/service/other/"}}},
+		{name: "router excluded", condition: &processorconfiguration.Condition{ExcludeRouters: []string{"/service/standard/"}}},
+		{name: "method", condition: &processorconfiguration.Condition{Methods: []string{"POST"}}, method: "POST", expected: true},
+		{name: "other method", condition: &processorconfiguration.Condition{Methods: []string{"POST"}}, method: "GET"},
+		{name: "status code", condition: &processorconfiguration.Condition{StatusCodes: []int{200}}, statusCode: 200, expected: true},
+		{name: "other status code", condition: &processorconfiguration.Condition{StatusCodes: []int{200}}, statusCode: 500},
+		{name: "match", condition: &processorconfiguration.Condition{Match: map[string][]string{"ingress_payload.model": {"regex:gpt-4.*"}}}, expected: true},
+		{name: "match array", condition: &processorconfiguration.Condition{Match: map[string][]string{"ingress_payload.messages.#.role": {"oneof:user"}}}, expected: true},
+		{name: "no match", condition: &processorconfiguration.Condition{Match: map[string][]string{"ingress_payload.model": {"oneof:claude"}}}},
+		{name: "missing field", condition: &processorconfiguration.Condition{Match: map[string][]string{"inbound_payload.model": {"regex:.*"}}}},
+		{
+			name: "all conditions",
+			condition: &processorconfiguration.Condition{
+				Routers:     []string{"/service/standard/"},
+				Methods:     []string{"POST"},
+				StatusCodes: []int{200},
+				Match:       map[string][]string{"ingress_payload.model": {"gpt-4o"}},
+			},
+			method:     "POST",
+			statusCode: 200,
+			expected:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions, err := compileProcessorConditions([]processorconfiguration.ProcessorConfiguration{{Name: "p", Condition: tt.condition}})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, conditions[0].matches(&o, conditionObject(&o), tt.method, tt.statusCode))
+		})
+	}
+
+	_, err := compileProcessorConditions([]processorconfiguration.ProcessorConfiguration{{Name: "p", Condition: &processorconfiguration.Condition{Match: map[string][]string{"ingress_payload.model": {"regex:(gpt"}}}}})
+	assert.Error(t, err)
+}
+
+func Test_processorMiddlewareFuncCondition(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"moderation":"ok"}`))
+	}))
+	defer server.Close()
+
+	p := processorconfiguration.ProcessorConfiguration{
+		Name:              "moderation",
+		Required:          true,
+		OutputFieldsWrite: []string{"moderation"},
+		Http:              &processorconfiguration.HttpTransport{Url: server.URL},
+		Timeout:           1000,
+		Condition:         &processorconfiguration.Condition{Routers: []string{"/service/standard/"}, StatusCodes: []int{200}},
+	}
+	caller := &processorCaller{clients: map[*processorconfiguration.HttpTransport]*http.Client{p.Http: http.DefaultClient}}
+
+	tests := []struct {
+		name            string
+		middleware      processorsMiddlewareFunc
+		glPath          string
+		statusCode      int
+		expectedSkipped bool
+		expectedCalls   int
+	}{
+		{name: "request processor called", middleware: requestProcessorMiddlewareFunc, glPath: "/service/standard/", expectedCalls: 1},
+		{name: "request processor skipped", middleware: requestProcessorMiddlewareFunc, glPath: "/service/other/", expectedSkipped: true},
+		{name: "response processor called", middleware: responseProcessorMiddlewareFunc, glPath: "/service/standard/", statusCode: 200, expectedCalls: 1},
+		{name: "response processor skipped", middleware: responseProcessorMiddlewareFunc, glPath: "/service/standard/", statusCode: 500, expectedSkipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			crw := &GechologResponseWriter{
+				requestObject:             gechologobject.New(),
+				requestErrorObject:        gechologobject.New(),
+				responseObject:            gechologobject.New(),
+				responseErrorObject:       gechologobject.New(),
+				processorLogsRequestSync:  map[string]processorLog{},
+				processorLogsResponseSync: map[string]processorLog{},
+				inboundStatusCode:         tt.statusCode,
+				method:                    http.MethodPost,
+			}
+			for _, o := range []*gechologobject.GechoLogObject{&crw.requestObject, &crw.responseObject} {
+				store.Store(o, &crw.requestErrorObject, "gl_path", tt.glPath)
+				store.Store(o, &crw.requestErrorObject, "ingress_payload", []byte(`{"model":"gpt-4o"}`))
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			tt.middleware(context.Background(), caller, []processorconfiguration.ProcessorConfiguration{p}, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/service/standard/", nil))
+
+			assert.True(t, nextCalled)
+			assert.Equal(t, tt.expectedCalls, calls)
+
+			logs := crw.processorLogsRequestSync
+			if tt.statusCode != 0 {
+				logs = crw.processorLogsResponseSync
+			}
+			log, exists := logs["moderation"]
+			assert.True(t, exists)
+			assert.Equal(t, tt.expectedSkipped, log.Skipped)
+			assert.Equal(t, !tt.expectedSkipped, log.Completed)
+		})
+	}
+}
+
+func Test_gl_configValidateProcessorConditions(t *testing.T) {
+	c := &gl_config{}
+	c.RequestProcessors.Processors = [][]processorconfiguration.ProcessorConfiguration{{
+		{Name: "a", ServiceBusTopic: "coburg.a", Timeout: 1000, Condition: &processorconfiguration.Condition{StatusCodes: []int{200}}},
+		{Name: "b", ServiceBusTopic: "coburg.b", Timeout: 1000, Condition: &processorconfiguration.Condition{Match: map[string][]string{"ingress_payload.model": {"regex:(gpt"}}}},
+	}}
+	c.ResponseProcessors.Processors = [][]processorconfiguration.ProcessorConfiguration{{
+		{Name: "c", ServiceBusTopic: "coburg.c", Timeout: 1000, Condition: &processorconfiguration.Condition{StatusCodes: []int{200}, Methods: []string{"POST"}}},
+	}}
+	errors := c.Validate()
+	assert.Contains(t, errors, "gl_config.RequestProcessors.Processors[0][0].Condition.StatusCodes")
+	assert.Contains(t, errors, "gl_config.RequestProcessors.Processors[0][1].Condition.Match")
+	for key := range errors {
+		assert.NotContains(t, key, "ResponseProcessors", errors.String())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// Largest answer read from a processor over http
+const MAX_PROCESSOR_RESPONSE_BYTES = 10 << 20
+
+// processorCaller sends the message of a processor on its transport, the service bus or http
+type processorCaller struct {
+	nc      *nats.Conn
+	clients map[*processorconfiguration.HttpTransport]*http.Client
+}
+
+// newProcessorCaller creates the http clients of the processors with http transport
+func newProcessorCaller(nc *nats.Conn, config *gl_config) (*processorCaller, error) {
+	c := &processorCaller{
+		nc:      nc,
+		clients: map[*processorconfiguration.HttpTransport]*http.Client{},
+	}
+	for _, matrix := range []processorsMatrix{config.RequestProcessors, config.ResponseProcessors} {
+		for _, row := range matrix.Processors {
+			for _, p := range row {
+				if p.Http == nil {
+					continue
+				}
+				client, err := processorClient(config, p.Http)
+				if err != nil {
+					return nil, fmt.Errorf("processor %s: %v", p.Name, err)
+				}
+				c.clients[p.Http] = client
+			}
+		}
+	}
+	return c, nil
+}
+
+// processorClient is the shared outbound client unless the transport has its own tls settings
+func processorClient(config *gl_config, transport *processorconfiguration.HttpTransport) (*http.Client, error) {
+	if transport.Tls == nil {
+		return config.client, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.tlsConfig != nil {
+		tlsConfig = config.tlsConfig.Clone()
+	}
+	if len(transport.Tls.CaFiles) != 0 {
+		pool := x509.NewCertPool()
+		for _, filename := range transport.Tls.CaFiles {
+			pem, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in ca file %s", filename)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if transport.Tls.Insecure || config.TlsUserConfig.Outbound.InsecureFlag {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if transport.Tls.CertificateFile != "" {
+		certificate, err := tls.LoadX509KeyPair(transport.Tls.CertificateFile, transport.Tls.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// call sends data to the processor and returns its answer. sc is injected when the request is traced
+func (c *processorCaller) call(ctx context.Context, p processorconfiguration.ProcessorConfiguration, data []byte, sc *tracing.SpanContext) ([]byte, error) {
+	if p.Http == nil {
+		request := nats.NewMsg(p.ServiceBusTopic)
+		request.Data = data
+		if sc != nil {
+			tracing.Inject(request.Header, *sc)
+		}
+		msg, err := c.nc.RequestMsgWithContext(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return msg.Data, nil
+	}
+
+	client, exists := c.clients[p.Http]
+	if !exists {
+		return nil, fmt.Errorf("no http client for processor %s", p.Name)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Http.Url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, values := range p.Http.Headers {
+		request.Header.Del(key)
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	if sc != nil {
+		tracing.Inject(request.Header, *sc)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("processor answered with status code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MAX_PROCESSOR_RESPONSE_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MAX_PROCESSOR_RESPONSE_BYTES {
+		return nil, fmt.Errorf("processor answer exceeds %d bytes", MAX_PROCESSOR_RESPONSE_BYTES)
+	}
+	return body, nil
+}
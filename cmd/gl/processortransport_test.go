@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/direktoren/gecholog/internal/tracing"
+	"github.com/direktoren/gecholog/internal/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_processorCaller(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		object := map[string]json.RawMessage{}
+		json.Unmarshal(body, &object)
+		answer, _ := json.Marshal(map[string]any{
+			"ingress_payload": object["ingress_payload"],
+			"content_type":    r.Header.Get("Content-Type"),
+			"traceparent":     r.Header.Get("Traceparent"),
+		})
+		w.Write(answer)
+	}))
+	defer server.Close()
+
+	transport := &processorconfiguration.HttpTransport{Url: server.URL, Headers: map[string][]string{"Authorization": {"Bearer secret"}}}
+	config := &gl_config{client: http.DefaultClient}
+	config.RequestProcessors.Processors = [][]processorconfiguration.ProcessorConfiguration{{{Name: "webhook", Http: transport, Timeout: 1000}}}
+	caller, err := newProcessorCaller(nil, config)
+	assert.NoError(t, err)
+	assert.Same(t, http.DefaultClient, caller.clients[transport])
+
+	t.Run("message format", func(t *testing.T) {
+		sc := tracing.SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}}
+		answer, err := caller.call(context.Background(), config.RequestProcessors.Processors[0][0], []byte(`{"ingress_payload":{"model":"gpt-4o"}}`), &sc)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"ingress_payload":{"model":"gpt-4o"},"content_type":"application/json","traceparent":"`+sc.Traceparent()+`"}`, string(answer))
+	})
+
+	t.Run("status code", func(t *testing.T) {
+		p := processorconfiguration.ProcessorConfiguration{Name: "webhook", Http: &processorconfiguration.HttpTransport{Url: server.URL}, Timeout: 1000}
+		caller.clients[p.Http] = http.DefaultClient
+		_, err := caller.call(context.Background(), p, []byte(`{}`), nil)
+		assert.ErrorContains(t, err, "401")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := caller.call(ctx, config.RequestProcessors.Processors[0][0], []byte(`{}`), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func Test_processorClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	config := &gl_config{client: http.DefaultClient}
+
+	t.Run("ca files", func(t *testing.T) {
+		client, err := processorClient(config, &processorconfiguration.HttpTransport{Url: server.URL, Tls: &processorconfiguration.HttpTls{CaFiles: []string{caFile}}})
+		assert.NoError(t, err)
+		resp, err := client.Post(server.URL, "application/json", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		client, err := processorClient(config, &processorconfiguration.HttpTransport{Url: server.URL, Tls: &processorconfiguration.HttpTls{}})
+		assert.NoError(t, err)
+		_, err = client.Post(server.URL, "application/json", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("insecure", func(t *testing.T) {
+		client, err := processorClient(config, &processorconfiguration.HttpTransport{Url: server.URL, Tls: &processorconfiguration.HttpTls{Insecure: true}})
+		assert.NoError(t, err)
+		resp, err := client.Post(server.URL, "application/json", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("missing ca file", func(t *testing.T) {
+		_, err := processorClient(config, &processorconfiguration.HttpTransport{Url: server.URL, Tls: &processorconfiguration.HttpTls{CaFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}}})
+		assert.Error(t, err)
+	})
+}
+
+func Test_requestProcessorMiddlewareFuncHttp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"outbound_payload":{"model":"gpt-4o-mini"}}`))
+	}))
+	defer server.Close()
+
+	p := processorconfiguration.ProcessorConfiguration{
+		Name:               "downgrade",
+		Modifier:           true,
+		Required:           true,
+		InputFieldsInclude: []string{"outbound_payload"},
+		OutputFieldsWrite:  []string{"outbound_payload"},
+		Http:               &processorconfiguration.HttpTransport{Url: server.URL},
+		Timeout:            1000,
+	}
+	caller := &processorCaller{clients: map[*processorconfiguration.HttpTransport]*http.Client{p.Http: http.DefaultClient}}
+
+	crw := &GechologResponseWriter{
+		requestObject:            gechologobject.New(),
+		requestErrorObject:       gechologobject.New(),
+		processorLogsRequestSync: map[string]processorLog{},
+	}
+	store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", []byte(`{"model":"gpt-4o"}`))
+
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+	requestProcessorMiddlewareFunc(context.Background(), caller, []processorconfiguration.ProcessorConfiguration{p}, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/service/standard/", nil))
+
+	assert.True(t, nextCalled)
+	assert.True(t, crw.processorLogsRequestSync["downgrade"].Completed)
+	payload, err := crw.requestObject.GetField("outbound_payload")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-4o-mini"}`, string(payload))
+}
+
+func Test_processorsMatrixValidate(t *testing.T) {
+	webhook := func(name string) processorconfiguration.ProcessorConfiguration {
+		return processorconfiguration.ProcessorConfiguration{Name: name, Http: &processorconfiguration.HttpTransport{Url: "https://processors.example.com/" + name}, Timeout: 1000}
+	}
+	bus := func(name string, topic string) processorconfiguration.ProcessorConfiguration {
+		return processorconfiguration.ProcessorConfiguration{Name: name, ServiceBusTopic: topic, Timeout: 1000}
+	}
+	tests := []struct {
+		name       string
+		processors []processorconfiguration.ProcessorConfiguration
+		wantErr    bool
+	}{
+		{name: "http processors", processors: []processorconfiguration.ProcessorConfiguration{webhook("a"), webhook("b"), bus("c", "coburg.c")}},
+		{name: "duplicate topics", processors: []processorconfiguration.ProcessorConfiguration{bus("a", "coburg.a"), bus("b", "coburg.a")}, wantErr: true},
+		{name: "no transport", processors: []processorconfiguration.ProcessorConfiguration{{Name: "a", Timeout: 1000}}, wantErr: true},
+		{name: "both transports", processors: []processorconfiguration.ProcessorConfiguration{{Name: "a", ServiceBusTopic: "coburg.a", Http: &processorconfiguration.HttpTransport{Url: "https://processors.example.com/a"}, Timeout: 1000}}, wantErr: true},
+		{name: "invalid url", processors: []processorconfiguration.ProcessorConfiguration{{Name: "a", Http: &processorconfiguration.HttpTransport{Url: "processors"}, Timeout: 1000}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matrix := processorsMatrix{Processors: [][]processorconfiguration.ProcessorConfiguration{tt.processors}}
+			errors := validate.ValidateStruct(validate.New(), &matrix)
+			if tt.wantErr {
+				assert.NotEmpty(t, errors)
+				return
+			}
+			assert.Empty(t, errors, errors.String())
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+)
+
+const (
+	RATE_LIMIT_KEY_ROUTER  = "router"
+	RATE_LIMIT_KEY_IP      = "ip"
+	RATE_LIMIT_KEY_HEADER  = "header"
+	RATE_LIMIT_KEY_SESSION = "session"
+
+	// Idle buckets are pruned when there are more than this many, at most once per interval
+	RATE_LIMIT_MAX_BUCKETS    = 10000
+	RATE_LIMIT_PRUNE_INTERVAL = 10 * time.Second
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per limiter key
+type rateLimiter struct {
+	m sync.Mutex
+
+	config   router.RateLimit
+	capacity float64
+	rate     float64 // tokens per second
+	buckets  map[string]*tokenBucket
+	pruned   time.Time
+}
+
+func newRateLimiter(config router.RateLimit) *rateLimiter {
+	per := time.Second
+	if config.Per == "minute" {
+		per = time.Minute
+	}
+	capacity := config.Burst
+	if capacity == 0 {
+		capacity = config.Requests
+	}
+	return &rateLimiter{
+		config:   config,
+		capacity: float64(capacity),
+		rate:     float64(config.Requests) / per.Seconds(),
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// keepRateLimiter returns the previous limiter of the router if its configuration is unchanged,
+// so a reload keeps the buckets. Otherwise a new limiter
+func keepRateLimiter(previous *rateLimiter, config router.RateLimit) *rateLimiter {
+	if previous != nil && previous.config == config {
+		return previous
+	}
+	return newRateLimiter(config)
+}
+
+// Caller holds the lock
+func (rl *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens = math.Min(rl.capacity, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+}
+
+// take uses one token for the key. It returns the remaining tokens, and how
+// long to wait for the next token if the request is not allowed
+func (rl *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	if len(rl.buckets) > RATE_LIMIT_MAX_BUCKETS && now.Sub(rl.pruned) >= RATE_LIMIT_PRUNE_INTERVAL {
+		rl.pruned = now
+		for k, b := range rl.buckets {
+			rl.refill(b, now)
+			if b.tokens >= rl.capacity {
+				delete(rl.buckets, k)
+			}
+		}
+	}
+
+	b, exists := rl.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: rl.capacity, last: now}
+		rl.buckets[key] = b
+	}
+	rl.refill(b, now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// resetAfter is the time until the bucket of the key is full again
+func (rl *rateLimiter) resetAfter(key string) time.Duration {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	b, exists := rl.buckets[key]
+	if !exists {
+		return 0
+	}
+	return time.Duration((rl.capacity - b.tokens) / rl.rate * float64(time.Second))
+}
+
+// ceilSeconds rounds up to whole seconds for the response headers
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitKey returns the limiter key of the request. Header values are hashed since they are often credentials
+func rateLimitKey(config router.RateLimit, crw *GechologResponseWriter, r *http.Request) string {
+	switch config.Key {
+	case RATE_LIMIT_KEY_IP:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return RATE_LIMIT_KEY_IP + ":" + host
+	case RATE_LIMIT_KEY_HEADER:
+		sum := sha256.Sum256([]byte(r.Header.Get(config.Header)))
+		return RATE_LIMIT_KEY_HEADER + ":" + config.Header + ":" + hex.EncodeToString(sum[:])[:16]
+	case RATE_LIMIT_KEY_SESSION:
+		return RATE_LIMIT_KEY_SESSION + ":" + crw.sessionID
+	}
+	return RATE_LIMIT_KEY_ROUTER
+}
+
+// Log entry for the rate limit decision
+type rateLimitLog struct {
+	Key       string `json:"key"`
+	Limited   bool   `json:"limited"`
+	Remaining int    `json:"remaining"`
+}
+
+// rateLimitMiddlewareFunc rejects requests with 429 when the bucket of the key is empty. A nil limiter disables it
+func rateLimitMiddlewareFunc(limiter *rateLimiter, config *router.RateLimit, s *state) func(http.Handler) http.Handler {
+
+	if limiter != nil && config == nil {
+		logger.Error("rate limit config is nil")
+		return nil
+	}
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if limiter == nil || crw.mirrorOf != "" {
+				// Shadow requests do not use the quota of the client
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			key := rateLimitKey(*config, crw, r)
+			allowed, remaining, wait := limiter.take(key, time.Now())
+
+			crw.egressHeaders.Set("X-RateLimit-Limit", fmt.Sprintf("%d", int(limiter.capacity)))
+			crw.egressHeaders.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+			crw.egressHeaders.Set("X-RateLimit-Reset", fmt.Sprintf("%d", ceilSeconds(limiter.resetAfter(key))))
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "rate_limit", &rateLimitLog{Key: key, Limited: !allowed, Remaining: remaining})
+
+			if !allowed {
+				crw.egressHeaders.Set("Retry-After", fmt.Sprintf("%d", max(ceilSeconds(wait), 1)))
+				crw.egressBody.Write([]byte(`{"error":"rate limit exceeded"}`))
+				crw.egressStatusCode = http.StatusTooManyRequests
+
+				crw.requestErrorObject.AssignField("rate_limit", "rate limit exceeded for "+key)
+				return
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rateLimiter_take(t *testing.T) {
+	rl := newRateLimiter(router.RateLimit{Requests: 2, Per: "second", Burst: 3, Key: RATE_LIMIT_KEY_ROUTER})
+	now := time.Now()
+
+	for expected := 2; expected >= 0; expected-- {
+		allowed, remaining, _ := rl.take("k", now)
+		assert.True(t, allowed)
+		assert.Equal(t, expected, remaining)
+	}
+	allowed, _, wait := rl.take("k", now)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other keys have their own bucket
+	allowed, _, _ = rl.take("other", now)
+	assert.True(t, allowed)
+
+	// Refilled at 2 per second
+	allowed, remaining, _ := rl.take("k", now.Add(time.Second))
+	assert.True(t, allowed)
+	assert.Equal(t, 1, remaining)
+	assert.Equal(t, time.Second, rl.resetAfter("k"))
+}
+
+func Test_rateLimiter_prune(t *testing.T) {
+	rl := newRateLimiter(router.RateLimit{Requests: 1, Per: "second", Key: RATE_LIMIT_KEY_IP})
+	now := time.Now()
+	for i := 0; i <= RATE_LIMIT_MAX_BUCKETS; i++ {
+		rl.take(fmt.Sprintf("ip:%d", i), now)
+	}
+
+	// All buckets are full again and pruned once the limit is passed
+	now = now.Add(2 * time.Second)
+	rl.take("ip:new", now)
+	assert.Len(t, rl.buckets, 1)
+
+	// Not again within the interval
+	for i := 0; i <= RATE_LIMIT_MAX_BUCKETS; i++ {
+		rl.take(fmt.Sprintf("ip:%d", i), now)
+	}
+	now = now.Add(2 * time.Second)
+	rl.take("ip:other", now)
+	assert.Len(t, rl.buckets, RATE_LIMIT_MAX_BUCKETS+3)
+
+	now = now.Add(RATE_LIMIT_PRUNE_INTERVAL)
+	rl.take("ip:last", now)
+	assert.Len(t, rl.buckets, 1)
+}
+
+func Test_keepRateLimiter(t *testing.T) {
+	config := router.RateLimit{Requests: 1, Per: "minute", Key: RATE_LIMIT_KEY_ROUTER}
+	previous := newRateLimiter(config)
+	previous.take("k", time.Now())
+
+	kept := keepRateLimiter(previous, config)
+	assert.Same(t, previous, kept)
+	allowed, _, _ := kept.take("k", time.Now())
+	assert.False(t, allowed)
+
+	config.Requests = 2
+	changed := keepRateLimiter(previous, config)
+	assert.NotSame(t, previous, changed)
+	allowed, _, _ = changed.take("k", time.Now())
+	assert.True(t, allowed)
+
+	assert.NotNil(t, keepRateLimiter(nil, config))
+}
+
+func Test_rateLimitKey(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header.Set("Api-Key", "secret")
+	crw := &GechologResponseWriter{sessionID: "TST00001_1_1_0"}
+
+	assert.Equal(t, "router", rateLimitKey(router.RateLimit{Key: RATE_LIMIT_KEY_ROUTER}, crw, req))
+	assert.Equal(t, "ip:10.0.0.1", rateLimitKey(router.RateLimit{Key: RATE_LIMIT_KEY_IP}, crw, req))
+	assert.Equal(t, "session:TST00001_1_1_0", rateLimitKey(router.RateLimit{Key: RATE_LIMIT_KEY_SESSION}, crw, req))
+	headerKey := rateLimitKey(router.RateLimit{Key: RATE_LIMIT_KEY_HEADER, Header: "Api-Key"}, crw, req)
+	assert.Equal(t, "header:Api-Key:2bb80d537b1da3e3", headerKey)
+	assert.NotContains(t, headerKey, "secret")
+}
+
+func Test_rateLimitMiddlewareFunc(t *testing.T) {
+	config := &router.RateLimit{Requests: 1, Per: "minute", Key: RATE_LIMIT_KEY_IP}
+	middleware := rateLimitMiddlewareFunc(newRateLimiter(*config), config, &state{})
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	call := func(mirrorOf string) *GechologResponseWriter {
+		crw := &GechologResponseWriter{
+			ResponseWriter:     httptest.NewRecorder(),
+			egressBody:         bytes.NewBufferString(""),
+			egressHeaders:      http.Header{},
+			egressStatusCode:   http.StatusOK,
+			requestObject:      gechologobject.New(),
+			requestErrorObject: gechologobject.New(),
+			mirrorOf:           mirrorOf,
+		}
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.RemoteAddr = "10.0.0.1:51234"
+		handler.ServeHTTP(crw, req)
+		return crw
+	}
+
+	first := call("")
+	assert.Equal(t, http.StatusOK, first.egressStatusCode)
+	assert.Equal(t, "1", first.egressHeaders.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", first.egressHeaders.Get("X-RateLimit-Remaining"))
+
+	// Shadow requests do not use tokens
+	shadow := call("TST00001_1700000000000000000_1_0")
+	assert.Equal(t, http.StatusOK, shadow.egressStatusCode)
+	assert.Equal(t, "", shadow.egressHeaders.Get("X-RateLimit-Limit"))
+
+	second := call("")
+	assert.Equal(t, http.StatusTooManyRequests, second.egressStatusCode)
+	assert.Equal(t, "60", second.egressHeaders.Get("Retry-After"))
+	assert.Equal(t, `{"error":"rate limit exceeded"}`, second.egressBody.String())
+
+	logRaw, err := second.requestObject.GetField("rate_limit")
+	assert.NoError(t, err)
+	entry := rateLimitLog{}
+	assert.NoError(t, json.Unmarshal(logRaw, &entry))
+	assert.Equal(t, rateLimitLog{Key: "ip:10.0.0.1", Limited: true, Remaining: 0}, entry)
+}
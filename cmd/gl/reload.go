@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/glconfig"
+)
+
+// swappableHandler serves requests with the current gateway. A swap only
+// affects new requests, in-flight requests finish on the gateway they started on
+type swappableHandler struct {
+	gateway atomic.Pointer[gateway]
+}
+
+func (sh *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.current().handler.ServeHTTP(w, r)
+}
+
+func (sh *swappableHandler) current() *gateway {
+	return sh.gateway.Load()
+}
+
+func (sh *swappableHandler) swap(g *gateway) {
+	sh.gateway.Store(g)
+}
+
+// restartRequired lists the changed fields that cannot be applied by a reload
+func restartRequired(current *gl_config, next *gl_config) []string {
+	fields := []string{}
+	if current.Port != next.Port {
+		fields = append(fields, "gl_port")
+	}
+	if current.MetricsPort != next.MetricsPort {
+		fields = append(fields, "metrics_port")
+	}
+	if current.ServiceBusConfig != next.ServiceBusConfig {
+		fields = append(fields, "service_bus")
+	}
+	if !slices.Equal(current.MaskedHeaders, next.MaskedHeaders) {
+		// Masked headers are registered once in protectedheader
+		fields = append(fields, "masked_headers")
+	}
+	if current.TlsUserConfig.Ingress.Enabled != next.TlsUserConfig.Ingress.Enabled {
+		fields = append(fields, "tls.ingress.enabled")
+	}
+	return fields
+}
+
+// apply copies the reloadable fields of next. IsAliveData is kept
+func (g *gl_config) apply(next *gl_config) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.GatewayID = next.GatewayID
+	g.Version = next.Version
+	g.LogLevel = next.LogLevel
+	g.TlsUserConfig = next.TlsUserConfig
+	g.SessionIDHeader = next.SessionIDHeader
+	g.RemoveHeaders = next.RemoveHeaders
+	g.removeHeadersMap = next.removeHeadersMap
+	g.LogUnauthorized = next.LogUnauthorized
+	g.InlineBinaryMaxBytes = next.InlineBinaryMaxBytes
+	g.Routers = next.Routers
+	g.RequestProcessors = next.RequestProcessors
+	g.ResponseProcessors = next.ResponseProcessors
+	g.Logger = next.Logger
+	g.Tracing = next.Tracing
+	g.Compression = next.Compression
+	g.ShutdownTimeout = next.ShutdownTimeout
+	g.ConsumerHeader = next.ConsumerHeader
+	g.Consumers = next.Consumers
+	g.client = next.client
+	g.tlsConfig = next.tlsConfig
+	g.ingressCertificate = next.ingressCertificate
+	g.ingressClientCAs = next.ingressClientCAs
+	g.sha256 = next.sha256
+}
+
+// reloader rebuilds the gateway from the configuration file. A failed reload keeps the running gateway
+type reloader struct {
+	m sync.Mutex
+
+	filename string
+	gateways *swappableHandler
+	build    func(config *gl_config) (*gateway, error)
+	report   func(status string)
+}
+
+func (rl *reloader) reload(trigger string) error {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	logger.Info("reloading configuration", slog.String("trigger", trigger), slog.String("file", rl.filename))
+
+	err := func() error {
+		checksum, err := glconfig.GenerateChecksum(rl.filename)
+		if err != nil {
+			return fmt.Errorf("error generating checksum: %v", err)
+		}
+		config, err := glconfig.ReadFile(rl.filename)
+		if err != nil {
+			return fmt.Errorf("error opening file: %v", err)
+		}
+
+		next := &gl_config{}
+		err = parseConfig(config, next)
+		if err != nil {
+			return err
+		}
+		fields := restartRequired(&globalConfig, next)
+		if len(fields) != 0 {
+			return fmt.Errorf("changes to %s require a restart", strings.Join(fields, ", "))
+		}
+		err = setupTLS(next)
+		if err != nil {
+			return err
+		}
+		next.sha256 = checksum
+
+		g, err := rl.build(next)
+		if err != nil {
+			return err
+		}
+		rl.gateways.swap(g)
+		globalConfig.apply(next)
+		setLogLevel(next.LogLevel)
+		return nil
+	}()
+	if err != nil {
+		logger.Error("configuration reload failed, keeping running configuration", slog.String("trigger", trigger), slog.Any("error", err))
+		globalConfig.setLastError(err, time.Now())
+		rl.report(fmt.Sprintf("configuration reload failed: %v", err))
+		return err
+	}
+
+	if globalConfig.checksumFile != "" {
+		err = os.WriteFile(globalConfig.checksumFile, []byte(globalConfig.sha256), 0644)
+		if err != nil {
+			logger.Error(
+				"error writing checksum file",
+				slog.String("file", globalConfig.checksumFile),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	logger.Info("configuration reloaded", slog.String("trigger", trigger), slog.String("checksum", globalConfig.sha256))
+	rl.report("configuration reloaded")
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_swappableHandler(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	before := &gateway{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("old"))
+	})}
+	after := &gateway{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	})}
+
+	sh := &swappableHandler{}
+	sh.swap(before)
+
+	inFlight := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		sh.ServeHTTP(inFlight, httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	sh.swap(after)
+	next := httptest.NewRecorder()
+	sh.ServeHTTP(next, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "new", next.Body.String())
+
+	close(release)
+	<-done
+	assert.Equal(t, "old", inFlight.Body.String())
+}
+
+func Test_restartRequired(t *testing.T) {
+	type testCase struct {
+		name   string
+		change func(g *gl_config)
+		want   []string
+	}
+
+	testCases := []testCase{
+		{
+			name:   "no change",
+			change: func(g *gl_config) {},
+			want:   []string{},
+		},
+		{
+			name:   "routers only",
+			change: func(g *gl_config) { g.LogUnauthorized = true; g.SessionIDHeader = "Other-Id" },
+			want:   []string{},
+		},
+		{
+			name: "port and service bus",
+			change: func(g *gl_config) {
+				g.Port = 8080
+				g.ServiceBusConfig.TopicExactReload = "coburn.gl.reload"
+			},
+			want: []string{"gl_port", "service_bus"},
+		},
+		{
+			name: "masked headers and ingress tls",
+			change: func(g *gl_config) {
+				g.MaskedHeaders = []string{"Api-Key", "Authorization"}
+				g.TlsUserConfig.Ingress.Enabled = true
+			},
+			want: []string{"masked_headers", "tls.ingress.enabled"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			current := &gl_config{Port: 5380, SessionIDHeader: "Session-Id", MaskedHeaders: []string{"Api-Key"}}
+			next := &gl_config{Port: 5380, SessionIDHeader: "Session-Id", MaskedHeaders: []string{"Api-Key"}}
+			tc.change(next)
+			assert.Equal(t, tc.want, restartRequired(current, next))
+		})
+	}
+}
+
+func Test_reloader(t *testing.T) {
+	t.Setenv("NATS_TOKEN", "changeme")
+	t.Setenv("GECHOLOG_API_KEY", "changeme")
+	t.Setenv("AISERVICE_API_KEY", "changeme")
+	t.Setenv("AISERVICE_API_BASE", "https://example.com/")
+
+	original, err := os.ReadFile("../../config/gl_config.json")
+	assert.NoError(t, err)
+
+	// The running configuration
+	err = parseConfig(string(original), &globalConfig)
+	assert.NoError(t, err)
+
+	running := &gateway{handler: http.NotFoundHandler()}
+	filename := filepath.Join(t.TempDir(), "gl_config.json")
+
+	type testCase struct {
+		name    string
+		config  string
+		wantErr string
+		swapped bool
+	}
+
+	testCases := []testCase{
+		{
+			name:    "invalid json",
+			config:  "{",
+			wantErr: "invalid",
+		},
+		{
+			name:    "fails validation",
+			config:  strings.Replace(string(original), `"gateway_id": "TST00001"`, `"gateway_id": "bad"`, 1),
+			wantErr: "error validating configuration",
+		},
+		{
+			name:    "port change",
+			config:  strings.Replace(string(original), `"gl_port": 5380`, `"gl_port": 5381`, 1),
+			wantErr: "changes to gl_port require a restart",
+		},
+		{
+			name:    "valid",
+			config:  strings.Replace(string(original), `"log_unauthorized": false`, `"log_unauthorized": true`, 1),
+			swapped: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(filename, []byte(tc.config), 0600))
+
+			sh := &swappableHandler{}
+			sh.swap(running)
+			built := &gateway{handler: http.NotFoundHandler()}
+			reports := []string{}
+			rl := &reloader{
+				filename: filename,
+				gateways: sh,
+				build:    func(config *gl_config) (*gateway, error) { return built, nil },
+				report:   func(status string) { reports = append(reports, status) },
+			}
+
+			err := rl.reload("test")
+			if !tc.swapped {
+				assert.ErrorContains(t, err, tc.wantErr)
+				assert.Same(t, running, sh.current())
+				assert.Len(t, reports, 1)
+				assert.Contains(t, reports[0], "configuration reload failed")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Same(t, built, sh.current())
+			assert.Equal(t, []string{"configuration reloaded"}, reports)
+			assert.True(t, globalConfig.LogUnauthorized)
+		})
+	}
+}
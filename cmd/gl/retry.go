@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+// retryBackoff returns how long to wait before the next round. round is the
+// round that just failed, starting at 1
+func retryBackoff(policy *router.RetryPolicy, round int) time.Duration {
+	if policy == nil || policy.InitialBackoff == 0 {
+		return 0
+	}
+
+	backoff := time.Duration(policy.InitialBackoff) * time.Millisecond
+	maxBackoff := time.Duration(policy.MaxBackoff) * time.Millisecond
+	for i := 1; i < round && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	if policy.Jitter && backoff > 0 {
+		// Equal jitter, somewhere between half and the full backoff
+		half := backoff / 2
+		backoff = half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+	}
+	return backoff
+}
+
+// retryAfter parses the Retry-After header, either seconds or an http date
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || date.Before(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+// retryWait returns how long to wait before retrying a response. Retry-After extends the backoff,
+// a Retry-After beyond max_backoff ends the retries
+func retryWait(policy *router.RetryPolicy, round int, h http.Header, now time.Time) (time.Duration, bool) {
+	if policy == nil {
+		return 0, false
+	}
+	after := retryAfter(h, now)
+	if after > time.Duration(policy.MaxBackoff)*time.Millisecond {
+		return 0, false
+	}
+	return max(retryBackoff(policy, round), after), true
+}
+
+func retryableStatusCode(policy *router.RetryPolicy, statusCode int) bool {
+	if policy == nil {
+		return false
+	}
+	return slices.Contains(policy.StatusCodes, statusCode)
+}
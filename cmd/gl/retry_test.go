@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_retryBackoff(t *testing.T) {
+	policy := &router.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100,
+		MaxBackoff:     300,
+	}
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(policy, 1))
+	assert.Equal(t, 200*time.Millisecond, retryBackoff(policy, 2))
+	assert.Equal(t, 300*time.Millisecond, retryBackoff(policy, 3))
+	assert.Equal(t, 300*time.Millisecond, retryBackoff(policy, 4))
+	assert.Equal(t, time.Duration(0), retryBackoff(nil, 1))
+
+	policy.Jitter = true
+	for i := 0; i < 20; i++ {
+		b := retryBackoff(policy, 2)
+		assert.GreaterOrEqual(t, b, 100*time.Millisecond)
+		assert.LessOrEqual(t, b, 200*time.Millisecond)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "missing", value: "", expected: 0},
+		{name: "seconds", value: "3", expected: 3 * time.Second},
+		{name: "negative", value: "-3", expected: 0},
+		{name: "http date", value: now.Add(10 * time.Second).Format(http.TimeFormat), expected: 10 * time.Second},
+		{name: "date in the past", value: now.Add(-10 * time.Second).Format(http.TimeFormat), expected: 0},
+		{name: "garbage", value: "soon", expected: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.value != "" {
+				h.Set("Retry-After", tt.value)
+			}
+			assert.Equal(t, tt.expected, retryAfter(h, now))
+		})
+	}
+}
+
+func Test_retryWait(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := &router.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100,
+		MaxBackoff:     5000,
+	}
+	tests := []struct {
+		name          string
+		policy        *router.RetryPolicy
+		value         string
+		expectedWait  time.Duration
+		expectedRetry bool
+	}{
+		{name: "no policy", policy: nil, value: "1", expectedWait: 0, expectedRetry: false},
+		{name: "backoff", policy: policy, value: "", expectedWait: 100 * time.Millisecond, expectedRetry: true},
+		{name: "retry after", policy: policy, value: "3", expectedWait: 3 * time.Second, expectedRetry: true},
+		{name: "retry after at max backoff", policy: policy, value: "5", expectedWait: 5 * time.Second, expectedRetry: true},
+		{name: "retry after beyond max backoff", policy: policy, value: "3600", expectedWait: 0, expectedRetry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.value != "" {
+				h.Set("Retry-After", tt.value)
+			}
+			wait, retry := retryWait(tt.policy, 1, h, now)
+			assert.Equal(t, tt.expectedWait, wait)
+			assert.Equal(t, tt.expectedRetry, retry)
+		})
+	}
+}
+
+func Test_standardRequestFunc_Retry(t *testing.T) {
+	tests := []struct {
+		name               string
+		policy             *router.RetryPolicy
+		retryAfter         string
+		expectedCalls      int32
+		expectedStatusCode int
+	}{
+		{
+			name:               "no policy",
+			policy:             nil,
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "retry on 429",
+			policy: &router.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 1,
+				MaxBackoff:     10,
+				StatusCodes:    []int{429},
+			},
+			retryAfter:         "0",
+			expectedCalls:      3,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "retry after exceeds deadline",
+			policy: &router.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 1,
+				MaxBackoff:     10,
+				StatusCodes:    []int{429},
+				Deadline:       500,
+			},
+			retryAfter:         "2",
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "retry after exceeds max backoff",
+			policy: &router.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 1,
+				MaxBackoff:     10,
+				StatusCodes:    []int{429},
+			},
+			retryAfter:         "3600",
+			expectedCalls:      1,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := int32(0)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) < 3 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				outboundBody:       bytes.NewBufferString(`{}`),
+				outboundHeaders:    http.Header{},
+				inboundBody:        bytes.NewBufferString(""),
+				inboundHeaders:     http.Header{},
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+				upstreams:          newTargetBalancer(router.OutboundNode{Url: server.URL}).candidates(),
+				retry:              tt.policy,
+			}
+
+			req, err := http.NewRequest("POST", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+
+			assert.Equal(t, tt.expectedCalls, atomic.LoadInt32(&calls))
+			assert.Equal(t, tt.expectedStatusCode, crw.inboundStatusCode)
+
+			attemptsRaw, err := crw.requestObject.GetField("outbound_attempts")
+			assert.NoError(t, err)
+			attempts := []outboundAttempt{}
+			assert.NoError(t, json.Unmarshal(attemptsRaw, &attempts))
+			assert.Len(t, attempts, int(tt.expectedCalls))
+			for i, a := range attempts {
+				assert.Equal(t, i+1, a.Attempt)
+			}
+		})
+	}
+}
+
+func Test_standardRequestFunc_RetryClientGone(t *testing.T) {
+	calls := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	crw := &GechologResponseWriter{
+		ResponseWriter:     httptest.NewRecorder(),
+		outboundBody:       bytes.NewBufferString(`{}`),
+		outboundHeaders:    http.Header{},
+		inboundBody:        bytes.NewBufferString(""),
+		inboundHeaders:     http.Header{},
+		requestObject:      gechologobject.New(),
+		requestErrorObject: gechologobject.New(),
+		upstreams:          newTargetBalancer(router.OutboundNode{Url: server.URL}).candidates(),
+		retry: &router.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10000,
+			MaxBackoff:     10000,
+			StatusCodes:    []int{429},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/tidwall/gjson"
+)
+
+// routingRule is a compiled content based routing rule of a router
+type routingRule struct {
+	name    string
+	payload protectedheader.Requirements
+	headers protectedheader.Requirements
+	query   protectedheader.Requirements
+	target  string
+	router  string
+}
+
+// The rule that matched, written to the request log
+type routingMatch struct {
+	Name   string `json:"name"`
+	Target string `json:"target,omitempty"`
+	Router string `json:"router,omitempty"`
+}
+
+// compileRoutingRules compiles the rules of a router. Rules can only send requests to known routers
+func compileRoutingRules(rules []router.RoutingRule, routerPaths []string) ([]routingRule, error) {
+	compiled := []routingRule{}
+	for _, rule := range rules {
+		payload, headers, query, err := rule.Requirements()
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		if rule.Router != "" && !slices.Contains(routerPaths, rule.Router) {
+			return nil, fmt.Errorf("rule %s: unknown router %s", rule.Name, rule.Router)
+		}
+		target := ""
+		if rule.Target != "" {
+			u, err := url.Parse(rule.Target)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+			target = u.String()
+		}
+		compiled = append(compiled, routingRule{
+			name:    rule.Name,
+			payload: payload,
+			headers: headers,
+			query:   query,
+			target:  target,
+			router:  rule.Router,
+		})
+	}
+	return compiled, nil
+}
+
+// payloadValues looks up the gjson paths of the requirements. Arrays give one value per element
+func payloadValues(payload []byte, requirements protectedheader.Requirements) protectedheader.ProtectedHeader {
+	values := protectedheader.ProtectedHeader{}
+	for path := range requirements {
+		result := gjson.GetBytes(payload, path)
+		if !result.Exists() {
+			continue
+		}
+		if result.IsArray() {
+			for _, element := range result.Array() {
+				values[path] = append(values[path], element.String())
+			}
+			continue
+		}
+		values[path] = []string{result.String()}
+	}
+	return values
+}
+
+// match is true when all conditions of the rule are met
+func (rule routingRule) match(payload []byte, header http.Header, query url.Values) bool {
+	if ok, _ := rule.headers.Check(protectedheader.ProtectedHeader(header)); !ok {
+		return false
+	}
+	if ok, _ := rule.query.Check(protectedheader.ProtectedHeader(query)); !ok {
+		return false
+	}
+	if ok, _ := rule.payload.Check(payloadValues(payload, rule.payload)); !ok {
+		return false
+	}
+	return true
+}
+
+// routingMiddlewareFunc applies the first matching rule. A router rule sets gl_path, processors can
+// still change it. A target rule sends the request to that target only
+func routingMiddlewareFunc(rules []routingRule, s *state) func(http.Handler) http.Handler {
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if len(rules) == 0 {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			payload, _ := crw.requestObject.GetField("ingress_payload")
+			query := r.URL.Query()
+			for _, rule := range rules {
+				if !rule.match(payload, r.Header, query) {
+					continue
+				}
+
+				logger.Debug(
+					"routing rule matched",
+					slog.String("transaction_id", crw.transactionID),
+					slog.String("rule", rule.name),
+				)
+				store.Store(&crw.requestObject, &crw.requestErrorObject, "routing_rule", routingMatch{Name: rule.name, Target: rule.target, Router: rule.router})
+				if rule.router != "" {
+					store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", rule.router)
+				}
+				crw.routingTarget = rule.target
+				break
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
+
+// pinTarget keeps only the upstream with the target url. The list is unchanged if there is no such target
+func pinTarget(upstreams []upstreamTarget, target string) []upstreamTarget {
+	for _, u := range upstreams {
+		if u.url.String() == target {
+			return []upstreamTarget{u}
+		}
+	}
+	return upstreams
+}
+
+// checkRouterReference verifies that the referenced router exists and that its path parameters are
+// path parameters of thisRouter
+func checkRouterReference(thisRouter router.Router, path string, routers []router.Router) error {
+	index := slices.IndexFunc(routers, func(r router.Router) bool { return r.Path == path })
+	if index == -1 {
+		return fmt.Errorf("unknown router %s", path)
+	}
+	names := thisRouter.PathParameters()
+	for _, name := range routers[index].PathParameters() {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("path parameter %s of router %s is not a path parameter of this router", name, path)
+		}
+	}
+	return nil
+}
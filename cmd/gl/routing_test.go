@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compileRoutingRules(t *testing.T) {
+	paths := []string{"/service/standard/", "/service/mini/"}
+
+	_, err := compileRoutingRules([]router.RoutingRule{{Name: "mini", Payload: map[string][]string{"model": {"gpt-4o-mini"}}, Router: "/service/mini/"}}, paths)
+	assert.NoError(t, err)
+
+	_, err = compileRoutingRules([]router.RoutingRule{{Name: "unknown", Router: "/service/unknown/"}}, paths)
+	assert.Error(t, err)
+
+	_, err = compileRoutingRules([]router.RoutingRule{{Name: "invalid", Payload: map[string][]string{"model": {"regex:(gpt"}}, Router: "/service/mini/"}}, paths)
+	assert.Error(t, err)
+}
+
+func Test_routingMiddleware(t *testing.T) {
+	rules, err := compileRoutingRules([]router.RoutingRule{
+		{
+			Name:    "mini",
+			Payload: map[string][]string{"model": {"oneof:gpt-4o-mini"}},
+			Target:  "https://mini.example.com",
+		},
+		{
+			Name:    "tools",
+			Payload: map[string][]string{"tools.#.type": {"regex:function"}},
+			Headers: map[string][]string{"x-team": {"oneof:research"}},
+			Router:  "/service/tools/",
+		},
+		{
+			Name:   "preview",
+			Query:  map[string][]string{"api-version": {"regex:.*preview"}},
+			Router: "/service/preview/",
+		},
+	}, []string{"/service/tools/", "/service/preview/"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		payload        string
+		header         http.Header
+		target         string
+		expectedRule   string
+		expectedTarget string
+		expectedPath   string
+	}{
+		{
+			name:           "payload target",
+			payload:        `{"model":"gpt-4o-mini"}`,
+			target:         "/service/standard/",
+			expectedRule:   `{"name":"mini","target":"https://mini.example.com"}`,
+			expectedTarget: "https://mini.example.com",
+			expectedPath:   `"/service/standard/"`,
+		},
+		{
+			name:         "payload array and header router",
+			payload:      `{"model":"gpt-4o","tools":[{"type":"function"}]}`,
+			header:       http.Header{"X-Team": {"research"}},
+			target:       "/service/standard/",
+			expectedRule: `{"name":"tools","router":"/service/tools/"}`,
+			expectedPath: `"/service/tools/"`,
+		},
+		{
+			name:         "header not matching",
+			payload:      `{"model":"gpt-4o","tools":[{"type":"function"}]}`,
+			header:       http.Header{"X-Team": {"sales"}},
+			target:       "/service/standard/",
+			expectedPath: `"/service/standard/"`,
+		},
+		{
+			name:         "query router",
+			payload:      `{"model":"gpt-4o"}`,
+			target:       "/service/standard/?api-version=2024-10-01-preview",
+			expectedRule: `{"name":"preview","router":"/service/preview/"}`,
+			expectedPath: `"/service/preview/"`,
+		},
+		{
+			name:         "no match",
+			payload:      `{"model":"gpt-4o"}`,
+			target:       "/service/standard/",
+			expectedPath: `"/service/standard/"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:     httptest.NewRecorder(),
+				egressBody:         bytes.NewBufferString(""),
+				egressHeaders:      http.Header{},
+				egressStatusCode:   http.StatusOK,
+				requestObject:      gechologobject.New(),
+				requestErrorObject: gechologobject.New(),
+			}
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "ingress_payload", []byte(tt.payload))
+			store.Store(&crw.requestObject, &crw.requestErrorObject, "gl_path", "/service/standard/")
+
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.payload))
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			routingMiddlewareFunc(rules, &state{m: &sync.Mutex{}})(next).ServeHTTP(crw, r)
+
+			assert.True(t, nextCalled)
+			assert.Equal(t, tt.expectedTarget, crw.routingTarget)
+
+			path, err := crw.requestObject.GetField("gl_path")
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedPath, string(path))
+
+			rule, err := crw.requestObject.GetField("routing_rule")
+			if tt.expectedRule == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedRule, string(rule))
+		})
+	}
+}
+
+func Test_pinTarget(t *testing.T) {
+	balancer := newTargetBalancer(router.OutboundNode{Targets: []router.TargetNode{
+		{Url: "https://a.example.com"},
+		{Url: "https://b.example.com"},
+	}})
+	upstreams := balancer.candidates()
+
+	pinned := pinTarget(upstreams, "https://b.example.com")
+	assert.Len(t, pinned, 1)
+	assert.Equal(t, 1, pinned[0].index)
+
+	assert.Len(t, pinTarget(upstreams, "https://c.example.com"), 2)
+}
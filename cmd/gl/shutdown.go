@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Drain period in milliseconds when shutdown_timeout is not set
+const DEFAULT_SHUTDOWN_TIMEOUT = 10000
+
+// pendingLogs tracks the log finalizations and shadow requests still running
+var pendingLogs = &logTracker{}
+
+type logTracker struct {
+	m       sync.Mutex
+	pending int
+	idle    chan struct{} // closed when pending drops to zero
+}
+
+// finalize runs post in its own goroutine and tracks it until done
+func (t *logTracker) finalize(post loggingPostProcessor, crw *GechologResponseWriter) {
+	t.track(func() { post(crw) })
+}
+
+// track runs f in its own goroutine and tracks it until done
+func (t *logTracker) track(f func()) {
+	t.m.Lock()
+	if t.pending == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.pending++
+	t.m.Unlock()
+
+	go func() {
+		defer t.done()
+		f()
+	}()
+}
+
+func (t *logTracker) done() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.pending--
+	if t.pending == 0 {
+		close(t.idle)
+	}
+}
+
+// wait blocks until all finalizations are done or ctx expires, returning how many are still running
+func (t *logTracker) wait(ctx context.Context) int {
+	for {
+		t.m.Lock()
+		if t.pending == 0 {
+			t.m.Unlock()
+			return 0
+		}
+		idle := t.idle
+		t.m.Unlock()
+
+		select {
+		case <-idle:
+			// Check again, new finalizations may have started
+		case <-ctx.Done():
+			t.m.Lock()
+			defer t.m.Unlock()
+			return t.pending
+		}
+	}
+}
+
+// shutdown drains the servers, waits for the pending logs and flushes the service bus. Returns the number of lost logs
+func shutdown(servers []*http.Server, stopServing context.CancelFunc, nc *nats.Conn, timeout time.Duration) int {
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logger.Info("shutting down", slog.Duration("drain", timeout))
+
+	for _, server := range servers {
+		err := server.Shutdown(drainCtx)
+		if err != nil {
+			logger.Warn("requests still running after drain period", slog.String("addr", server.Addr), slog.Any("error", err))
+			server.Close()
+		}
+	}
+
+	lost := pendingLogs.wait(drainCtx)
+	stopServing() // Processors still running are cancelled
+
+	// The spans of the finished logs are still queued
+	spanExports.close(drainCtx)
+
+	// The logs published during the drain are still buffered
+	err := nc.FlushTimeout(time.Second)
+	if err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		logger.Error("error flushing service bus", slog.Any("error", err))
+	}
+
+	if lost > 0 {
+		logger.Error("logs lost during shutdown", slog.Int("lost", lost))
+		return lost
+	}
+	logger.Info("all logs flushed")
+	return 0
+}
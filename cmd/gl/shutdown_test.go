@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_logTracker(t *testing.T) {
+	tests := []struct {
+		name         string
+		finalizers   []time.Duration
+		drain        time.Duration
+		expectedLost int
+	}{
+		{
+			name:         "nothing pending",
+			drain:        10 * time.Millisecond,
+			expectedLost: 0,
+		},
+		{
+			name:         "all finish within drain",
+			finalizers:   []time.Duration{5 * time.Millisecond, 10 * time.Millisecond},
+			drain:        time.Second,
+			expectedLost: 0,
+		},
+		{
+			name:         "slow logs are lost",
+			finalizers:   []time.Duration{5 * time.Millisecond, time.Second, time.Second},
+			drain:        50 * time.Millisecond,
+			expectedLost: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &logTracker{}
+			release := make(chan struct{})
+			defer close(release)
+
+			for _, d := range tt.finalizers {
+				tracker.finalize(func(crw *GechologResponseWriter) {
+					select {
+					case <-time.After(d):
+					case <-release:
+					}
+				}, &GechologResponseWriter{})
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.drain)
+			defer cancel()
+			assert.Equal(t, tt.expectedLost, tracker.wait(ctx))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Each chunk of a stream gets this long to reach the client. The server WriteTimeout
+// counts from the start of the request and would cut off long streams
+const STREAM_WRITE_TIMEOUT = 30 * time.Second
+
+// streamSettings is set on the GechologResponseWriter for routers with streaming enabled
+type streamSettings struct {
+	removeHeadersMap map[string]struct{}
+	sessionHeader    string
+}
+
+func streamingMiddlewareFunc(enabled bool, removeHeadersMap map[string]struct{}, sessionHeader string, s *state) func(http.Handler) http.Handler {
+
+	if removeHeadersMap == nil {
+		logger.Error("removeHeadersMap is empty")
+		return nil
+	}
+
+	if sessionHeader == "" {
+		logger.Error("sessionHeader is empty")
+		return nil
+	}
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if enabled {
+				crw.stream = &streamSettings{
+					removeHeadersMap: removeHeadersMap,
+					sessionHeader:    sessionHeader,
+				}
+			}
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
+
+// isEventStream checks the Content-Type for server-sent events
+func isEventStream(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/event-stream"
+}
+
+// errStreamTooLarge ends a stream that exceeds max_response_bytes
+var errStreamTooLarge = errors.New("inbound stream too large")
+
+// streamInboundBody writes the upstream body to the client chunk by chunk and
+// stores the reassembled events in inboundBody once the stream ends. The stream
+// stops when the client goes away or after maxBytes bytes, zero means no limit
+func streamInboundBody(crw *GechologResponseWriter, statusCode int, header http.Header, body io.Reader, maxBytes int64) error {
+
+	for key, values := range header {
+		if _, remove := crw.stream.removeHeadersMap[key]; remove {
+			continue
+		}
+		for _, value := range values {
+			crw.Header().Add(key, value)
+		}
+	}
+	for key, values := range crw.egressHeaders {
+		for _, value := range values {
+			crw.Header().Add(key, value)
+		}
+	}
+	crw.Header().Del("Content-Length")
+	crw.Header().Set(crw.stream.sessionHeader, crw.transactionID)
+
+	crw.WriteHeader(statusCode)
+	crw.streamed = true
+
+	flusher, _ := crw.ResponseWriter.(http.Flusher)
+	controller := http.NewResponseController(crw.ResponseWriter)
+
+	raw := bytes.Buffer{}
+	defer func() {
+		crw.inboundBody.Write(assembleEventStream(raw.Bytes()))
+	}()
+
+	chunk := make([]byte, 4096)
+	for {
+		n, err := body.Read(chunk)
+		if n > 0 {
+			tooLarge := maxBytes > 0 && int64(raw.Len()+n) > maxBytes
+			if tooLarge {
+				n = int(maxBytes - int64(raw.Len()))
+			}
+			raw.Write(chunk[:n])
+			// Not supported by all writers, those have no deadline to extend
+			controller.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT))
+			_, writeErr := crw.ResponseWriter.Write(chunk[:n])
+			if writeErr != nil {
+				// The log keeps the events received so far
+				logger.Warn("client stopped receiving stream", slog.String("transaction_id", crw.transactionID), slog.Any("error", writeErr))
+				return fmt.Errorf("client stopped receiving stream: %v", writeErr)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if tooLarge {
+				return fmt.Errorf("%w, exceeds %d bytes", errStreamTooLarge, maxBytes)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// assembleEventStream collects the data: fields of a server-sent event stream
+// into a json array. Json events are kept as-is, others are stored as strings
+// and the [DONE] terminator is dropped
+func assembleEventStream(raw []byte) []byte {
+	events := []json.RawMessage{}
+	data := []string{}
+
+	endOfEvent := func() {
+		if len(data) == 0 {
+			return
+		}
+		payload := strings.Join(data, "\n")
+		data = []string{}
+		if payload == "[DONE]" {
+			return
+		}
+		if json.Valid([]byte(payload)) {
+			events = append(events, json.RawMessage(payload))
+			return
+		}
+		quoted, _ := json.Marshal(payload)
+		events = append(events, quoted)
+	}
+
+	normalized := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	for _, line := range strings.Split(normalized, "\n") {
+		if line == "" {
+			endOfEvent()
+			continue
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	endOfEvent()
+
+	assembled, _ := json.Marshal(&events)
+	return assembled
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_assembleEventStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name:     "empty stream",
+			raw:      "",
+			expected: `[]`,
+		},
+		{
+			name:     "json events with done",
+			raw:      "data: {\"id\":1}\n\ndata: {\"id\":2}\n\ndata: [DONE]\n\n",
+			expected: `[{"id":1},{"id":2}]`,
+		},
+		{
+			name:     "crlf and comments",
+			raw:      ": keep-alive\r\nevent: message\r\ndata: {\"id\":1}\r\n\r\n",
+			expected: `[{"id":1}]`,
+		},
+		{
+			name:     "multiline text event",
+			raw:      "data: hello\ndata: world\n\n",
+			expected: `["hello\nworld"]`,
+		},
+		{
+			name:     "last event without trailing newline",
+			raw:      "data: {\"id\":1}",
+			expected: `[{"id":1}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, string(assembleEventStream([]byte(tt.raw))))
+		})
+	}
+}
+
+func Test_isEventStream(t *testing.T) {
+	assert.True(t, isEventStream(http.Header{"Content-Type": []string{"text/event-stream; charset=utf-8"}}))
+	assert.False(t, isEventStream(http.Header{"Content-Type": []string{"application/json"}}))
+	assert.False(t, isEventStream(http.Header{}))
+}
+
+func Test_streamInboundBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+	crw := &GechologResponseWriter{
+		ResponseWriter: rr,
+		inboundBody:    bytes.NewBufferString(""),
+		egressHeaders:  http.Header{"X-Rate": []string{"1"}},
+		transactionID:  "TST00001_1_1_0",
+		stream: &streamSettings{
+			removeHeadersMap: map[string]struct{}{"Content-Length": {}},
+			sessionHeader:    "Session-Id",
+		},
+	}
+
+	raw := "data: {\"id\":1}\n\ndata: [DONE]\n\n"
+	header := http.Header{
+		"Content-Type":   []string{"text/event-stream"},
+		"Content-Length": []string{"10"},
+	}
+	err := streamInboundBody(crw, http.StatusOK, header, strings.NewReader(raw), 0)
+	assert.NoError(t, err)
+
+	assert.True(t, crw.streamed)
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, raw, rr.Body.String())
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "TST00001_1_1_0", rr.Header().Get("Session-Id"))
+	assert.Equal(t, "1", rr.Header().Get("X-Rate"))
+	assert.Empty(t, rr.Header().Get("Content-Length"))
+	assert.Equal(t, `[{"id":1}]`, crw.inboundBody.String())
+}
+
+// failingWriter is a client that went away
+type failingWriter struct {
+	header http.Header
+}
+
+func (w *failingWriter) Header() http.Header { return w.header }
+func (w *failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+func (w *failingWriter) WriteHeader(int) {}
+
+// endlessEvents is an upstream that never ends its stream
+type endlessEvents struct {
+	reads int
+}
+
+func (e *endlessEvents) Read(b []byte) (int, error) {
+	e.reads++
+	return copy(b, "data: {\"id\":1}\n\n"), nil
+}
+
+func Test_streamInboundBodyStops(t *testing.T) {
+	header := http.Header{"Content-Type": []string{"text/event-stream"}}
+	settings := &streamSettings{removeHeadersMap: map[string]struct{}{}, sessionHeader: "Session-Id"}
+
+	t.Run("max bytes", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		crw := &GechologResponseWriter{
+			ResponseWriter: rr,
+			inboundBody:    bytes.NewBufferString(""),
+			egressHeaders:  http.Header{},
+			stream:         settings,
+		}
+		upstream := &endlessEvents{}
+		err := streamInboundBody(crw, http.StatusOK, header, upstream, 40)
+		assert.ErrorIs(t, err, errStreamTooLarge)
+		assert.Equal(t, 40, rr.Body.Len())
+		assert.Equal(t, 3, upstream.reads)
+		// The cut event is kept as a string
+		assert.Equal(t, `[{"id":1},{"id":1},"{\""]`, crw.inboundBody.String())
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		crw := &GechologResponseWriter{
+			ResponseWriter: &failingWriter{header: http.Header{}},
+			inboundBody:    bytes.NewBufferString(""),
+			egressHeaders:  http.Header{},
+			stream:         settings,
+		}
+		upstream := &endlessEvents{}
+		err := streamInboundBody(crw, http.StatusOK, header, upstream, 0)
+		assert.Error(t, err)
+		assert.Equal(t, 1, upstream.reads)
+		assert.Equal(t, `[{"id":1}]`, crw.inboundBody.String())
+	})
+}
+
+// slowEvents is an upstream that sends one event per delay
+type slowEvents struct {
+	events int
+	delay  time.Duration
+}
+
+func (e *slowEvents) Read(b []byte) (int, error) {
+	if e.events == 0 {
+		return 0, io.EOF
+	}
+	e.events--
+	time.Sleep(e.delay)
+	return copy(b, "data: {\"id\":1}\n\n"), nil
+}
+
+func Test_streamInboundBodyWriteTimeout(t *testing.T) {
+	streamErr := make(chan error, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crw := &GechologResponseWriter{
+			ResponseWriter: w,
+			inboundBody:    bytes.NewBufferString(""),
+			egressHeaders:  http.Header{},
+			stream:         &streamSettings{removeHeadersMap: map[string]struct{}{}, sessionHeader: "Session-Id"},
+		}
+		header := http.Header{"Content-Type": []string{"text/event-stream"}}
+		streamErr <- streamInboundBody(crw, http.StatusOK, header, &slowEvents{events: 4, delay: 100 * time.Millisecond}, 0)
+	}))
+	// The stream lasts longer than the server allows for a response
+	server.Config.WriteTimeout = 150 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("data: {\"id\":1}\n\n", 4), string(body))
+	assert.NoError(t, <-streamErr)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+)
+
+const (
+	BALANCING_WEIGHTED_ROUND_ROBIN = "weighted_round_robin"
+	BALANCING_LEAST_IN_FLIGHT      = "least_in_flight"
+)
+
+// upstreamTarget is an outbound target resolved for a single request
+type upstreamTarget struct {
+	index    int
+	url      *url.URL
+	headers  protectedheader.ProtectedHeader
+	balancer *targetBalancer
+	breaker  *circuitBreaker
+}
+
+// requestURL joins the target url with the outbound subpath and query parameters.
+// Query parameters of the target url take precedence
+func (t upstreamTarget) requestURL(subPath string, rawQuery string) url.URL {
+	u := outboundTargetURL(t.url, subPath)
+
+	query, _ := url.ParseQuery(rawQuery)
+	for key, values := range t.url.Query() {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// outboundTargetURL joins the target path and the outbound subpath, keeping a trailing slash
+func outboundTargetURL(targetURL *url.URL, subPath string) url.URL {
+	u := url.URL{
+		Scheme: targetURL.Scheme,
+		Host:   targetURL.Host,
+		Path:   path.Join(targetURL.Path, subPath),
+	}
+	if strings.HasSuffix(strings.Trim(targetURL.Path+subPath, " "), "/") {
+		u.Path += "/"
+	}
+	return u
+}
+
+// targetBalancer picks the preferred target of a router for each request
+type targetBalancer struct {
+	m sync.Mutex
+
+	balancing string
+	targets   []router.TargetNode
+	parsed    []*url.URL
+	breakers  []*circuitBreaker
+
+	current  []int // smooth weighted round robin state
+	inFlight []int
+	next     int // rotating start for least in flight ties
+}
+
+func newTargetBalancer(outbound router.OutboundNode) *targetBalancer {
+	targets := outbound.GetTargets()
+	b := &targetBalancer{
+		balancing: outbound.Balancing,
+		targets:   targets,
+		parsed:    make([]*url.URL, len(targets)),
+		current:   make([]int, len(targets)),
+		inFlight:  make([]int, len(targets)),
+	}
+	if b.balancing == "" {
+		b.balancing = BALANCING_WEIGHTED_ROUND_ROBIN
+	}
+	for i, t := range targets {
+		u, err := url.Parse(t.Url)
+		if err != nil {
+			// We trust this works since checks are made of the config
+			u = &url.URL{}
+		}
+		b.parsed[i] = u
+	}
+	return b
+}
+
+// attachBreakers gives every target the circuit breaker of its url
+func (b *targetBalancer) attachBreakers(registry *breakerRegistry, config router.CircuitBreaker) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.breakers = make([]*circuitBreaker, len(b.targets))
+	for i := range b.targets {
+		b.breakers[i] = registry.get(b.parsed[i], config)
+	}
+}
+
+// pick returns the index of the preferred target. Caller holds the lock
+func (b *targetBalancer) pick() int {
+	if b.balancing == BALANCING_LEAST_IN_FLIGHT {
+		best := -1
+		for n := 0; n < len(b.targets); n++ {
+			i := (b.next + n) % len(b.targets)
+			// inFlight[i]/weight[i] < inFlight[best]/weight[best]
+			if best == -1 || b.inFlight[i]*b.targets[best].Weight < b.inFlight[best]*b.targets[i].Weight {
+				best = i
+			}
+		}
+		b.next = (b.next + 1) % len(b.targets)
+		return best
+	}
+
+	total := 0
+	best := 0
+	for i, t := range b.targets {
+		b.current[i] += t.Weight
+		total += t.Weight
+		if b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+// candidates returns all targets, preferred first and the rest in failover order
+func (b *targetBalancer) candidates() []upstreamTarget {
+	b.m.Lock()
+	first := b.pick()
+	b.m.Unlock()
+
+	list := make([]upstreamTarget, len(b.targets))
+	for n := range b.targets {
+		i := (first + n) % len(b.targets)
+		list[n] = upstreamTarget{
+			index:    i,
+			url:      b.parsed[i],
+			headers:  b.targets[i].Headers,
+			balancer: b,
+		}
+		if b.breakers != nil {
+			list[n].breaker = b.breakers[i]
+		}
+	}
+	return list
+}
+
+func (b *targetBalancer) acquire(index int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.inFlight[index]++
+}
+
+func (b *targetBalancer) release(index int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.inFlight[index]--
+}
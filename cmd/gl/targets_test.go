@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/direktoren/gecholog/internal/protectedheader"
+	"github.com/direktoren/gecholog/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_targetBalancer_WeightedRoundRobin(t *testing.T) {
+	b := newTargetBalancer(router.OutboundNode{
+		Targets: []router.TargetNode{
+			{Url: "http://a.example.com", Weight: 3},
+			{Url: "http://b.example.com"}, // Default weight 1
+		},
+	})
+
+	picks := map[int]int{}
+	for i := 0; i < 8; i++ {
+		candidates := b.candidates()
+		assert.Len(t, candidates, 2)
+		assert.NotEqual(t, candidates[0].index, candidates[1].index)
+		picks[candidates[0].index]++
+	}
+	assert.Equal(t, 6, picks[0])
+	assert.Equal(t, 2, picks[1])
+}
+
+func Test_targetBalancer_LeastInFlight(t *testing.T) {
+	b := newTargetBalancer(router.OutboundNode{
+		Balancing: BALANCING_LEAST_IN_FLIGHT,
+		Targets: []router.TargetNode{
+			{Url: "http://a.example.com"},
+			{Url: "http://b.example.com"},
+			{Url: "http://c.example.com"},
+		},
+	})
+
+	b.acquire(0)
+	b.acquire(1)
+	assert.Equal(t, 2, b.candidates()[0].index)
+
+	b.acquire(2)
+	b.acquire(2)
+	b.release(0)
+	assert.Equal(t, 0, b.candidates()[0].index)
+}
+
+func Test_targetBalancer_SingleUrl(t *testing.T) {
+	b := newTargetBalancer(router.OutboundNode{Url: "https://example.com/v1/?api-version=1"})
+	candidates := b.candidates()
+	assert.Len(t, candidates, 1)
+	u := candidates[0].requestURL("chat/completions", "a=b&api-version=0")
+	assert.Equal(t, "https://example.com/v1/chat/completions?a=b&api-version=1", u.String())
+}
+
+func Test_standardRequestFunc_Failover(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"key":"` + r.Header.Get("Api-Key") + `"}`))
+	}))
+	defer working.Close()
+
+	b := newTargetBalancer(router.OutboundNode{
+		Targets: []router.TargetNode{
+			{Url: failing.URL, Weight: 10},
+			{Url: working.URL, Headers: protectedheader.ProtectedHeader{"Api-Key": []string{"second"}}},
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	crw := &GechologResponseWriter{
+		ResponseWriter:     rr,
+		outboundBody:       bytes.NewBufferString(`{}`),
+		outboundHeaders:    http.Header{"Api-Key": []string{"first"}},
+		outboundURL:        url.URL{},
+		inboundBody:        bytes.NewBufferString(""),
+		inboundHeaders:     http.Header{},
+		requestObject:      gechologobject.New(),
+		requestErrorObject: gechologobject.New(),
+		upstreams:          b.candidates(),
+	}
+	assert.Equal(t, 0, crw.upstreams[0].index)
+
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standardRequestFunc(http.DefaultClient).ServeHTTP(crw, req)
+
+	assert.Equal(t, http.StatusOK, crw.inboundStatusCode)
+	assert.Equal(t, `{"key":"second"}`, crw.inboundBody.String())
+
+	attemptsRaw, err := crw.requestObject.GetField("outbound_attempts")
+	assert.NoError(t, err)
+	attempts := []outboundAttempt{}
+	assert.NoError(t, json.Unmarshal(attemptsRaw, &attempts))
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, attempts[0].StatusCode)
+	assert.Equal(t, 1, attempts[1].Target)
+
+	targetRaw, err := crw.requestObject.GetField("outbound_target")
+	assert.NoError(t, err)
+	target := outboundAttempt{}
+	assert.NoError(t, json.Unmarshal(targetRaw, &target))
+	assert.Equal(t, 1, target.Target)
+	assert.Equal(t, working.URL, target.Url)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/timer"
+	"github.com/direktoren/gecholog/internal/tracing"
+)
+
+const (
+	SPAN_QUEUE_SIZE     = 1024 // traces waiting for export, further traces are dropped
+	SPAN_BATCH_SIZE     = 512  // spans sent in one export
+	SPAN_FLUSH_INTERVAL = 5 * time.Second
+)
+
+// spanExports batches the spans of all routers, flushed on shutdown
+var spanExports = newSpanBatcher(SPAN_QUEUE_SIZE, SPAN_BATCH_SIZE, SPAN_FLUSH_INTERVAL)
+
+// Tracing settings. Timeout is in milliseconds
+type tracingConfig struct {
+	OtlpEndpoint string `json:"otlp_endpoint" validate:"omitempty,http_url"`
+	ServiceName  string `json:"service_name" validate:"omitempty,ascii"`
+	Timeout      int    `json:"timeout" validate:"omitempty,min=1"`
+}
+
+func (t *tracingConfig) String() string {
+	return fmt.Sprintf("otlp_endpoint:%s service_name:%s timeout:%d", t.OtlpEndpoint, t.ServiceName, t.Timeout)
+}
+
+// newTraceExporter returns nil if spans should not be exported
+func newTraceExporter(config *tracingConfig) *tracing.Exporter {
+	if config == nil || config.OtlpEndpoint == "" {
+		return nil
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = thisBinary
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 5000
+	}
+	return tracing.NewExporter(config.OtlpEndpoint, serviceName, time.Duration(timeout)*time.Millisecond)
+}
+
+// spanBatch is the spans of one trace and the exporter of its router
+type spanBatch struct {
+	exporter *tracing.Exporter
+	spans    []tracing.Span
+}
+
+// spanBatcher exports spans in the background, when batchSize spans are queued or every interval
+type spanBatcher struct {
+	queue     chan spanBatch
+	batchSize int
+	interval  time.Duration
+	dropped   atomic.Uint64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newSpanBatcher(queueSize int, batchSize int, interval time.Duration) *spanBatcher {
+	b := &spanBatcher{
+		queue:     make(chan spanBatch, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// add queues the spans without blocking, they are dropped when the queue is full
+func (b *spanBatcher) add(exporter *tracing.Exporter, spans []tracing.Span) {
+	select {
+	case b.queue <- spanBatch{exporter: exporter, spans: spans}:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+func (b *spanBatcher) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	pending := []spanBatch{}
+	count := 0
+	for {
+		select {
+		case batch := <-b.queue:
+			pending = append(pending, batch)
+			count += len(batch.spans)
+			if count >= b.batchSize {
+				b.flush(pending)
+				pending, count = []spanBatch{}, 0
+			}
+		case <-ticker.C:
+			b.flush(pending)
+			pending, count = []spanBatch{}, 0
+		case <-b.stop:
+			for {
+				select {
+				case batch := <-b.queue:
+					pending = append(pending, batch)
+				default:
+					b.flush(pending)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush sends one export per exporter
+func (b *spanBatcher) flush(pending []spanBatch) {
+	if dropped := b.dropped.Swap(0); dropped > 0 {
+		logger.Warn("span export queue full, traces dropped", slog.Uint64("dropped", dropped))
+	}
+
+	exporters := []*tracing.Exporter{}
+	spans := map[*tracing.Exporter][]tracing.Span{}
+	for _, batch := range pending {
+		if _, exists := spans[batch.exporter]; !exists {
+			exporters = append(exporters, batch.exporter)
+		}
+		spans[batch.exporter] = append(spans[batch.exporter], batch.spans...)
+	}
+	for _, exporter := range exporters {
+		err := exporter.Export(context.Background(), spans[exporter])
+		if err != nil {
+			logger.Warn("error exporting spans", slog.Int("spans", len(spans[exporter])), slog.Any("error", err))
+		}
+	}
+}
+
+// close exports the queued spans and stops the batcher. Spans added later are not exported
+func (b *spanBatcher) close(ctx context.Context) {
+	b.stopOnce.Do(func() { close(b.stop) })
+	select {
+	case <-b.stopped:
+	case <-ctx.Done():
+		logger.Warn("spans still exporting after drain period")
+	}
+}
+
+// traceState is the trace context of one request
+type traceState struct {
+	m sync.Mutex
+
+	exporter *tracing.Exporter
+	batcher  *spanBatcher
+	name     string
+	method   string
+
+	parentSpanID [8]byte // zero when gl started the trace
+	server       tracing.SpanContext
+	outbound     tracing.SpanContext
+	processors   []tracing.Span
+}
+
+// tracingMiddlewareFunc continues the trace of the caller, or starts a new one. A nil config disables it
+func tracingMiddlewareFunc(config *tracingConfig, exporter *tracing.Exporter, batcher *spanBatcher, s *state) func(http.Handler) http.Handler {
+
+	if exporter != nil && batcher == nil {
+		logger.Error("span batcher is nil")
+		return nil
+	}
+
+	if s == nil {
+		logger.Error("state is nil")
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			crw, ok := w.(*GechologResponseWriter)
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+				return
+			}
+
+			if config == nil {
+				next.ServeHTTP(crw, r)
+				return
+			}
+
+			ts := &traceState{
+				exporter: exporter,
+				batcher:  batcher,
+				name:     "gl " + r.Pattern,
+				method:   r.Method,
+			}
+			incoming, valid := tracing.Parse(r.Header.Get(tracing.TRACEPARENT_HEADER), r.Header.Get(tracing.TRACESTATE_HEADER))
+			if valid {
+				ts.server = incoming.Child()
+				ts.parentSpanID = incoming.SpanID
+			} else {
+				ts.server = tracing.NewTrace()
+			}
+			ts.outbound = ts.server.Child()
+			crw.trace = ts
+
+			next.ServeHTTP(crw, r)
+		})
+	}
+}
+
+// processorSpans keeps a span for each processor that was called
+func (ts *traceState) processorSpans(stage string, processors []processorconfiguration.ProcessorConfiguration, logEntries []processorLog, contexts []tracing.SpanContext) {
+	ts.m.Lock()
+	defer ts.m.Unlock()
+
+	for i, p := range processors {
+		if logEntries[i].outcome == "" {
+			continue
+		}
+		attributes := map[string]string{
+			"gecholog.processor.stage":   stage,
+			"gecholog.processor.outcome": logEntries[i].outcome,
+		}
+		if p.Http != nil {
+			attributes["url.full"] = p.Http.Url
+		} else {
+			attributes["messaging.destination.name"] = p.ServiceBusTopic
+		}
+		ts.processors = append(ts.processors, tracing.Span{
+			Name:         "processor " + p.Name,
+			Kind:         tracing.SPAN_KIND_CLIENT,
+			Context:      contexts[i],
+			ParentSpanID: ts.server.SpanID,
+			Start:        logEntries[i].Timestamp.GetStart(),
+			End:          logEntries[i].Timestamp.GetStop(),
+			Attributes:   attributes,
+			Error:        logEntries[i].outcome != PROCESSOR_COMPLETED,
+		})
+	}
+}
+
+// spans builds the spans of the finished request from its timers
+func (ts *traceState) spans(crw *GechologResponseWriter) []tracing.Span {
+	ts.m.Lock()
+	defer ts.m.Unlock()
+
+	phase := func(name string, kind int, sc tracing.SpanContext, t timer.Timer) []tracing.Span {
+		if t.GetStart().IsZero() || t.GetStop().IsZero() {
+			// The phase never happened
+			return []tracing.Span{}
+		}
+		return []tracing.Span{{
+			Name:         name,
+			Kind:         kind,
+			Context:      sc,
+			ParentSpanID: ts.server.SpanID,
+			Start:        t.GetStart(),
+			End:          t.GetStop(),
+			Attributes:   map[string]string{},
+		}}
+	}
+
+	server := tracing.Span{
+		Name:         ts.name,
+		Kind:         tracing.SPAN_KIND_SERVER,
+		Context:      ts.server,
+		ParentSpanID: ts.parentSpanID,
+		Start:        crw.ingressEgressTimer.GetStart(),
+		End:          crw.ingressEgressTimer.GetStop(),
+		Attributes: map[string]string{
+			"http.request.method":       ts.method,
+			"http.response.status_code": fmt.Sprintf("%d", crw.egressStatusCode),
+			"gecholog.session_id":       crw.sessionID,
+			"gecholog.transaction_id":   crw.transactionID,
+		},
+		Error: crw.egressStatusCode >= http.StatusInternalServerError,
+	}
+
+	spans := []tracing.Span{server}
+	spans = append(spans, phase("ingress_outbound", tracing.SPAN_KIND_INTERNAL, ts.server.Child(), crw.ingressOutboundTimer)...)
+	outbound := phase("outbound_inbound", tracing.SPAN_KIND_CLIENT, ts.outbound, crw.outboundInboundTimer)
+	for i := range outbound {
+		outbound[i].Attributes["url.full"] = upstreamLabel(crw.outboundURL) + crw.outboundURL.Path
+		outbound[i].Attributes["http.response.status_code"] = fmt.Sprintf("%d", crw.inboundStatusCode)
+		outbound[i].Error = crw.inboundStatusCode >= http.StatusInternalServerError
+	}
+	spans = append(spans, outbound...)
+	spans = append(spans, ts.processors...)
+	spans = append(spans, phase("egress_post", tracing.SPAN_KIND_INTERNAL, ts.server.Child(), crw.egressPostTimer)...)
+	return spans
+}
+
+// export queues the spans of a sampled trace, called when the log is complete
+func (ts *traceState) export(crw *GechologResponseWriter) {
+	if ts.exporter == nil || !ts.server.Sampled() {
+		return
+	}
+	ts.batcher.add(ts.exporter, ts.spans(crw))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/processorconfiguration"
+	"github.com/direktoren/gecholog/internal/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_tracingMiddleware(t *testing.T) {
+	type testCase struct {
+		name        string
+		config      *tracingConfig
+		traceparent string
+		wantTrace   bool
+		wantTraceID string
+		wantParent  string
+	}
+
+	testCases := []testCase{
+		{
+			name:        "disabled",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "continue trace of caller",
+			config:      &tracingConfig{},
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantTrace:   true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantParent:  "00f067aa0ba902b7",
+		},
+		{
+			name:        "invalid traceparent starts new trace",
+			config:      &tracingConfig{},
+			traceparent: "00-nothex-00f067aa0ba902b7-01",
+			wantTrace:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{ResponseWriter: httptest.NewRecorder()}
+			r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+			r.Header.Set("traceparent", tc.traceparent)
+
+			var seen *traceState
+			handler := tracingMiddlewareFunc(tc.config, nil, nil, &state{m: &sync.Mutex{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = w.(*GechologResponseWriter).trace
+			}))
+			handler.ServeHTTP(crw, r)
+
+			if !tc.wantTrace {
+				assert.Nil(t, seen)
+				return
+			}
+			assert.NotNil(t, seen)
+			assert.True(t, seen.server.IsValid())
+			assert.Equal(t, seen.server.TraceID, seen.outbound.TraceID)
+			assert.NotEqual(t, seen.server.SpanID, seen.outbound.SpanID)
+			if tc.wantTraceID != "" {
+				assert.Equal(t, tc.wantTraceID, seen.server.TraceIDString())
+				assert.Equal(t, tc.wantParent, hex.EncodeToString(seen.parentSpanID[:]))
+			}
+		})
+	}
+}
+
+func Test_traceStateExport(t *testing.T) {
+	received := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer collector.Close()
+
+	crw := &GechologResponseWriter{ResponseWriter: httptest.NewRecorder()}
+	r := httptest.NewRequest(http.MethodPost, "/service/standard/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Pattern = "/service/standard/"
+	config := &tracingConfig{OtlpEndpoint: collector.URL + "/v1/traces"}
+	batcher := newSpanBatcher(SPAN_QUEUE_SIZE, SPAN_BATCH_SIZE, time.Hour)
+	tracingMiddlewareFunc(config, newTraceExporter(config), batcher, &state{m: &sync.Mutex{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(crw, r)
+
+	start := time.Now()
+	crw.ingressEgressTimer.SetStart(start)
+	crw.ingressEgressTimer.SetStop(start.Add(40 * time.Millisecond))
+	crw.ingressOutboundTimer.SetStart(start)
+	crw.ingressOutboundTimer.SetStop(start.Add(10 * time.Millisecond))
+	crw.outboundInboundTimer.SetStart(start.Add(10 * time.Millisecond))
+	crw.outboundInboundTimer.SetStop(start.Add(30 * time.Millisecond))
+	crw.egressStatusCode = http.StatusOK
+	crw.inboundStatusCode = http.StatusOK
+	crw.outboundURL = url.URL{Scheme: "https", Host: "api.example.com", Path: "/v1/chat", RawQuery: "api-key=secret"}
+
+	logEntries := []processorLog{{outcome: PROCESSOR_COMPLETED}}
+	logEntries[0].Timestamp.SetStart(start.Add(5 * time.Millisecond))
+	logEntries[0].Timestamp.SetStop(start.Add(8 * time.Millisecond))
+	processorContext := crw.trace.server.Child()
+	crw.trace.processorSpans("request", []processorconfiguration.ProcessorConfiguration{{Name: "regex", ServiceBusTopic: "coburn.gl.regex"}}, logEntries, []tracing.SpanContext{processorContext})
+
+	// egress_post never stopped, no span for it
+	crw.trace.export(crw)
+	batcher.close(context.Background())
+
+	body := struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}{}
+	assert.NoError(t, json.Unmarshal(<-received, &body))
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	}
+	assert.Equal(t, []string{"gl /service/standard/", "ingress_outbound", "outbound_inbound", "processor regex"}, names)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+	for _, span := range spans[1:] {
+		assert.Equal(t, spans[0].SpanID, span.ParentSpanID)
+	}
+	assert.Equal(t, crw.trace.outbound.SpanIDString(), spans[2].SpanID)
+	assert.Equal(t, processorContext.SpanIDString(), spans[3].SpanID)
+}
+
+func Test_spanBatcher(t *testing.T) {
+	received := make(chan int, 10)
+	release := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []json.RawMessage `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- len(body.ResourceSpans[0].ScopeSpans[0].Spans)
+		<-release
+	}))
+	defer collector.Close()
+
+	exporter := tracing.NewExporter(collector.URL, "gl", time.Second)
+	span := func() []tracing.Span {
+		return []tracing.Span{{Name: "gl", Context: tracing.NewTrace(), Start: time.Now(), End: time.Now()}}
+	}
+
+	t.Run("flush on size and drop when full", func(t *testing.T) {
+		b := newSpanBatcher(1, 2, time.Hour)
+		b.add(exporter, append(span(), span()...))
+		assert.Equal(t, 2, <-received)
+
+		// The batcher is busy exporting, the queue holds one trace
+		b.add(exporter, span())
+		b.add(exporter, span())
+		assert.Equal(t, uint64(1), b.dropped.Load())
+
+		release <- struct{}{}
+		go func() { release <- struct{}{} }()
+		// The queued trace is flushed on close
+		b.close(context.Background())
+		assert.Equal(t, 1, <-received)
+	})
+
+	t.Run("flush on interval", func(t *testing.T) {
+		b := newSpanBatcher(SPAN_QUEUE_SIZE, SPAN_BATCH_SIZE, 20*time.Millisecond)
+		b.add(exporter, span())
+		select {
+		case n := <-received:
+			assert.Equal(t, 1, n)
+		case <-time.After(time.Second):
+			t.Fatal("spans not flushed")
+		}
+		release <- struct{}{}
+		b.close(context.Background())
+	})
+}
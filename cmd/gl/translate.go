@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/direktoren/gecholog/internal/store"
+	"github.com/direktoren/gecholog/internal/translate"
+)
+
+const TRANSLATION_OPENAI_TO_ANTHROPIC = "openai_to_anthropic"
+
+// translationMiddleware rewrites the outbound payload to the schema of the target and the inbound
+// payload back, as set by the outbound router. The untranslated payloads are kept in the log
+func translationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crw, ok := w.(*GechologResponseWriter)
+		if !ok {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			logger.Error("failed to cast ResponseWriter to GechologResponseWriter")
+			return
+		}
+
+		if crw.translation != TRANSLATION_OPENAI_TO_ANTHROPIC {
+			next.ServeHTTP(crw, r)
+			return
+		}
+
+		untranslated := bytes.Clone(crw.outboundBody.Bytes())
+		translated, err := translate.OpenAIToAnthropicRequest(untranslated)
+		if err != nil {
+			if errors.Is(err, translate.ErrStreaming) {
+				crw.inboundBody.WriteString(`{"error":"streaming is not supported with translation"}`)
+			} else {
+				crw.inboundBody.WriteString(`{"error":"payload could not be translated"}`)
+			}
+			crw.inboundStatusCode = http.StatusBadRequest
+
+			crw.requestErrorObject.AssignField("translation", err.Error())
+			return
+		}
+		crw.outboundBody.Reset()
+		crw.outboundBody.Write(translated)
+		store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload_untranslated", untranslated)
+		store.Store(&crw.requestObject, &crw.requestErrorObject, "outbound_payload", translated)
+
+		next.ServeHTTP(crw, r)
+
+		if crw.streamed || !json.Valid(crw.inboundBody.Bytes()) {
+			return
+		}
+		untranslated = bytes.Clone(crw.inboundBody.Bytes())
+		translated, err = translate.AnthropicToOpenAIResponse(untranslated, time.Now())
+		if err != nil {
+			crw.responseErrorObject.AssignField("translation", err.Error())
+			logger.Warn(
+				"inbound payload could not be translated",
+				slog.String("transaction_id", crw.transactionID),
+				slog.Any("error", err),
+			)
+			return
+		}
+		crw.inboundBody.Reset()
+		crw.inboundBody.Write(translated)
+		store.Store(&crw.responseObject, &crw.responseErrorObject, "inbound_payload_untranslated", untranslated)
+	})
+}
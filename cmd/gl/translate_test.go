@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/direktoren/gecholog/internal/gechologobject"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_translationMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		translation      string
+		payload          string
+		inbound          string
+		expectedNext     bool
+		expectedStatus   int
+		expectedOutbound string
+		expectedInbound  string
+	}{
+		{
+			name:             "translated",
+			translation:      TRANSLATION_OPENAI_TO_ANTHROPIC,
+			payload:          `{"model":"claude-sonnet","messages":[{"role":"user","content":"Hello"}]}`,
+			inbound:          `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}`,
+			expectedNext:     true,
+			expectedStatus:   http.StatusOK,
+			expectedOutbound: `{"model":"claude-sonnet","messages":[{"role":"user","content":[{"type":"text","text":"Hello"}]}],"max_tokens":4096}`,
+			expectedInbound:  `"chat.completion"`,
+		},
+		{
+			name:             "no translation",
+			payload:          `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`,
+			inbound:          `{"object":"chat.completion"}`,
+			expectedNext:     true,
+			expectedStatus:   http.StatusOK,
+			expectedOutbound: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`,
+			expectedInbound:  `"chat.completion"`,
+		},
+		{
+			name:             "streaming rejected",
+			translation:      TRANSLATION_OPENAI_TO_ANTHROPIC,
+			payload:          `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedOutbound: `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crw := &GechologResponseWriter{
+				ResponseWriter:      httptest.NewRecorder(),
+				outboundBody:        bytes.NewBufferString(tt.payload),
+				inboundBody:         bytes.NewBufferString(""),
+				inboundStatusCode:   http.StatusOK,
+				requestObject:       gechologobject.New(),
+				requestErrorObject:  gechologobject.New(),
+				responseObject:      gechologobject.New(),
+				responseErrorObject: gechologobject.New(),
+				translation:         tt.translation,
+			}
+
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				crw.inboundBody.WriteString(tt.inbound)
+			})
+			translationMiddleware(next).ServeHTTP(crw, httptest.NewRequest(http.MethodPost, "/service/standard/", nil))
+
+			assert.Equal(t, tt.expectedNext, nextCalled)
+			assert.Equal(t, tt.expectedStatus, crw.inboundStatusCode)
+			assert.JSONEq(t, tt.expectedOutbound, crw.outboundBody.String())
+			if !tt.expectedNext {
+				return
+			}
+
+			assert.Contains(t, crw.inboundBody.String(), tt.expectedInbound)
+
+			_, err := crw.responseObject.GetField("inbound_payload_untranslated")
+			assert.Equal(t, tt.translation == "", err != nil)
+			_, err = crw.requestObject.GetField("outbound_payload_untranslated")
+			assert.Equal(t, tt.translation == "", err != nil)
+		})
+	}
+}
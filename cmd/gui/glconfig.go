@@ -43,6 +43,7 @@ type gl_serviceBusConfig struct {
 	TopicExactIsAlive string `json:"topic_exact_isalive"`
 	TopicExactLogger  string `json:"topic_exact_logger"`
 	Token             string `json:"token"`
+	TopicExactReload  string `json:"topic_exact_reload,omitempty"`
 }
 
 type gl_tlsUserConfig struct {
@@ -50,6 +51,9 @@ type gl_tlsUserConfig struct {
 		Enabled         bool   `json:"enabled"`
 		CertificateFile string `json:"certificate_file"`
 		PrivateKeyFile  string `json:"private_key_file"`
+
+		ClientAuth    string   `json:"client_auth,omitempty"`
+		ClientCAFiles []string `json:"client_ca_files,omitempty"`
 	} `json:"ingress"`
 	Outbound struct {
 		InsecureFlag       bool     `json:"insecure"`
@@ -59,7 +63,7 @@ type gl_tlsUserConfig struct {
 }
 
 type processorsMatrix struct {
-	Processors []([]processorconfiguration.ProcessorConfiguration) `json:"processors" validate:"dive,unique=Name,unique=ServiceBusTopic,dive"`
+	Processors []([]processorconfiguration.ProcessorConfiguration) `json:"processors" validate:"dive,unique=Name,uniquenonempty=ServiceBusTopic,dive"`
 }
 
 type filter struct {
@@ -82,6 +86,8 @@ type Gl_config_v1001 struct {
 	ServiceBusConfig gl_serviceBusConfig `json:"service_bus"`
 
 	Port            int    `json:"gl_port"`
+	MetricsPort     int    `json:"metrics_port,omitempty"`
+	ShutdownTimeout int    `json:"shutdown_timeout,omitempty"`
 	SessionIDHeader string `json:"session_id_header"`
 
 	MaskedHeaders []string `json:"masked_headers"`
@@ -92,9 +98,18 @@ type Gl_config_v1001 struct {
 
 	Routers []router.Router `json:"routers"`
 
+	InlineBinaryMaxBytes int `json:"inline_binary_max_bytes,omitempty"`
+
 	RequestProcessors  processorsMatrix `json:"request"`
 	ResponseProcessors processorsMatrix `json:"response"`
 	Logger             finalLogger      `json:"logger"`
+
+	Tracing json.RawMessage `json:"tracing,omitempty"`
+
+	Compression json.RawMessage `json:"compression,omitempty"`
+
+	ConsumerHeader string          `json:"consumer_header,omitempty"`
+	Consumers      json.RawMessage `json:"consumers,omitempty"`
 }
 
 func (g *Gl_config_v1001) loadConfigFile(file string) error {
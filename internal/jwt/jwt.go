@@ -0,0 +1,308 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Verification of compact JWS tokens signed with HS256, RS256 or ES256
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrAlgorithm = errors.New("algorithm not allowed")
+	ErrSignature = errors.New("invalid signature")
+	ErrExpired   = errors.New("token expired")
+	ErrNoExpiry  = errors.New("token has no expiry")
+	ErrNotYet    = errors.New("token not valid yet")
+	ErrIssuer    = errors.New("invalid issuer")
+	ErrAudience  = errors.New("invalid audience")
+)
+
+// Key verifies signatures of one algorithm. The algorithm follows the key type
+type Key struct {
+	ID        string
+	Algorithm string
+	key       any
+}
+
+func (k Key) verify(signingInput []byte, signature []byte) bool {
+	digest := sha256.Sum256(signingInput)
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// KeySet holds the keys a token may be signed with
+type KeySet struct {
+	Keys []Key
+}
+
+// NewHMACKeySet returns a key set with one HS256 secret
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{Keys: []Key{{Algorithm: HS256, key: secret}}}
+}
+
+// ParsePEM reads an RSA or P-256 public key, or the public key of a certificate
+func ParsePEM(data []byte) (*KeySet, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found")
+	}
+
+	var public any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		public = key
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		public = cert.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported pem block %s", block.Type)
+	}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return &KeySet{Keys: []Key{{Algorithm: RS256, key: key}}}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+		}
+		return &KeySet{Keys: []Key{{Algorithm: ES256, key: key}}}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ParseJWKS reads a JSON Web Key Set. Encryption keys and unsupported key types are skipped
+func ParseJWKS(data []byte) (*KeySet, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{}
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: invalid n: %v", i, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %d: invalid e", i)
+			}
+			ks.Keys = append(ks.Keys, Key{ID: k.Kid, Algorithm: RS256, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: invalid x: %v", i, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: invalid y: %v", i, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %d: point not on curve", i)
+			}
+			ks.Keys = append(ks.Keys, Key{ID: k.Kid, Algorithm: ES256, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: invalid k: %v", i, err)
+			}
+			ks.Keys = append(ks.Keys, Key{ID: k.Kid, Algorithm: HS256, key: secret})
+		}
+	}
+	if len(ks.Keys) == 0 {
+		return nil, fmt.Errorf("no usable keys")
+	}
+	return ks, nil
+}
+
+// Claims of a verified token
+type Claims map[string]any
+
+// Verify checks the signature of token against the keys and returns its claims. An empty allowed list allows all algorithms of the keys
+func (ks *KeySet) Verify(token string, allowed []string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerBytes, &header) != nil {
+		return nil, ErrMalformed
+	}
+	if len(allowed) != 0 && !slices.Contains(allowed, header.Alg) {
+		return nil, ErrAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	verified := false
+	signingInput := []byte(parts[0] + "." + parts[1])
+	for _, k := range ks.Keys {
+		// The key decides the algorithm, never the token
+		if k.Algorithm != header.Alg || (header.Kid != "" && k.ID != "" && k.ID != header.Kid) {
+			continue
+		}
+		if k.verify(signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	claims := Claims{}
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+	if decoder.Decode(&claims) != nil {
+		return nil, ErrMalformed
+	}
+	return claims, nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Strings returns a string or array claim as strings. Other values are formatted
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case nil:
+		return []string{}
+	case string:
+		return []string{v}
+	case []any:
+		s := []string{}
+		for _, item := range v {
+			s = append(s, fmt.Sprint(item))
+		}
+		return s
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// Validate checks exp and nbf with leeway, and iss and aud when not empty
+func (c Claims) Validate(now time.Time, leeway time.Duration, issuer string, audience string) error {
+	// A token without exp would be valid forever
+	if _, exists := c["exp"]; !exists {
+		return ErrNoExpiry
+	}
+	exp, ok := c.time("exp")
+	if !ok {
+		return ErrMalformed
+	}
+	if now.After(exp.Add(leeway)) {
+		return ErrExpired
+	}
+	if _, exists := c["nbf"]; exists {
+		nbf, ok := c.time("nbf")
+		if !ok {
+			return ErrMalformed
+		}
+		if now.Add(leeway).Before(nbf) {
+			return ErrNotYet
+		}
+	}
+	if issuer != "" {
+		if iss, _ := c["iss"].(string); iss != issuer {
+			return ErrIssuer
+		}
+	}
+	if audience != "" && !slices.Contains(c.Strings("aud"), audience) {
+		return ErrAudience
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func sign(t *testing.T, alg string, kid string, key any, claims map[string]any) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	input := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("a-shared-secret-of-enough-length")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa-1","use":"sig","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec-1","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc-1","use":"enc","n":"%s","e":"AQAB"}
+	]}`,
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(bigEndian(rsaKey.E)),
+		base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+	)
+	jwksKeys, err := ParseJWKS([]byte(jwks))
+	assert.NoError(t, err)
+	assert.Len(t, jwksKeys.Keys, 2)
+
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	assert.NoError(t, err)
+	pemKeys, err := ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+
+	claims := map[string]any{"sub": "alice", "groups": []string{"llm-users"}}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		keys    *KeySet
+		token   string
+		allowed []string
+		wantErr error
+	}{
+		{name: "HS256", keys: NewHMACKeySet(secret), token: sign(t, HS256, "", secret, claims)},
+		{name: "HS256 wrong secret", keys: NewHMACKeySet([]byte("other")), token: sign(t, HS256, "", secret, claims), wantErr: ErrSignature},
+		{name: "RS256 from jwks", keys: jwksKeys, token: sign(t, RS256, "rsa-1", rsaKey, claims)},
+		{name: "ES256 from jwks", keys: jwksKeys, token: sign(t, ES256, "ec-1", ecKey, claims)},
+		{name: "ES256 from pem", keys: pemKeys, token: sign(t, ES256, "", ecKey, claims)},
+		{name: "unknown kid", keys: jwksKeys, token: sign(t, RS256, "rsa-2", rsaKey, claims), wantErr: ErrSignature},
+		{name: "other signer", keys: jwksKeys, token: sign(t, RS256, "rsa-1", otherRSA, claims), wantErr: ErrSignature},
+		{name: "algorithm not allowed", keys: jwksKeys, token: sign(t, RS256, "rsa-1", rsaKey, claims), allowed: []string{ES256}, wantErr: ErrAlgorithm},
+		{name: "alg none", keys: jwksKeys, token: encode(map[string]string{"alg": "none"}) + "." + encode(claims) + ".", wantErr: ErrSignature},
+		{name: "malformed", keys: jwksKeys, token: "not-a-token", wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := tt.keys.Verify(tt.token, tt.allowed)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", verified["sub"])
+			assert.Equal(t, []string{"llm-users"}, verified.Strings("groups"))
+		})
+	}
+}
+
+func bigEndian(e int) []byte {
+	b := []byte{}
+	for ; e > 0; e >>= 8 {
+		b = append([]byte{byte(e)}, b...)
+	}
+	return b
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		claims   string
+		issuer   string
+		audience string
+		wantErr  error
+	}{
+		{name: "valid", claims: `{"exp":1700000100,"nbf":1699999900,"iss":"https://idp.example.com","aud":["gl","other"]}`, issuer: "https://idp.example.com", audience: "gl"},
+		{name: "no exp", claims: `{"sub":"alice"}`, wantErr: ErrNoExpiry},
+		{name: "expired", claims: `{"exp":1699999900}`, wantErr: ErrExpired},
+		{name: "expired within leeway", claims: `{"exp":1699999990}`},
+		{name: "not yet valid", claims: `{"exp":1700000200,"nbf":1700000100}`, wantErr: ErrNotYet},
+		{name: "exp not a number", claims: `{"exp":"tomorrow"}`, wantErr: ErrMalformed},
+		{name: "wrong issuer", claims: `{"exp":1700000100,"iss":"https://evil.example.com"}`, issuer: "https://idp.example.com", wantErr: ErrIssuer},
+		{name: "audience string", claims: `{"exp":1700000100,"aud":"gl"}`, audience: "gl"},
+		{name: "wrong audience", claims: `{"exp":1700000100,"aud":"other"}`, audience: "gl", wantErr: ErrAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := Claims{}
+			decoder := json.NewDecoder(strings.NewReader(tt.claims))
+			decoder.UseNumber()
+			assert.NoError(t, decoder.Decode(&claims))
+
+			err := claims.Validate(now, 30*time.Second, tt.issuer, tt.audience)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal prometheus text exposition of counters, gauges and histograms
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+type series struct {
+	labelValues []string
+
+	value float64
+
+	// Histograms only
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+type family struct {
+	m sync.Mutex
+
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+
+	series map[string]*series
+}
+
+// Caller holds the lock
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.metricType == typeHistogram {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) add(labelValues []string, v float64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.get(labelValues).value += v
+}
+
+func (f *family) observe(labelValues []string, v float64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	s := f.get(labelValues)
+	for i, upperBound := range f.buckets {
+		if v <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Counter only goes up
+type Counter struct {
+	f *family
+}
+
+func (c Counter) Inc(labelValues ...string) {
+	c.f.add(labelValues, 1)
+}
+
+func (c Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.add(labelValues, v)
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	f *family
+}
+
+func (g Gauge) Inc(labelValues ...string) {
+	g.f.add(labelValues, 1)
+}
+
+func (g Gauge) Dec(labelValues ...string) {
+	g.f.add(labelValues, -1)
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	f *family
+}
+
+func (h Histogram) Observe(v float64, labelValues ...string) {
+	h.f.observe(labelValues, v)
+}
+
+// Registry holds the metric families in registration order
+type Registry struct {
+	m        sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name string, help string, metricType string, buckets []float64, labelNames []string) *family {
+	r.m.Lock()
+	defer r.m.Unlock()
+	f := &family{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*series{},
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) Counter {
+	return Counter{f: r.register(name, help, typeCounter, nil, labelNames)}
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) Gauge {
+	return Gauge{f: r.register(name, help, typeGauge, nil, labelNames)}
+}
+
+// NewHistogram sorts the bucket upper bounds, the +Inf bucket is added when written
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return Histogram{f: r.register(name, help, typeHistogram, sorted, labelNames)}
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Write writes all families in the prometheus text format. Series are sorted by label values
+func (r *Registry) Write(w io.Writer) error {
+	r.m.Lock()
+	families := append([]*family{}, r.families...)
+	r.m.Unlock()
+
+	b := strings.Builder{}
+	for _, f := range families {
+		f.m.Lock()
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.metricType)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.metricType != typeHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+				continue
+			}
+			for i, upperBound := range f.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", formatFloat(upperBound)), s.bucketCounts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), formatFloat(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), s.count)
+		}
+		f.m.Unlock()
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler serves the registry for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
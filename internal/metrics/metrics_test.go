@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests.", "router", "status_code")
+	inFlight := r.NewGauge("test_in_flight", "In flight.")
+	latency := r.NewHistogram("test_duration_seconds", "Latency.", []float64{1, 0.1}, "router")
+
+	requests.Inc("/service/standard/", "200")
+	requests.Inc("/service/standard/", "200")
+	requests.Add(3, "/echo/", "500")
+	requests.Add(-1, "/echo/", "500") // ignored
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency.Observe(0.05, `/quote"d/`)
+	latency.Observe(0.5, `/quote"d/`)
+	latency.Observe(5, `/quote"d/`)
+
+	b := strings.Builder{}
+	assert.NoError(r.Write(&b))
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{router="/echo/",status_code="500"} 3
+test_requests_total{router="/service/standard/",status_code="200"} 2
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{router="/quote\"d/",le="0.1"} 1
+test_duration_seconds_bucket{router="/quote\"d/",le="1"} 2
+test_duration_seconds_bucket{router="/quote\"d/",le="+Inf"} 3
+test_duration_seconds_sum{router="/quote\"d/"} 5.55
+test_duration_seconds_count{router="/quote\"d/"} 3
+`
+	assert.Equal(expected, b.String())
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, rec.Body.String(), "test_total 1\n")
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.", "router")
+	assert.Panics(t, func() { c.Inc() })
+}
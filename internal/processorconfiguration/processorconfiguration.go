@@ -1,5 +1,11 @@
 package processorconfiguration
 
+import (
+	"fmt"
+
+	"github.com/direktoren/gecholog/internal/protectedheader"
+)
+
 type ProcessorConfiguration struct {
 	Name               string   `json:"name" validate:"required,alphanumunderscore"`
 	Modifier           bool     `json:"modifier"`
@@ -8,6 +14,60 @@ type ProcessorConfiguration struct {
 	InputFieldsInclude []string `json:"input_fields_include" validate:"unique,dive,alphanumunderscore"`
 	InputFieldsExclude []string `json:"input_fields_exclude" validate:"unique,dive,alphanumunderscore"`
 	OutputFieldsWrite  []string `json:"output_fields_write" validate:"unique,dive,alphanumunderscore"`
-	ServiceBusTopic    string   `json:"service_bus_topic" validate:"required,alphanumdot"`
+	ServiceBusTopic    string   `json:"service_bus_topic,omitempty" validate:"required_without=Http,excluded_with=Http,omitempty,alphanumdot"`
 	Timeout            int      `json:"timeout" validate:"min=1"`
+
+	// Call the processor over http instead of the service bus
+	Http *HttpTransport `json:"http,omitempty" validate:"omitempty"`
+
+	Condition *Condition `json:"condition,omitempty" validate:"omitempty"`
+}
+
+// Condition for calling a processor, all set conditions must match. Otherwise the processor is
+// skipped. Match keys are gjson paths in the request or response object and values use the syntax
+// of the ingress headers. Status codes are the inbound status codes and only apply to response processors
+type Condition struct {
+	Routers        []string            `json:"routers,omitempty" validate:"omitempty,unique,dive,router"`
+	ExcludeRouters []string            `json:"exclude_routers,omitempty" validate:"omitempty,unique,dive,router"`
+	Methods        []string            `json:"methods,omitempty" validate:"omitempty,unique,dive,oneof=GET HEAD POST PUT PATCH DELETE OPTIONS"`
+	StatusCodes    []int               `json:"status_codes,omitempty" validate:"omitempty,unique,dive,min=100,max=599"`
+	Match          map[string][]string `json:"match,omitempty" validate:"omitempty,dive,keys,required,endkeys,gt=0,dive,required"`
+}
+
+// Requirements compiles the match conditions
+func (c *Condition) Requirements() (protectedheader.Requirements, error) {
+	return protectedheader.CompileRequirements(protectedheader.ProtectedHeader(c.Match))
+}
+
+func (c *Condition) String() string {
+	return fmt.Sprintf("routers:%v exclude_routers:%v methods:%v status_codes:%v match:%v", c.Routers, c.ExcludeRouters, c.Methods, c.StatusCodes, c.Match)
+}
+
+// HTTP transport of a processor. The message is sent in a POST and the processor answers
+// with the same message format as on the service bus
+type HttpTransport struct {
+	Url     string              `json:"url" validate:"required,http_url"`
+	Headers map[string][]string `json:"headers,omitempty" validate:"omitempty,dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,required,ascii"`
+	Tls     *HttpTls            `json:"tls,omitempty" validate:"omitempty"`
+}
+
+// TLS settings of the http transport. Without ca_files the outbound certificates of gl are trusted
+type HttpTls struct {
+	CaFiles         []string `json:"ca_files,omitempty" validate:"omitempty,unique,dive,file"`
+	CertificateFile string   `json:"certificate_file,omitempty" validate:"required_with=PrivateKeyFile,omitempty,file"`
+	PrivateKeyFile  string   `json:"private_key_file,omitempty" validate:"required_with=CertificateFile,omitempty,file"`
+	Insecure        bool     `json:"insecure,omitempty"`
+}
+
+func (h *HttpTransport) String() string {
+	// Header values are not printed
+	headers := []string{}
+	for header := range h.Headers {
+		headers = append(headers, header)
+	}
+	s := fmt.Sprintf("url:%s headers:%v", h.Url, headers)
+	if h.Tls != nil {
+		s += fmt.Sprintf(" tls:{ca_files:%v certificate_file:%s private_key_file:%s insecure:%v}", h.Tls.CaFiles, h.Tls.CertificateFile, h.Tls.PrivateKeyFile, h.Tls.Insecure)
+	}
+	return s
 }
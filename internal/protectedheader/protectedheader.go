@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 )
 
 // ProtectedHeader is a type derived from http.Header
@@ -133,30 +135,146 @@ func Equal(a ProtectedHeader, b ProtectedHeader) bool {
 
 // All headers and values have to be equal i non-empty, but exists in b if empty
 func EqualIfNonEmptyExistsIfCatchall(a ProtectedHeader, b ProtectedHeader) (bool, string) {
+	requirements, err := CompileRequirements(a)
+	if err != nil {
+		return false, err.Error()
+	}
+	return requirements.Check(b)
+}
+
+// Prefixes of ingress header requirement values. Values without a prefix must match exactly
+const (
+	REGEX_PREFIX     = "regex:"  // a value of the header matches the whole pattern
+	NOT_REGEX_PREFIX = "!regex:" // no value of the header matches the whole pattern, absent is fine. An empty pattern requires the header to be absent
+	ONEOF_PREFIX     = "oneof:"  // a value of the header equals one of the oneof: values
+)
+
+// Requirement is the compiled requirement of one ingress header
+type Requirement struct {
+	exact   []string
+	oneOf   map[string]struct{}
+	match   []*regexp.Regexp
+	noMatch []*regexp.Regexp
+}
+
+// Requirements are compiled once per router, the header name is the key
+type Requirements map[string]Requirement
+
+// CompileRequirements compiles the patterns of the ingress header requirements. Exact values cannot be mixed with
+// prefixed values, and a header without values is an error since it would accept any request
+func CompileRequirements(a ProtectedHeader) (Requirements, error) {
+	requirements := Requirements{}
 	for header, values := range a {
-		_, exists := b[header]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("header:'%s' has no values", header)
+		}
+		r := Requirement{oneOf: map[string]struct{}{}}
+		prefixed := 0
+		for _, value := range values {
+			switch {
+			case strings.HasPrefix(value, NOT_REGEX_PREFIX):
+				pattern := strings.TrimPrefix(value, NOT_REGEX_PREFIX)
+				if pattern == "" {
+					// Any value is not allowed
+					pattern = "(?s).*"
+				}
+				re, err := compilePattern(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("header:'%s' invalid pattern: %v", header, err)
+				}
+				r.noMatch = append(r.noMatch, re)
+				prefixed++
+			case strings.HasPrefix(value, REGEX_PREFIX):
+				re, err := compilePattern(strings.TrimPrefix(value, REGEX_PREFIX))
+				if err != nil {
+					return nil, fmt.Errorf("header:'%s' invalid pattern: %v", header, err)
+				}
+				r.match = append(r.match, re)
+				prefixed++
+			case strings.HasPrefix(value, ONEOF_PREFIX):
+				r.oneOf[strings.TrimPrefix(value, ONEOF_PREFIX)] = struct{}{}
+				prefixed++
+			default:
+				r.exact = append(r.exact, value)
+			}
+		}
+		if prefixed != 0 && len(r.exact) != 0 {
+			return nil, fmt.Errorf("header:'%s' mixes exact and prefixed values", header)
+		}
+		requirements[header] = r
+	}
+	return requirements, nil
+}
+
+// compilePattern anchors the pattern, it must match the whole value
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// onlyNegative is true when the header may be absent
+func (r Requirement) onlyNegative() bool {
+	return len(r.exact) == 0 && len(r.oneOf) == 0 && len(r.match) == 0
+}
+
+func anyMatch(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns false and the reason if b does not meet the requirements
+func (requirements Requirements) Check(b ProtectedHeader) (bool, string) {
+	for header, r := range requirements {
+		values, exists := b[header]
 		if !exists {
+			if r.onlyNegative() {
+				continue
+			}
 			return false, fmt.Sprintf("header:'%s' missing", header)
 		}
-		if len(values) == 1 && values[0] == "regex:.+" {
-			// If the header exists as a requirement, b values just need to be non-empty
-			if len(b[header]) == 0 {
-				return false, fmt.Sprintf("header:'%s' values missing", header)
+		for _, re := range r.noMatch {
+			if anyMatch(re, values) {
+				return false, fmt.Sprintf("header:'%s' not allowed", header)
+			}
+		}
+		if r.onlyNegative() {
+			continue
+		}
+		if len(r.exact) != 0 {
+			if !equalStringSlices(values, r.exact) {
+				return false, fmt.Sprintf("header:'%s' values invalid", header)
+			}
+			continue
+		}
+		if func() bool {
+			for _, s := range values {
+				if s != "" {
+					return false
+				}
+			}
+			return true
+		}() {
+			return false, fmt.Sprintf("header:'%s' values missing", header)
+		}
+		for _, re := range r.match {
+			if !anyMatch(re, values) {
+				return false, fmt.Sprintf("header:'%s' values invalid", header)
 			}
-			if func() bool {
-				for _, s := range b[header] {
-					if s != "" {
-						return false
+		}
+		if len(r.oneOf) != 0 {
+			if !func() bool {
+				for _, s := range values {
+					if _, ok := r.oneOf[s]; ok {
+						return true
 					}
 				}
-				return true
+				return false
 			}() {
-				return false, fmt.Sprintf("header:'%s' values missing", header)
+				return false, fmt.Sprintf("header:'%s' values invalid", header)
 			}
-			continue
-		}
-		if !equalStringSlices(b[header], values) {
-			return false, fmt.Sprintf("header:'%s' values invalid", header)
 		}
 	}
 	return true, ""
@@ -472,6 +472,18 @@ func TestEqualIfNonEmptyExistsIfEmpty(t *testing.T) {
 			b:        ProtectedHeader{"Accept": []string{"application/json", "text/plain"}},
 			expected: false,
 		},
+		{
+			name:     "Requirement without values",
+			a:        ProtectedHeader{"Api-Key": []string{}},
+			b:        ProtectedHeader{"Api-Key": []string{"sk-team"}},
+			expected: false,
+		},
+		{
+			name:     "Requirement without values, header missing",
+			a:        ProtectedHeader{"Api-Key": []string{}},
+			b:        ProtectedHeader{},
+			expected: false,
+		},
 		{
 			name:     "One Empty",
 			a:        ProtectedHeader{},
@@ -495,6 +507,167 @@ func TestEqualIfNonEmptyExistsIfEmpty(t *testing.T) {
 	}
 }
 
+func TestCompileRequirements(t *testing.T) {
+	testCases := []struct {
+		name    string
+		a       ProtectedHeader
+		wantErr bool
+	}{
+		{
+			name: "Exact values",
+			a:    ProtectedHeader{"Accept": []string{"text/plain", "application/json"}},
+		},
+		{
+			name: "Patterns and oneof",
+			a:    ProtectedHeader{"Api-Key": []string{"regex:sk-.*", "!regex:.*revoked.*", "oneof:sk-a", "oneof:sk-b"}},
+		},
+		{
+			name:    "Invalid pattern",
+			a:       ProtectedHeader{"Api-Key": []string{"regex:("}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid negated pattern",
+			a:       ProtectedHeader{"Api-Key": []string{"!regex:[a-"}},
+			wantErr: true,
+		},
+		{
+			name:    "Exact mixed with prefixed",
+			a:       ProtectedHeader{"Api-Key": []string{"sk-a", "oneof:sk-b"}},
+			wantErr: true,
+		}, {
+			name:    "No values",
+			a:       ProtectedHeader{"Api-Key": []string{}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CompileRequirements(tc.a)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRequirementsCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		a        ProtectedHeader
+		b        ProtectedHeader
+		expected bool
+		reason   string
+	}{
+		{
+			name:     "Pattern matches",
+			a:        ProtectedHeader{"Api-Key": []string{"regex:sk-[a-z]+"}},
+			b:        ProtectedHeader{"Api-Key": []string{"sk-team"}},
+			expected: true,
+		},
+		{
+			name:     "Pattern does not match",
+			a:        ProtectedHeader{"Api-Key": []string{"regex:sk-[a-z]+"}},
+			b:        ProtectedHeader{"Api-Key": []string{"pk-team"}},
+			expected: false,
+			reason:   "header:'Api-Key' values invalid",
+		},
+		{
+			name:     "Pattern matches the whole value",
+			a:        ProtectedHeader{"Api-Key": []string{"regex:sk-[a-z]+"}},
+			b:        ProtectedHeader{"Api-Key": []string{"pk-sk-team"}},
+			expected: false,
+			reason:   "header:'Api-Key' values invalid",
+		},
+		{
+			name:     "All patterns must match",
+			a:        ProtectedHeader{"Api-Key": []string{"regex:sk-.*", "regex:.*-prod"}},
+			b:        ProtectedHeader{"Api-Key": []string{"sk-team-dev"}},
+			expected: false,
+			reason:   "header:'Api-Key' values invalid",
+		},
+		{
+			name:     "One of several keys",
+			a:        ProtectedHeader{"Api-Key": []string{"oneof:key-team-a", "oneof:key-team-b"}},
+			b:        ProtectedHeader{"Api-Key": []string{"key-team-b"}},
+			expected: true,
+		},
+		{
+			name:     "None of several keys",
+			a:        ProtectedHeader{"Api-Key": []string{"oneof:key-team-a", "oneof:key-team-b"}},
+			b:        ProtectedHeader{"Api-Key": []string{"key-team-c"}},
+			expected: false,
+			reason:   "header:'Api-Key' values invalid",
+		},
+		{
+			name:     "Missing header",
+			a:        ProtectedHeader{"Api-Key": []string{"oneof:key-team-a"}},
+			b:        ProtectedHeader{},
+			expected: false,
+			reason:   "header:'Api-Key' missing",
+		},
+		{
+			name:     "Negated pattern allows absent header",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:true"}},
+			b:        ProtectedHeader{},
+			expected: true,
+		},
+		{
+			name:     "Negated pattern allows other values",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:true"}},
+			b:        ProtectedHeader{"X-Debug": []string{"false"}},
+			expected: true,
+		},
+		{
+			name:     "Negated pattern rejects match",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:true"}},
+			b:        ProtectedHeader{"X-Debug": []string{"true"}},
+			expected: false,
+			reason:   "header:'X-Debug' not allowed",
+		},
+		{
+			name:     "Negated pattern matches the whole value",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:true"}},
+			b:        ProtectedHeader{"X-Debug": []string{"untrue"}},
+			expected: true,
+		},
+		{
+			name:     "Empty negated pattern rejects any value",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:"}},
+			b:        ProtectedHeader{"X-Debug": []string{"false"}},
+			expected: false,
+			reason:   "header:'X-Debug' not allowed",
+		},
+		{
+			name:     "Empty negated pattern requires absent header",
+			a:        ProtectedHeader{"X-Debug": []string{"!regex:"}},
+			b:        ProtectedHeader{"X-Debug": []string{""}},
+			expected: false,
+			reason:   "header:'X-Debug' not allowed",
+		},
+		{
+			name:     "Pattern and negated pattern",
+			a:        ProtectedHeader{"Api-Key": []string{"regex:sk-.*", "!regex:.*revoked.*"}},
+			b:        ProtectedHeader{"Api-Key": []string{"sk-revoked"}},
+			expected: false,
+			reason:   "header:'Api-Key' not allowed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requirements, err := CompileRequirements(tc.a)
+			assert.NoError(t, err)
+			result, reason := requirements.Check(tc.b)
+			assert.Equal(t, tc.expected, result, reason)
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+}
+
 func TestRemoveIfHeaderNameIsNotAllowed(t *testing.T) {
 	nonAllowed := map[string]struct{}{
 		"Forbidden-Header":   {},
@@ -2,6 +2,10 @@ package router
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
 
 	"github.com/direktoren/gecholog/internal/protectedheader"
 	"github.com/direktoren/gecholog/internal/validate"
@@ -34,28 +38,305 @@ var CommonHeaders = map[string]struct{}{
 type IngressNode struct {
 	Headers protectedheader.ProtectedHeader `json:"headers" validate:"omitempty,dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,required,ascii"`
 	//	Headers protectedheader.ProtectedHeader `json:"headers" validate:"omitempty,dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,ascii"`
+
+	JWT               *JWTRequirement               `json:"jwt,omitempty" validate:"omitempty"`
+	ClientCertificate *ClientCertificateRequirement `json:"client_certificate,omitempty" validate:"omitempty"`
+}
+
+// Verified client certificate required on ingress. Patterns are regular expressions, a certificate
+// is allowed if its subject common name matches a subject pattern or a SAN matches a san pattern.
+// Without patterns any verified certificate is allowed
+type ClientCertificateRequirement struct {
+	Subjects []string `json:"subjects,omitempty" validate:"omitempty,unique,dive,required"`
+	SANs     []string `json:"sans,omitempty" validate:"omitempty,unique,dive,required"`
+}
+
+func (c *ClientCertificateRequirement) String() string {
+	return fmt.Sprintf("subjects:%v sans:%v", c.Subjects, c.SANs)
+}
+
+// Patterns compiles the subject and san patterns
+func (c *ClientCertificateRequirement) Patterns() ([]*regexp.Regexp, []*regexp.Regexp, error) {
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		compiled := []*regexp.Regexp{}
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	subjects, err := compile(c.Subjects)
+	if err != nil {
+		return nil, nil, err
+	}
+	sans, err := compile(c.SANs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subjects, sans, nil
+}
+
+// JWT required on ingress. The key is one of jwks_file, public_key_file (pem) or secret (HS256).
+// Leeway is in seconds. A required claim must have one of the listed values
+type JWTRequirement struct {
+	Header         string              `json:"header,omitempty" validate:"omitempty,ascii,excludesall= /()<>@;:\\\"[]?="`
+	JwksFile       string              `json:"jwks_file,omitempty" validate:"required_without_all=PublicKeyFile Secret,omitempty,file"`
+	PublicKeyFile  string              `json:"public_key_file,omitempty" validate:"excluded_with=JwksFile,omitempty,file"`
+	Secret         string              `json:"secret,omitempty" validate:"excluded_with=JwksFile PublicKeyFile"`
+	Algorithms     []string            `json:"algorithms,omitempty" validate:"omitempty,unique,dive,oneof=HS256 RS256 ES256"`
+	Issuer         string              `json:"issuer,omitempty"`
+	Audience       string              `json:"audience,omitempty"`
+	Leeway         int                 `json:"leeway,omitempty" validate:"min=0"`
+	LogClaims      []string            `json:"log_claims,omitempty" validate:"omitempty,unique,dive,required"`
+	RequiredClaims map[string][]string `json:"required_claims,omitempty" validate:"omitempty,dive,keys,required,endkeys,gt=0,dive,required"`
+}
+
+func (j *JWTRequirement) String() string {
+	// The secret is not printed
+	return fmt.Sprintf("header:%s jwks_file:%s public_key_file:%s algorithms:%v issuer:%s audience:%s leeway:%d log_claims:%v required_claims:%v", j.Header, j.JwksFile, j.PublicKeyFile, j.Algorithms, j.Issuer, j.Audience, j.Leeway, j.LogClaims, j.RequiredClaims)
 }
 
 type OutboundNode struct {
-	Url      string                          `json:"url" validate:"required,http_url"`
+	Url      string                          `json:"url" validate:"required_without=Targets,excluded_with=Targets,omitempty,http_url"`
 	Endpoint string                          `json:"endpoint" validate:"omitempty,endpoint"`
 	Headers  protectedheader.ProtectedHeader `json:"headers" validate:"dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,required,ascii"`
+
+	// Replaces url with a list of targets to balance between
+	Targets   []TargetNode `json:"targets,omitempty" validate:"omitempty,dive"`
+	Balancing string       `json:"balancing,omitempty" validate:"omitempty,oneof=weighted_round_robin least_in_flight"`
+
+	ClientCertificate *OutboundClientCertificate `json:"client_certificate,omitempty" validate:"omitempty"`
+	Proxy             *Proxy                     `json:"proxy,omitempty" validate:"omitempty"`
+}
+
+// Client certificate presented on outbound calls of the router
+type OutboundClientCertificate struct {
+	CertificateFile string `json:"certificate_file" validate:"required,file"`
+	PrivateKeyFile  string `json:"private_key_file" validate:"required,file"`
+}
+
+// HTTP CONNECT proxy for outbound calls. no_proxy entries follow NO_PROXY: hosts, domains, ip addresses and CIDRs
+type Proxy struct {
+	Url     string   `json:"url" validate:"required,http_url"`
+	NoProxy []string `json:"no_proxy,omitempty" validate:"omitempty,dive,required,ascii"`
+}
+
+// A single upstream target. Headers are added on top of the outbound headers
+type TargetNode struct {
+	Url     string                          `json:"url" validate:"required,http_url"`
+	Weight  int                             `json:"weight" validate:"omitempty,min=1"`
+	Headers protectedheader.ProtectedHeader `json:"headers,omitempty" validate:"omitempty,dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,required,ascii"`
+}
+
+// Returns the targets of the node. A node with only url is a single target
+func (ni *OutboundNode) GetTargets() []TargetNode {
+	if len(ni.Targets) == 0 {
+		return []TargetNode{{Url: ni.Url, Weight: 1}}
+	}
+	targets := make([]TargetNode, len(ni.Targets))
+	for i, t := range ni.Targets {
+		targets[i] = t
+		if targets[i].Weight == 0 {
+			targets[i].Weight = 1
+		}
+	}
+	return targets
 }
 
 // Stringer
 func (ni *IngressNode) String() string {
-	return fmt.Sprintf("headers:%s", ni.Headers.String())
+	s := fmt.Sprintf("headers:%s", ni.Headers.String())
+	if ni.JWT != nil {
+		s += fmt.Sprintf(" jwt:{%s}", ni.JWT.String())
+	}
+	if ni.ClientCertificate != nil {
+		s += fmt.Sprintf(" client_certificate:{%s}", ni.ClientCertificate.String())
+	}
+	return s
 }
 
 // Stringer
 func (ni *OutboundNode) String() string {
-	return fmt.Sprintf("url:%s endpoint:%s headers:%s", ni.Url, ni.Endpoint, (ni.Headers).String())
+	s := fmt.Sprintf("url:%s endpoint:%s headers:%s", ni.Url, ni.Endpoint, (ni.Headers).String())
+	if len(ni.Targets) != 0 {
+		for i, t := range ni.Targets {
+			s += fmt.Sprintf(" target %d:{url:%s weight:%d headers:%s}", i, t.Url, t.Weight, t.Headers.String())
+		}
+		s += fmt.Sprintf(" balancing:%s", ni.Balancing)
+	}
+	if ni.ClientCertificate != nil {
+		s += fmt.Sprintf(" client_certificate:{certificate_file:%s private_key_file:%s}", ni.ClientCertificate.CertificateFile, ni.ClientCertificate.PrivateKeyFile)
+	}
+	if ni.Proxy != nil {
+		s += fmt.Sprintf(" proxy:{url:%s no_proxy:%v}", redactedURL(ni.Proxy.Url), ni.Proxy.NoProxy)
+	}
+	return s
+}
+
+// redactedURL hides the password of a proxy url
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
 }
 
 type Router struct {
 	Path     string       `json:"path" validate:"router"`
 	Ingress  IngressNode  `json:"ingress" validate:"omitempty"`
 	Outbound OutboundNode `json:"outbound" validate:"required"`
+
+	// Stream text/event-stream responses to the client as they arrive
+	Stream bool `json:"stream,omitempty"`
+
+	Retry          *RetryPolicy    `json:"retry,omitempty" validate:"omitempty"`
+	CircuitBreaker *CircuitBreaker `json:"circuit_breaker,omitempty" validate:"omitempty"`
+	Cache          *Cache          `json:"cache,omitempty" validate:"omitempty"`
+	RateLimit      *RateLimit      `json:"rate_limit,omitempty" validate:"omitempty"`
+	Limits         *Limits         `json:"limits,omitempty" validate:"omitempty"`
+
+	// Translate OpenAI chat completions to the schema of the outbound target and back
+	Translation string `json:"translation,omitempty" validate:"omitempty,oneof=openai_to_anthropic"`
+
+	// Content based routing, the first matching rule is applied
+	Rules []RoutingRule `json:"rules,omitempty" validate:"omitempty,unique=Name,dive"`
+
+	Mirror *Mirror `json:"mirror,omitempty" validate:"omitempty"`
+
+	Fallback *Fallback `json:"fallback,omitempty" validate:"omitempty"`
+}
+
+// Fallback routers, tried in order when the outbound call of the router fails with
+// one of the status codes or, with NetworkErrors, without a response
+type Fallback struct {
+	Routers       []string `json:"routers" validate:"gt=0,unique,dive,router"`
+	StatusCodes   []int    `json:"status_codes" validate:"unique,dive,min=100,max=599"`
+	NetworkErrors bool     `json:"network_errors"`
+}
+
+func (f *Fallback) String() string {
+	return fmt.Sprintf("routers:%v status_codes:%v network_errors:%v", f.Routers, f.StatusCodes, f.NetworkErrors)
+}
+
+// Traffic mirroring. A percentage of the requests is sent again to another router or to one of the
+// targets of the router. The shadow response is discarded and logged as its own transaction
+type Mirror struct {
+	Router     string  `json:"router,omitempty" validate:"required_without=Target,excluded_with=Target,omitempty,router"`
+	Target     string  `json:"target,omitempty" validate:"omitempty,http_url"`
+	Percentage float64 `json:"percentage" validate:"gt=0,lte=100"`
+}
+
+func (m *Mirror) String() string {
+	return fmt.Sprintf("router:%s target:%s percentage:%v", m.Router, m.Target, m.Percentage)
+}
+
+// Content based routing rule. Payload keys are gjson paths in the ingress payload, headers and
+// query are matched on the ingress request. Values use the syntax of the ingress headers and all
+// conditions must match. A matching rule sends the request to one of the targets of the router
+// or to another router
+type RoutingRule struct {
+	Name    string                          `json:"name" validate:"required,alphanumdot"`
+	Payload map[string][]string             `json:"payload,omitempty" validate:"omitempty,dive,keys,required,endkeys,gt=0,dive,required"`
+	Headers protectedheader.ProtectedHeader `json:"headers,omitempty" validate:"omitempty,dive,keys,ascii,excludesall= /()<>@;:\\\"[]?=,endkeys,gt=0,dive,required,ascii"`
+	Query   map[string][]string             `json:"query,omitempty" validate:"omitempty,dive,keys,required,endkeys,gt=0,dive,required"`
+	Target  string                          `json:"target,omitempty" validate:"required_without=Router,excluded_with=Router,omitempty,http_url"`
+	Router  string                          `json:"router,omitempty" validate:"omitempty,router"`
+}
+
+// Requirements compiles the payload, header and query conditions of the rule
+func (rule *RoutingRule) Requirements() (payload, headers, query protectedheader.Requirements, err error) {
+	payload, err = protectedheader.CompileRequirements(protectedheader.ProtectedHeader(rule.Payload))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	canonical := protectedheader.ProtectedHeader{}
+	for header, values := range rule.Headers {
+		canonical[http.CanonicalHeaderKey(header)] = values
+	}
+	headers, err = protectedheader.CompileRequirements(canonical)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	query, err = protectedheader.CompileRequirements(protectedheader.ProtectedHeader(rule.Query))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return payload, headers, query, nil
+}
+
+// Retry policy for the outbound call. Durations are in milliseconds
+type RetryPolicy struct {
+	MaxAttempts    int   `json:"max_attempts" validate:"min=1,max=10"`
+	InitialBackoff int   `json:"initial_backoff" validate:"min=0"`
+	MaxBackoff     int   `json:"max_backoff" validate:"gtefield=InitialBackoff"`
+	Jitter         bool  `json:"jitter"`
+	StatusCodes    []int `json:"status_codes" validate:"unique,dive,min=100,max=599"`
+	NetworkErrors  bool  `json:"network_errors"`
+	Deadline       int   `json:"deadline" validate:"min=0"`
+}
+
+// Circuit breaker for each outbound url. OpenDuration is in milliseconds.
+// StatusCode and Body are returned while the breaker is open
+type CircuitBreaker struct {
+	FailureThreshold int    `json:"failure_threshold" validate:"min=1"`
+	OpenDuration     int    `json:"open_duration" validate:"min=1"`
+	HalfOpenRequests int    `json:"half_open_requests" validate:"min=0"`
+	StatusCode       int    `json:"status_code" validate:"omitempty,min=100,max=599"`
+	Body             string `json:"body" validate:"omitempty,json"`
+}
+
+// Exact-match response cache. Ttl is in seconds. Headers are the outbound
+// headers that are part of the cache key
+type Cache struct {
+	Backend    string   `json:"backend" validate:"oneof=memory disk"`
+	Directory  string   `json:"directory" validate:"required_if=Backend disk"`
+	Ttl        int      `json:"ttl" validate:"min=1"`
+	MaxEntries int      `json:"max_entries" validate:"min=1"`
+	Headers    []string `json:"headers" validate:"unique,dive,ascii,excludesall= /()<>@;:\\\"[]?="`
+}
+
+// Token bucket rate limit. Requests are refilled per second or minute and
+// Burst is the bucket size. Key selects what the limit is counted by
+type RateLimit struct {
+	Requests int    `json:"requests" validate:"min=1"`
+	Per      string `json:"per" validate:"oneof=second minute"`
+	Burst    int    `json:"burst" validate:"min=0"`
+	Key      string `json:"key" validate:"oneof=router ip header session"`
+	Header   string `json:"header" validate:"required_if=Key header,omitempty,ascii,excludesall= /()<>@;:\\\"[]?="`
+}
+
+// Limits of a router. Timeout is in milliseconds for each outbound call,
+// including reading the response. Zero means no limit
+type Limits struct {
+	Timeout          int   `json:"timeout" validate:"min=0"`
+	MaxRequestBytes  int64 `json:"max_request_bytes" validate:"min=0"`
+	MaxResponseBytes int64 `json:"max_response_bytes" validate:"min=0"`
+}
+
+var pathParameter = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)(\.\.\.)?\}`)
+
+// PathParameters returns the names of the {name} and {name...} segments of the path
+func (c *Router) PathParameters() []string {
+	names := []string{}
+	for _, match := range pathParameter.FindAllStringSubmatch(c.Path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// ExpandPathParameters replaces {name} and {name...} in s with the captured values. Unknown names are kept
+func ExpandPathParameters(s string, values map[string]string) string {
+	return pathParameter.ReplaceAllStringFunc(s, func(match string) string {
+		name := pathParameter.FindStringSubmatch(match)[1]
+		if value, exists := values[name]; exists {
+			return value
+		}
+		return match
+	})
 }
 
 // Stringer
@@ -66,5 +347,59 @@ func (r *Router) String() string {
 func (c *Router) Validate() validate.ValidationErrors {
 	// Add map validation as well
 	v := validate.New()
-	return validate.ValidateStruct(v, c)
+	errors := validate.ValidateStruct(v, c)
+
+	// Patterns of the ingress header requirements must compile
+	_, err := protectedheader.CompileRequirements(c.Ingress.Headers)
+	if err != nil {
+		if errors == nil {
+			errors = validate.ValidationErrors{}
+		}
+		errors["Router.Ingress.Headers"] = err.Error()
+	}
+	if c.Ingress.ClientCertificate != nil {
+		_, _, err := c.Ingress.ClientCertificate.Patterns()
+		if err != nil {
+			if errors == nil {
+				errors = validate.ValidationErrors{}
+			}
+			errors["Router.Ingress.ClientCertificate"] = err.Error()
+		}
+	}
+	targets := map[string]struct{}{}
+	for _, t := range c.Outbound.GetTargets() {
+		targets[t.Url] = struct{}{}
+	}
+	for i, rule := range c.Rules {
+		field := fmt.Sprintf("Router.Rules[%d]", i)
+		_, _, _, err := rule.Requirements()
+		if err != nil {
+			if errors == nil {
+				errors = validate.ValidationErrors{}
+			}
+			errors[field] = err.Error()
+			continue
+		}
+		if _, exists := targets[rule.Target]; rule.Target != "" && !exists {
+			if errors == nil {
+				errors = validate.ValidationErrors{}
+			}
+			errors[field+".Target"] = fmt.Sprintf("target:'%s' is not an outbound target of the router", rule.Target)
+		}
+	}
+	if c.Fallback != nil && slices.Contains(c.Fallback.Routers, c.Path) {
+		if errors == nil {
+			errors = validate.ValidationErrors{}
+		}
+		errors["Router.Fallback.Routers"] = fmt.Sprintf("router:'%s' cannot fall back to itself", c.Path)
+	}
+	if c.Mirror != nil && c.Mirror.Target != "" {
+		if _, exists := targets[c.Mirror.Target]; !exists {
+			if errors == nil {
+				errors = validate.ValidationErrors{}
+			}
+			errors["Router.Mirror.Target"] = fmt.Sprintf("target:'%s' is not an outbound target of the router", c.Mirror.Target)
+		}
+	}
+	return errors
 }
@@ -219,3 +219,218 @@ func TestRouterMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestOutboundNodeGetTargets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		node     OutboundNode
+		expected []TargetNode
+	}{
+		{
+			name:     "Url only",
+			node:     OutboundNode{Url: "http://example.com"},
+			expected: []TargetNode{{Url: "http://example.com", Weight: 1}},
+		},
+		{
+			name: "Targets with default weight",
+			node: OutboundNode{
+				Targets: []TargetNode{
+					{Url: "http://a.example.com", Weight: 5},
+					{Url: "http://b.example.com"},
+				},
+			},
+			expected: []TargetNode{
+				{Url: "http://a.example.com", Weight: 5},
+				{Url: "http://b.example.com", Weight: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.node.GetTargets())
+		})
+	}
+}
+
+func TestRouterValidateIngressHeaders(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers protectedheader.ProtectedHeader
+		wantErr bool
+	}{
+		{
+			name:    "Valid pattern",
+			headers: protectedheader.ProtectedHeader{"Api-Key": {"regex:sk-[a-z]+"}},
+		},
+		{
+			name:    "Invalid pattern",
+			headers: protectedheader.ProtectedHeader{"Api-Key": {"regex:(sk"}},
+			wantErr: true,
+		},
+		{
+			name:    "Exact mixed with oneof",
+			headers: protectedheader.ProtectedHeader{"Api-Key": {"sk-a", "oneof:sk-b"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Router{
+				Path:     "/service/standard/",
+				Ingress:  IngressNode{Headers: tc.headers},
+				Outbound: OutboundNode{Url: "https://api.example.com", Endpoint: "/", Headers: protectedheader.ProtectedHeader{}},
+			}
+			errors := r.Validate()
+			_, exists := errors["Router.Ingress.Headers"]
+			assert.Equal(t, tc.wantErr, exists, errors.String())
+		})
+	}
+}
+
+func TestRouterValidateRules(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rule    RoutingRule
+		wantErr string
+	}{
+		{
+			name: "Valid target",
+			rule: RoutingRule{Name: "mini", Payload: map[string][]string{"model": {"gpt-4o-mini"}}, Target: "https://api.example.com"},
+		},
+		{
+			name: "Valid router",
+			rule: RoutingRule{Name: "mini", Headers: protectedheader.ProtectedHeader{"X-Team": {"oneof:research"}}, Router: "/service/mini/"},
+		},
+		{
+			name:    "Invalid pattern",
+			rule:    RoutingRule{Name: "mini", Payload: map[string][]string{"model": {"regex:(gpt"}}, Router: "/service/mini/"},
+			wantErr: "Router.Rules[0]",
+		},
+		{
+			name:    "Unknown target",
+			rule:    RoutingRule{Name: "mini", Target: "https://other.example.com"},
+			wantErr: "Router.Rules[0].Target",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Router{
+				Path:     "/service/standard/",
+				Outbound: OutboundNode{Url: "https://api.example.com", Endpoint: "/", Headers: protectedheader.ProtectedHeader{}},
+				Rules:    []RoutingRule{tc.rule},
+			}
+			errors := r.Validate()
+			if tc.wantErr == "" {
+				assert.Empty(t, errors, errors.String())
+				return
+			}
+			_, exists := errors[tc.wantErr]
+			assert.True(t, exists, errors.String())
+		})
+	}
+}
+
+func TestRouterValidateMirror(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mirror  Mirror
+		wantErr bool
+	}{
+		{name: "Valid router", mirror: Mirror{Router: "/service/shadow/", Percentage: 10}},
+		{name: "Valid target", mirror: Mirror{Target: "https://api.example.com", Percentage: 0.5}},
+		{name: "Router and target", mirror: Mirror{Router: "/service/shadow/", Target: "https://api.example.com", Percentage: 10}, wantErr: true},
+		{name: "Neither router nor target", mirror: Mirror{Percentage: 10}, wantErr: true},
+		{name: "Unknown target", mirror: Mirror{Target: "https://other.example.com", Percentage: 10}, wantErr: true},
+		{name: "Zero percentage", mirror: Mirror{Router: "/service/shadow/"}, wantErr: true},
+		{name: "Above 100 percent", mirror: Mirror{Router: "/service/shadow/", Percentage: 101}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mirror := tc.mirror
+			r := Router{
+				Path:     "/service/standard/",
+				Outbound: OutboundNode{Url: "https://api.example.com", Endpoint: "/", Headers: protectedheader.ProtectedHeader{}},
+				Mirror:   &mirror,
+			}
+			errors := r.Validate()
+			if !tc.wantErr {
+				assert.Empty(t, errors, errors.String())
+				return
+			}
+			assert.NotEmpty(t, errors)
+		})
+	}
+}
+
+func TestRouterValidateFallback(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fallback Fallback
+		wantErr  bool
+	}{
+		{name: "Valid", fallback: Fallback{Routers: []string{"/service/backup/", "/service/last/"}, StatusCodes: []int{429, 503}, NetworkErrors: true}},
+		{name: "No routers", fallback: Fallback{StatusCodes: []int{503}}, wantErr: true},
+		{name: "Duplicate routers", fallback: Fallback{Routers: []string{"/service/backup/", "/service/backup/"}}, wantErr: true},
+		{name: "Itself", fallback: Fallback{Routers: []string{"/service/standard/"}}, wantErr: true},
+		{name: "Invalid status code", fallback: Fallback{Routers: []string{"/service/backup/"}, StatusCodes: []int{700}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fallback := tc.fallback
+			r := Router{
+				Path:     "/service/standard/",
+				Outbound: OutboundNode{Url: "https://api.example.com", Endpoint: "/", Headers: protectedheader.ProtectedHeader{}},
+				Fallback: &fallback,
+			}
+			errors := r.Validate()
+			if !tc.wantErr {
+				assert.Empty(t, errors, errors.String())
+				return
+			}
+			assert.NotEmpty(t, errors)
+		})
+	}
+}
+
+func TestRouterValidatePath(t *testing.T) {
+	testCases := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: "/service/standard/"},
+		{path: "/openai/deployments/{deployment}/chat/completions"},
+		{path: "/openai/deployments/{deployment}/"},
+		{path: "/files/{rest...}"},
+		{path: "/service/standard", wantErr: true},
+		{path: "/files/{rest...}/more", wantErr: true},
+		{path: "/a/{name}/b/{name}", wantErr: true},
+		{path: "/a/{1name}/", wantErr: true},
+		{path: "/a/pre{name}/", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			r := Router{
+				Path:     tc.path,
+				Outbound: OutboundNode{Url: "https://api.example.com", Endpoint: "/", Headers: protectedheader.ProtectedHeader{}},
+			}
+			errors := r.Validate()
+			_, exists := errors["Router.Path"]
+			assert.Equal(t, tc.wantErr, exists, errors.String())
+		})
+	}
+}
+
+func TestPathParameters(t *testing.T) {
+	r := Router{Path: "/openai/deployments/{deployment}/files/{rest...}"}
+	assert.Equal(t, []string{"deployment", "rest"}, r.PathParameters())
+
+	values := map[string]string{"deployment": "gpt-4o", "rest": "a/b"}
+	assert.Equal(t, "/openai/deployments/gpt-4o/files/a/b", ExpandPathParameters(r.Path, values))
+	assert.Equal(t, "/deployments/gpt-4o/{unknown}", ExpandPathParameters("/deployments/{deployment}/{unknown}", values))
+}
@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// W3C trace context and OTLP/HTTP json export of spans
+
+const (
+	TRACEPARENT_HEADER = "traceparent"
+	TRACESTATE_HEADER  = "tracestate"
+
+	flagSampled = 0x01
+)
+
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2
+	SPAN_KIND_CLIENT   = 3
+)
+
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string
+}
+
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+func (sc SpanContext) SpanIDString() string {
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the version 00 traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceIDString(), sc.SpanIDString(), sc.Flags)
+}
+
+// Child returns a new span in the same trace
+func (sc SpanContext) Child() SpanContext {
+	child := sc
+	rand.Read(child.SpanID[:])
+	return child
+}
+
+// NewTrace starts a new sampled trace
+func NewTrace() SpanContext {
+	sc := SpanContext{Flags: flagSampled}
+	rand.Read(sc.TraceID[:])
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// Parse reads the traceparent and tracestate header values. Future versions
+// are parsed as version 00, as the specification requires
+func Parse(traceparent string, tracestate string) (SpanContext, bool) {
+	traceparent = strings.TrimSpace(traceparent)
+	if len(traceparent) < 55 || (len(traceparent) > 55 && traceparent[55] != '-') {
+		return SpanContext{}, false
+	}
+	parts := strings.Split(traceparent[:55], "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(traceparent) != 55) {
+		return SpanContext{}, false
+	}
+	for _, part := range parts {
+		if strings.ToLower(part) != part {
+			return SpanContext{}, false
+		}
+	}
+
+	sc := SpanContext{State: strings.TrimSpace(tracestate)}
+	version := []byte{0}
+	flags := []byte{0}
+	if _, err := hex.Decode(version, []byte(parts[0])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Carrier is implemented by http.Header and nats.Header
+type Carrier interface {
+	Set(key string, value string)
+}
+
+// Inject sets the trace context headers
+func Inject(h Carrier, sc SpanContext) {
+	h.Set(TRACEPARENT_HEADER, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TRACESTATE_HEADER, sc.State)
+	}
+}
+
+type Span struct {
+	Name         string
+	Kind         int
+	Context      SpanContext
+	ParentSpanID [8]byte
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        bool
+}
+
+// ----------- OTLP/HTTP json -----------------
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func attributes(m map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kv := []otlpKeyValue{}
+	for _, key := range keys {
+		kv = append(kv, otlpKeyValue{Key: key, Value: otlpValue{StringValue: m[key]}})
+	}
+	return kv
+}
+
+// Marshal builds the OTLP/HTTP json export request body
+func Marshal(serviceName string, spans []Span) ([]byte, error) {
+	scope := otlpScopeSpans{Spans: []otlpSpan{}}
+	scope.Scope.Name = "gecholog"
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.Context.TraceIDString(),
+			SpanID:            s.Context.SpanIDString(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: fmt.Sprintf("%d", s.Start.UnixNano()),
+			EndTimeUnixNano:   fmt.Sprintf("%d", s.End.UnixNano()),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.ParentSpanID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		if s.Error {
+			span.Status.Code = 2 // STATUS_CODE_ERROR
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = attributes(map[string]string{"service.name": serviceName})
+	return json.Marshal(&otlpTraces{ResourceSpans: []otlpResourceSpans{resource}})
+}
+
+// Exporter posts spans to an OTLP/HTTP traces endpoint, for example http://localhost:4318/v1/traces
+type Exporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+func NewExporter(endpoint string, serviceName string, timeout time.Duration) *Exporter {
+	return &Exporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (e *Exporter) Export(ctx context.Context, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := Marshal(e.serviceName, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp export failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
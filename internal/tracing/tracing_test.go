@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		valid       bool
+		sampled     bool
+	}{
+		{name: "sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{name: "not sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{name: "future version with extra fields", traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true, sampled: true},
+		{name: "version 00 with extra fields", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "version ff", traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "zero trace id", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span id", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "uppercase", traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "not hex", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01"},
+		{name: "short", traceparent: "00-4bf92f3577b34da6-00f067aa0ba902b7-01"},
+		{name: "empty", traceparent: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, valid := Parse(test.traceparent, " vendor=value ")
+			assert.Equal(t, test.valid, valid)
+			if !valid {
+				return
+			}
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceIDString())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanIDString())
+			assert.Equal(t, test.sampled, sc.Sampled())
+			assert.Equal(t, "vendor=value", sc.State)
+		})
+	}
+}
+
+func TestChildAndInject(t *testing.T) {
+	parent := NewTrace()
+	assert.True(t, parent.IsValid())
+	assert.True(t, parent.Sampled())
+
+	child := parent.Child()
+	assert.Equal(t, parent.TraceID, child.TraceID)
+	assert.NotEqual(t, parent.SpanID, child.SpanID)
+
+	child.State = "vendor=value"
+	h := http.Header{}
+	Inject(h, child)
+	parsed, valid := Parse(h.Get("traceparent"), h.Get("tracestate"))
+	assert.True(t, valid)
+	assert.Equal(t, child, parsed)
+}
+
+func TestExport(t *testing.T) {
+	received := []byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	root, _ := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "")
+	child := root.Child()
+	start := time.Unix(1700000000, 0)
+
+	e := NewExporter(server.URL+"/v1/traces", "gl", time.Second)
+	err := e.Export(context.Background(), []Span{
+		{Name: "root", Kind: SPAN_KIND_SERVER, Context: root, Start: start, End: start.Add(time.Second), Attributes: map[string]string{"b": "2", "a": "1"}},
+		{Name: "child", Kind: SPAN_KIND_CLIENT, Context: child, ParentSpanID: root.SpanID, Start: start, End: start.Add(time.Millisecond), Error: true},
+	})
+	assert.NoError(t, err)
+
+	body := struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}{}
+	assert.NoError(t, json.Unmarshal(received, &body))
+	assert.Equal(t, "service.name", body.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "gl", body.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "", spans[0].ParentSpanID)
+	assert.Equal(t, "1700000000000000000", spans[0].StartTimeUnixNano)
+	assert.Equal(t, "a", spans[0].Attributes[0].Key)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	assert.Equal(t, 2, spans[1].Status.Code)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	err = NewExporter(failing.URL, "gl", time.Second).Export(context.Background(), []Span{{Name: "root", Context: root}})
+	assert.ErrorContains(t, err, "400")
+}
@@ -0,0 +1,403 @@
+package translate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Used when the OpenAI request has no max_tokens, the Messages API requires it
+const DEFAULT_MAX_TOKENS = 4096
+
+var ErrStreaming = errors.New("streaming is not supported")
+
+type openAIRequest struct {
+	Model               string          `json:"model"`
+	Messages            []openAIMessage `json:"messages"`
+	MaxTokens           int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	Stop                json.RawMessage `json:"stop,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	Tools               []openAITool    `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage `json:"tool_choice,omitempty"`
+	User                string          `json:"user,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL struct {
+		Url string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    map[string]string  `json:"tool_choice,omitempty"`
+	Metadata      map[string]string  `json:"metadata,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *anthropicSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// text returns the content of a message as plain text
+func (m openAIMessage) text() (string, error) {
+	parts, err := m.parts()
+	if err != nil {
+		return "", err
+	}
+	texts := []string{}
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// parts returns the content of a message as content parts. String content is a single text part
+func (m openAIMessage) parts() ([]openAIContentPart, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return nil, nil
+	}
+	var s string
+	if json.Unmarshal(m.Content, &s) == nil {
+		return []openAIContentPart{{Type: "text", Text: s}}, nil
+	}
+	parts := []openAIContentPart{}
+	err := json.Unmarshal(m.Content, &parts)
+	if err != nil {
+		return nil, fmt.Errorf("message content: %v", err)
+	}
+	return parts, nil
+}
+
+func imageBlock(imageURL string) anthropicBlock {
+	// data:image/png;base64,....
+	if data, found := strings.CutPrefix(imageURL, "data:"); found {
+		mediaType, encoded, _ := strings.Cut(data, ";base64,")
+		return anthropicBlock{Type: "image", Source: &anthropicSource{Type: "base64", MediaType: mediaType, Data: encoded}}
+	}
+	return anthropicBlock{Type: "image", Source: &anthropicSource{Type: "url", Url: imageURL}}
+}
+
+// appendMessage adds the blocks to the last message if it has the same role, the Messages API requires alternating roles
+func appendMessage(messages []anthropicMessage, role string, blocks []anthropicBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if len(messages) != 0 && messages[len(messages)-1].Role == role {
+		messages[len(messages)-1].Content = append(messages[len(messages)-1].Content, blocks...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// OpenAIToAnthropicRequest translates an OpenAI chat completions request to an Anthropic Messages request
+func OpenAIToAnthropicRequest(payload []byte) ([]byte, error) {
+	var in openAIRequest
+	err := json.Unmarshal(payload, &in)
+	if err != nil {
+		return nil, err
+	}
+	if in.Stream {
+		return nil, ErrStreaming
+	}
+
+	out := anthropicRequest{
+		Model:       in.Model,
+		Messages:    []anthropicMessage{},
+		MaxTokens:   DEFAULT_MAX_TOKENS,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+	}
+	if in.MaxCompletionTokens != 0 {
+		out.MaxTokens = in.MaxCompletionTokens
+	}
+	if in.MaxTokens != 0 {
+		out.MaxTokens = in.MaxTokens
+	}
+	if in.User != "" {
+		out.Metadata = map[string]string{"user_id": in.User}
+	}
+
+	system := []string{}
+	for _, m := range in.Messages {
+		switch m.Role {
+		case "system", "developer":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			system = append(system, text)
+		case "user":
+			parts, err := m.parts()
+			if err != nil {
+				return nil, err
+			}
+			blocks := []anthropicBlock{}
+			for _, part := range parts {
+				switch part.Type {
+				case "text":
+					blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+				case "image_url":
+					blocks = append(blocks, imageBlock(part.ImageURL.Url))
+				default:
+					return nil, fmt.Errorf("content type %s is not supported", part.Type)
+				}
+			}
+			out.Messages = appendMessage(out.Messages, "user", blocks)
+		case "assistant":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			blocks := []anthropicBlock{}
+			if text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					return nil, fmt.Errorf("tool call %s: arguments not a valid json", call.ID)
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+			out.Messages = appendMessage(out.Messages, "assistant", blocks)
+		case "tool":
+			text, err := m.text()
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendMessage(out.Messages, "user", []anthropicBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: text}})
+		default:
+			return nil, fmt.Errorf("role %s is not supported", m.Role)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	if len(in.Stop) != 0 {
+		var stop string
+		if json.Unmarshal(in.Stop, &stop) == nil {
+			out.StopSequences = []string{stop}
+		} else if err := json.Unmarshal(in.Stop, &out.StopSequences); err != nil {
+			return nil, fmt.Errorf("stop: %v", err)
+		}
+	}
+
+	for _, tool := range in.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+
+	if len(in.ToolChoice) != 0 {
+		var choice string
+		if json.Unmarshal(in.ToolChoice, &choice) == nil {
+			switch choice {
+			case "auto":
+				out.ToolChoice = map[string]string{"type": "auto"}
+			case "required":
+				out.ToolChoice = map[string]string{"type": "any"}
+			case "none":
+				out.ToolChoice = map[string]string{"type": "none"}
+			}
+		} else {
+			var named openAITool
+			if err := json.Unmarshal(in.ToolChoice, &named); err != nil {
+				return nil, fmt.Errorf("tool_choice: %v", err)
+			}
+			out.ToolChoice = map[string]string{"type": "tool", "name": named.Function.Name}
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   openAIUsage    `json:"usage"`
+}
+
+type openAIChoice struct {
+	Index   int `json:"index"`
+	Message struct {
+		Role      string           `json:"role"`
+		Content   *string          `json:"content"`
+		ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+	} `json:"message"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+type openAIError struct {
+	Error struct {
+		Message string  `json:"message"`
+		Type    string  `json:"type"`
+		Param   *string `json:"param"`
+		Code    *string `json:"code"`
+	} `json:"error"`
+}
+
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// AnthropicToOpenAIResponse translates an Anthropic Messages response or error to an OpenAI chat completions response or error
+func AnthropicToOpenAIResponse(payload []byte, now time.Time) ([]byte, error) {
+	var in anthropicResponse
+	err := json.Unmarshal(payload, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Type == "error" || in.Error != nil {
+		out := openAIError{}
+		if in.Error != nil {
+			out.Error.Type = in.Error.Type
+			out.Error.Message = in.Error.Message
+		}
+		return json.Marshal(out)
+	}
+	if in.Type != "message" {
+		return nil, fmt.Errorf("type %s is not a message", in.Type)
+	}
+
+	choice := openAIChoice{FinishReason: finishReason(in.StopReason)}
+	choice.Message.Role = "assistant"
+	texts := []string{}
+	for _, block := range in.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			call := openAIToolCall{ID: block.ID, Type: "function"}
+			call.Function.Name = block.Name
+			call.Function.Arguments = string(block.Input)
+			choice.Message.ToolCalls = append(choice.Message.ToolCalls, call)
+		}
+	}
+	if len(texts) != 0 {
+		text := strings.Join(texts, "")
+		choice.Message.Content = &text
+	}
+
+	out := openAIResponse{
+		ID:      in.ID,
+		Object:  "chat.completion",
+		Created: now.Unix(),
+		Model:   in.Model,
+		Choices: []openAIChoice{choice},
+	}
+	out.Usage.PromptTokens = in.Usage.InputTokens + in.Usage.CacheCreationInputTokens + in.Usage.CacheReadInputTokens
+	out.Usage.CompletionTokens = in.Usage.OutputTokens
+	out.Usage.TotalTokens = out.Usage.PromptTokens + out.Usage.CompletionTokens
+	out.Usage.PromptTokensDetails.CachedTokens = in.Usage.CacheReadInputTokens
+	return json.Marshal(out)
+}
@@ -0,0 +1,111 @@
+package translate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAIToAnthropicRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "System and user",
+			payload:  `{"model":"claude-sonnet","max_tokens":256,"temperature":0.2,"stop":"END","user":"alice","messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hello"}]}`,
+			expected: `{"model":"claude-sonnet","system":"Be brief","messages":[{"role":"user","content":[{"type":"text","text":"Hello"}]}],"max_tokens":256,"temperature":0.2,"stop_sequences":["END"],"metadata":{"user_id":"alice"}}`,
+		},
+		{
+			name:     "Default max tokens and image",
+			payload:  `{"model":"claude-sonnet","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0"}}]}]}`,
+			expected: `{"model":"claude-sonnet","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0"}}]}],"max_tokens":4096}`,
+		},
+		{
+			name: "Tools",
+			payload: `{"model":"claude-sonnet","max_completion_tokens":100,"tool_choice":"required",
+				"tools":[{"type":"function","function":{"name":"weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}],
+				"messages":[
+					{"role":"user","content":"Weather in Oslo?"},
+					{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]},
+					{"role":"tool","tool_call_id":"call_1","content":"Sunny"},
+					{"role":"user","content":"Thanks"}
+				]}`,
+			expected: `{"model":"claude-sonnet","messages":[
+				{"role":"user","content":[{"type":"text","text":"Weather in Oslo?"}]},
+				{"role":"assistant","content":[{"type":"tool_use","id":"call_1","name":"weather","input":{"city":"Oslo"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"Sunny"},{"type":"text","text":"Thanks"}]}
+			],"max_tokens":100,
+			"tools":[{"name":"weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+			"tool_choice":{"type":"any"}}`,
+		},
+		{
+			name:    "Streaming",
+			payload: `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown role",
+			payload: `{"model":"claude-sonnet","messages":[{"role":"function","content":"Hello"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := OpenAIToAnthropicRequest([]byte(tc.payload))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(result))
+		})
+	}
+}
+
+func TestAnthropicToOpenAIResponse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := []struct {
+		name     string
+		payload  string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "Text",
+			payload:  `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2,"cache_read_input_tokens":4}}`,
+			expected: `{"id":"msg_1","object":"chat.completion","created":1700000000,"model":"claude-sonnet","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":14,"completion_tokens":2,"total_tokens":16,"prompt_tokens_details":{"cached_tokens":4}}}`,
+		},
+		{
+			name:     "Tool use",
+			payload:  `{"id":"msg_2","type":"message","role":"assistant","model":"claude-sonnet","content":[{"type":"tool_use","id":"toolu_1","name":"weather","input":{"city":"Oslo"}}],"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":5}}`,
+			expected: `{"id":"msg_2","object":"chat.completion","created":1700000000,"model":"claude-sonnet","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"toolu_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25,"prompt_tokens_details":{"cached_tokens":0}}}`,
+		},
+		{
+			name:     "Error",
+			payload:  `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			expected: `{"error":{"message":"Overloaded","type":"overloaded_error","param":null,"code":null}}`,
+		},
+		{
+			name:    "Not a message",
+			payload: `{"error":"failure making request"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := AnthropicToOpenAIResponse([]byte(tc.payload), now)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(result))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package validate
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 
@@ -29,11 +30,75 @@ func isAlphaNumUnderscore(fl validator.FieldLevel) bool {
 }
 
 func isEndpoint(fl validator.FieldLevel) bool {
-	return regexp.MustCompile(`^[A-Za-z0-9?=./_-]+$`).MatchString(fl.Field().String()) && !strings.Contains(fl.Field().String(), "//") && !strings.Contains(fl.Field().String(), "..") && !strings.Contains(fl.Field().String(), "??") && !strings.Contains(fl.Field().String(), "./") && !strings.Contains(fl.Field().String(), "/=") && !strings.Contains(fl.Field().String(), "=/") && !strings.Contains(fl.Field().String(), "/?") && !strings.Contains(fl.Field().String(), "?/")
+	return regexp.MustCompile(`^[A-Za-z0-9?=./_{}-]+$`).MatchString(fl.Field().String()) && !strings.Contains(fl.Field().String(), "//") && !strings.Contains(fl.Field().String(), "..") && !strings.Contains(fl.Field().String(), "??") && !strings.Contains(fl.Field().String(), "./") && !strings.Contains(fl.Field().String(), "/=") && !strings.Contains(fl.Field().String(), "=/") && !strings.Contains(fl.Field().String(), "/?") && !strings.Contains(fl.Field().String(), "?/")
 }
 
+var (
+	routerSegment         = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	routerTemplateSegment = regexp.MustCompile(`^\{[a-zA-Z_][a-zA-Z0-9_]*(\.\.\.)?\}$`)
+)
+
+// isRouter allows paths of alphanumeric segments ending in /. Segments can be {name} templates,
+// the last one can be a {name...} wildcard. Paths with templates do not have to end in /
 func isRouter(fl validator.FieldLevel) bool {
-	return regexp.MustCompile(`^[a-zA-Z0-9/]+$`).MatchString(fl.Field().String()) && (strings.HasSuffix(fl.Field().String(), "/") && strings.HasPrefix(fl.Field().String(), "/"))
+	path := fl.Field().String()
+	if path == "/" {
+		return true
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	templated := false
+	names := map[string]struct{}{}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch {
+		case segment == "" && last:
+		case routerSegment.MatchString(segment):
+		case routerTemplateSegment.MatchString(segment):
+			wildcard := strings.HasSuffix(segment, "...}")
+			if wildcard && !last {
+				return false
+			}
+			name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+			if _, exists := names[name]; exists {
+				return false
+			}
+			names[name] = struct{}{}
+			templated = true
+		default:
+			return false
+		}
+	}
+	return templated || strings.HasSuffix(path, "/")
+}
+
+// isUniqueNonEmpty checks that the non empty values of the struct field named in the param are unique in a slice
+func isUniqueNonEmpty(fl validator.FieldLevel) bool {
+	field := fl.Field()
+	if field.Kind() != reflect.Slice && field.Kind() != reflect.Array {
+		return false
+	}
+	seen := map[any]struct{}{}
+	for i := 0; i < field.Len(); i++ {
+		element := reflect.Indirect(field.Index(i))
+		if element.Kind() != reflect.Struct {
+			return false
+		}
+		value := element.FieldByName(fl.Param())
+		if !value.IsValid() {
+			return false
+		}
+		if value.IsZero() {
+			continue
+		}
+		if _, exists := seen[value.Interface()]; exists {
+			return false
+		}
+		seen[value.Interface()] = struct{}{}
+	}
+	return true
 }
 
 func New() *validator.Validate {
@@ -42,6 +107,7 @@ func New() *validator.Validate {
 	validate.RegisterValidation("alphanumunderscore", isAlphaNumUnderscore)
 	validate.RegisterValidation("router", isRouter)
 	validate.RegisterValidation("endpoint", isEndpoint)
+	validate.RegisterValidation("uniquenonempty", isUniqueNonEmpty)
 	//	validate.RegisterValidation("httpheader", isHTTPHeader)
 
 	return validate